@@ -11,13 +11,18 @@ import (
 	"time"
 
 	"ai-kms/internal/api"
+	"ai-kms/internal/chunking"
 	"ai-kms/internal/config"
 	"ai-kms/internal/db"
+	"ai-kms/internal/local"
+	"ai-kms/internal/models"
 	"ai-kms/internal/openai"
+	"ai-kms/internal/prompts"
 	"ai-kms/internal/repository"
 	"ai-kms/internal/services"
 	"ai-kms/internal/services/collaboration"
 	"ai-kms/internal/telemetry"
+	"ai-kms/internal/tokenizer"
 )
 
 /*
@@ -62,24 +67,133 @@ func main() {
 	}
 	defer database.Close()
 
-	// Initialize OpenAI client
-	openaiClient := openai.NewClient(cfg.OpenAIAPIKey)
-	log.Println("✓ OpenAI client initialized")
+	// Initialize AI provider
+	// Learning: Services only see the Embedder/ChatModel interfaces, so the
+	// OpenAI client and the offline local client are interchangeable
+	var provider interface {
+		services.Embedder
+		services.ChatModel
+	}
+	var migrationEmbedder services.Embedder // Embeds into EMBEDDING_MIGRATE_TO, if set
+	switch cfg.LLMProvider {
+	case "local":
+		dims, err := local.ModelDimensions(cfg.EmbeddingModel)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		provider = local.NewClient(dims)
+		if cfg.EmbeddingTarget != "" {
+			targetDims, err := local.ModelDimensions(cfg.EmbeddingTarget)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			migrationEmbedder = local.NewClient(targetDims)
+		}
+		log.Println("✓ Local AI provider initialized (offline mode)")
+	default:
+		// Learning: One rate limiter shared by every embedding worker and request
+		openaiClient := openai.NewClient(cfg.OpenAIAPIKey)
+		openaiClient.BaseURL = cfg.OpenAIBaseURL
+		openaiClient.MaxRetries = cfg.OpenAIMaxRetries
+		openaiClient.EmbeddingModel = cfg.EmbeddingModel
+		openaiClient.ChatModel = cfg.ChatModel
+		openaiClient.Temperature = cfg.ChatTemperature
+		openaiClient.MaxTokens = cfg.ChatMaxTokens
+		openaiClient.SetRateLimiter(openai.NewRateLimiter(cfg.OpenAIRequestsPerMinute, cfg.EmbeddingWorkers))
+		provider = openaiClient
+		if cfg.EmbeddingTarget != "" {
+			migrationEmbedder = openaiClient.WithEmbeddingModel(cfg.EmbeddingTarget)
+		}
+		log.Printf("✓ OpenAI client initialized (%s, chat: %s, embeddings: %s)", cfg.OpenAIBaseURL, cfg.ChatModel, cfg.EmbeddingModel)
+	}
+
+	// Load prompt templates
+	// Learning: Templates are validated here, so a broken prompt fails startup
+	promptTemplates, err := prompts.Load(cfg.PromptsDir)
+	if err != nil {
+		log.Fatalf("❌ Failed to load prompt templates: %v", err)
+	}
+	if cfg.PromptsDir != "" {
+		log.Printf("✓ Prompt templates loaded from %s (sets: %v)", cfg.PromptsDir, promptTemplates.Sets())
+	}
+
+	// Initialize tokenizer
+	// Learning: Chunk sizes and prompt budgets are counted in real model tokens
+	// when the cl100k_base ranks are available, and estimated otherwise
+	var tok tokenizer.Tokenizer = tokenizer.Estimator{}
+	if cfg.LLMProvider != "local" || cfg.TokenizerBPEFile != "" {
+		if bpe, err := tokenizer.CL100K(cfg.TokenizerBPEFile); err != nil {
+			log.Printf("⚠️  Failed to load tokenizer: %v (estimating token counts)", err)
+		} else {
+			tok = bpe
+			log.Println("✓ cl100k_base tokenizer loaded")
+		}
+	}
 
 	// Initialize repositories
 	docRepo := repository.NewDocumentRepository(database.DB)
-	embRepo := repository.NewEmbeddingRepository(database.DB)
+	embRepo := repository.NewEmbeddingRepository(database.DB, cfg.EmbeddingModel)
+	embRepo.SetIndexConfig(models.VectorIndexConfig{
+		Type:           cfg.VectorIndexType,
+		M:              cfg.HNSWM,
+		EFConstruction: cfg.HNSWEFConstruction,
+		Lists:          cfg.IVFFlatLists,
+		Search: models.VectorSearchParams{
+			EFSearch: cfg.HNSWEFSearch,
+			Probes:   cfg.IVFFlatProbes,
+		},
+	})
+	if err := embRepo.EnsureVectorIndexes(context.Background()); err != nil {
+		log.Fatalf("❌ Failed to create vector indexes: %v", err)
+	}
+	jobRepo := repository.NewJobRepository(database.DB)
+	summaryRepo := repository.NewSummaryRepository(database.DB)
+
+	// Cache embeddings by content hash
+	// Learning: Every service embeds through the same cache instance, so a
+	// query embedded by search is free for RAG, and unchanged chunks are free
+	// when a document is re-embedded - see services/embedding_cache.go
+	var embedder services.Embedder = provider
+	if cfg.EmbeddingCache != "off" {
+		var cacheRepo services.EmbeddingCacheRepository
+		if cfg.EmbeddingCache == "postgres" {
+			cacheRepo = repository.NewEmbeddingCacheRepository(database.DB)
+		}
+		embedder = services.NewEmbeddingCache(provider, cfg.EmbeddingModel, cacheRepo, cfg.EmbeddingCacheSize)
+		if migrationEmbedder != nil {
+			migrationEmbedder = services.NewEmbeddingCache(migrationEmbedder, cfg.EmbeddingTarget, cacheRepo, cfg.EmbeddingCacheSize)
+		}
+		log.Printf("✓ Embedding cache enabled (%s, %d entries in memory)", cfg.EmbeddingCache, cfg.EmbeddingCacheSize)
+	}
+
+	// Learning: The same chunking is used for search and for summarization
+	chunkOpts := chunking.Options{
+		MaxTokens:      cfg.ChunkMaxTokens,
+		OverlapTokens:  cfg.ChunkOverlapTokens,
+		CountTokens:    tok.Count,
+		TruncateTokens: tok.Truncate,
+	}
 
 	// Initialize embedding service with worker pool
 	// Learning: This creates the worker pool but doesn't start it yet
 	embService := services.NewEmbeddingService(
-		openaiClient,
+		embedder,
 		embRepo,
 		docRepo,
+		jobRepo,
 		cfg.EmbeddingWorkers,
-		cfg.EmbeddingQueueSize,
+		cfg.EmbeddingBatchSize,
+		cfg.EmbeddingMaxAttempts,
+		chunkOpts,
 	)
 
+	// Learning: A migration fills a second model in the background while
+	// searches keep using EMBEDDING_MODEL - see services/embedding_migration.go
+	if migrationEmbedder != nil {
+		embService.SetMigrationTarget(migrationEmbedder, embRepo.ForModel(cfg.EmbeddingTarget))
+		log.Printf("✓ Embedding migration enabled (%s → %s)", cfg.EmbeddingModel, cfg.EmbeddingTarget)
+	}
+
 	// Start the worker pool
 	// Learning: This spawns goroutines that will process jobs concurrently
 	embService.Start()
@@ -92,6 +206,19 @@ func main() {
 	yjsRepo := repository.NewYjsRepository(database.DB)
 	sessionManager.SetYjsRepository(yjsRepo)
 
+	// Write collaborative edits back to documents.content so search and RAG see them
+	syncInterval := time.Duration(cfg.CollabSyncInterval) * time.Second
+	sessionManager.SetContentSync(docRepo, embService, syncInterval)
+	log.Printf("✓ Collaborative edits written back every %s", syncInterval)
+	sessionManager.SetCompaction(cfg.CollabCompactUpdates, cfg.CollabCompactBytes)
+	if cfg.CollabCompactUpdates > 0 || cfg.CollabCompactBytes > 0 {
+		log.Printf("✓ Yjs update logs compacted past %d updates or %d bytes (0 = no limit)", cfg.CollabCompactUpdates, cfg.CollabCompactBytes)
+	}
+
+	// Version history: snapshots on every API edit and periodically from live sessions
+	versionService := services.NewVersionService(docRepo, repository.NewVersionRepository(database.DB))
+	sessionManager.SetVersioning(versionService, time.Duration(cfg.CollabVersionInterval)*time.Second)
+
 	sessionManager.Start()
 
 	// Initialize WebSocket handler
@@ -99,13 +226,47 @@ func main() {
 
 	// Initialize RAG service for AI features
 	// Learning: RAG combines retrieval (semantic search) with generation (LLM)
-	ragService := services.NewRAGService(openaiClient, embRepo)
+	ragService := services.NewRAGService(
+		embedder,
+		provider,
+		embRepo,
+		summaryRepo,
+		services.NewPromptBuilder(tok, cfg.PromptMaxTokens, promptTemplates),
+		chunkOpts,
+	)
+
+	// Learning: Re-ranking trades one extra model call (or a cheap lexical
+	// pass) for better, less repetitive context
+	switch cfg.Reranker {
+	case "llm":
+		ragService.SetRerankStage(services.NewRerankStage(services.NewLLMReranker(provider, promptTemplates), cfg.RerankOverfetch, cfg.MMRLambda))
+		log.Println("✓ RAG re-ranking enabled (llm)")
+	case "lexical":
+		ragService.SetRerankStage(services.NewRerankStage(services.LexicalReranker{}, cfg.RerankOverfetch, cfg.MMRLambda))
+		log.Println("✓ RAG re-ranking enabled (lexical)")
+	}
+
+	// Initialize conversations (multi-turn RAG with persisted history)
+	conversationService := services.NewConversationService(ragService, repository.NewConversationRepository(database.DB))
+
+	// Initialize search service
+	// Learning: Hybrid search fuses vector and full-text rankings
+	searchService := services.NewSearchService(
+		embedder,
+		embRepo,
+		services.SearchMode(cfg.SearchDefaultMode),
+		models.HybridWeights{
+			Semantic: cfg.HybridSemanticWeight,
+			Keyword:  cfg.HybridKeywordWeight,
+			K:        cfg.HybridRRFK,
+		},
+	)
 
 	// Initialize knowledge graph repository
 	linkRepo := repository.NewLinkRepository(database.DB)
 
 	// Initialize handlers with dependency injection
-	handler := api.NewHandler(docRepo, embRepo, embService, wsHandler, ragService, openaiClient, linkRepo)
+	handler := api.NewHandler(docRepo, embRepo, embService, wsHandler, ragService, searchService, linkRepo, conversationService, versionService)
 
 	// Setup routes
 	router := api.SetupRoutes(handler)
@@ -130,7 +291,16 @@ func main() {
 		log.Printf("   GET    /api/documents/:id       - Get document")
 		log.Printf("   PUT    /api/documents/:id       - Update document")
 		log.Printf("   DELETE /api/documents/:id       - Delete document (soft)")
+		log.Printf("   GET    /api/documents/:id/versions - Version history")
+		log.Printf("   GET    /api/documents/:id/versions/diff?from=&to= - Diff two versions")
+		log.Printf("   POST   /api/documents/:id/versions/:version/restore - Restore a version")
+		log.Printf("   GET    /api/documents/:id/presence - Users editing a document")
 		log.Printf("   POST   /api/documents/:id/embed - Generate embeddings")
+		log.Printf("   GET    /api/documents/:id/embedding-status - Is the document searchable?")
+		log.Printf("   GET    /api/jobs/:id            - Embedding job status")
+		log.Printf("   GET    /api/admin/embeddings/migration - Embedding model migration progress")
+		log.Printf("   GET    /api/admin/embeddings/index - Vector index stats")
+		log.Printf("   GET    /api/admin/embeddings/cache - Embedding cache hit/miss counters")
 		log.Println()
 
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
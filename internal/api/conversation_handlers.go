@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ai-kms/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// Conversation endpoints
+
+// CreateConversation starts a conversation, answering the first message if one is given
+func (h *Handler) CreateConversation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title     string `json:"title,omitempty"`
+		Message   string `json:"message,omitempty"`
+		MaxChunks int    `json:"max_chunks,omitempty"`
+		generationOptions
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, err := req.apply(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conv, err := h.conversations.Create(ctx, req.Title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"conversation": conv,
+	}
+
+	if req.Message != "" {
+		if req.MaxChunks == 0 {
+			req.MaxChunks = 5
+		}
+		reply, err := h.conversations.Reply(ctx, conv.ID, req.Message, req.MaxChunks)
+		if err != nil {
+			http.Error(w, err.Error(), aiErrorStatus(err))
+			return
+		}
+		response["reply"] = replyResponse(reply)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// SendConversationMessage asks a (follow-up) question in an existing conversation
+func (h *Handler) SendConversationMessage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		Message   string `json:"message"`
+		MaxChunks int    `json:"max_chunks,omitempty"`
+		generationOptions
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+	if req.MaxChunks == 0 {
+		req.MaxChunks = 5
+	}
+
+	ctx, err := req.apply(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reply, err := h.conversations.Reply(ctx, id, req.Message, req.MaxChunks)
+	if errors.Is(err, services.ErrConversationNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), aiErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(replyResponse(reply))
+}
+
+// ListConversations lists conversations, most recently active first
+func (h *Handler) ListConversations(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	offset := 0
+
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && parsed >= 0 {
+		offset = parsed
+	}
+
+	convs, err := h.conversations.List(r.Context(), limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conversations": convs,
+		"limit":         limit,
+		"offset":        offset,
+	})
+}
+
+// GetConversation returns a conversation with its full message history
+func (h *Handler) GetConversation(w http.ResponseWriter, r *http.Request) {
+	conv, err := h.conversations.Get(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrConversationNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conv)
+}
+
+// DeleteConversation removes a conversation and its messages
+func (h *Handler) DeleteConversation(w http.ResponseWriter, r *http.Request) {
+	err := h.conversations.Delete(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrConversationNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replyResponse is the JSON shape of one answered question
+func replyResponse(reply *services.ConversationReply) map[string]interface{} {
+	return map[string]interface{}{
+		"conversation_id":   reply.Answer.ConversationID,
+		"message_id":        reply.Answer.ID,
+		"answer":            reply.Answer.Content,
+		"retrieval_query":   reply.Answer.RetrievalQuery,
+		"sources":           reply.Answer.Sources,
+		"prompt_tokens":     reply.Answer.PromptTokens,
+		"completion_tokens": reply.Answer.CompletionTokens,
+		"context_tokens":    reply.RAG.ContextTokens,
+		"truncated":         reply.RAG.Truncated,
+		"dropped_chunks":    reply.RAG.DroppedChunks,
+		"segments":          reply.RAG.Segments,
+		"citations":         reply.RAG.Citations,
+		"invalid_citations": reply.RAG.InvalidCitations,
+		"grounded":          reply.RAG.Grounded,
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"ai-kms/internal/models"
+	"ai-kms/internal/repository"
+	"ai-kms/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// Embedding model administration endpoints
+
+// ListEmbeddingModels lists every embedding model with stored chunks
+func (h *Handler) ListEmbeddingModels(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.embService.ListEmbeddingModels(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"models": stats,
+	})
+}
+
+// DropEmbeddingModel deletes the chunks of a model that no longer serves searches
+func (h *Handler) DropEmbeddingModel(w http.ResponseWriter, r *http.Request) {
+	err := h.embService.DropEmbeddingModel(r.Context(), mux.Vars(r)["model"])
+	if errors.Is(err, services.ErrModelInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetEmbeddingCacheStats reports embedding cache hits and misses per model
+// since the process started
+func (h *Handler) GetEmbeddingCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"caches": h.embService.EmbeddingCacheStats(),
+	})
+}
+
+// GetEmbeddingMigration reports how far the migration target is from covering the corpus
+// Cut over (EMBEDDING_MODEL = target) once it is complete
+func (h *Handler) GetEmbeddingMigration(w http.ResponseWriter, r *http.Request) {
+	status, err := h.embService.MigrationStatus(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// StartEmbeddingMigration queues the documents still missing from the migration target
+// Startup does this too - call it to retry documents whose jobs failed
+func (h *Handler) StartEmbeddingMigration(w http.ResponseWriter, r *http.Request) {
+	queued, err := h.embService.StartMigration(r.Context())
+	if errors.Is(err, services.ErrNoMigration) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queued": queued,
+	})
+}
+
+// GetVectorIndexStats reports the configured index settings and each model's index
+func (h *Handler) GetVectorIndexStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.embRepo.VectorIndexStats(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"config":  h.embRepo.IndexConfig(),
+		"indexes": stats,
+	})
+}
+
+// RebuildVectorIndex rebuilds a model's index with the current settings in the background
+// Progress shows up in GetVectorIndexStats
+func (h *Handler) RebuildVectorIndex(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model,omitempty"` // Defaults to the active model
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Model == "" {
+		req.Model = h.embRepo.Model()
+	}
+
+	repo := h.embRepo.ForModel(req.Model)
+	if repo.IndexBuildInProgress() {
+		http.Error(w, repository.ErrIndexBuildInProgress.Error(), http.StatusConflict)
+		return
+	}
+
+	// Learning: A large HNSW build takes minutes - far longer than a request
+	go func() {
+		start := time.Now()
+		if err := repo.RebuildVectorIndex(context.Background()); err != nil {
+			log.Printf("⚠️  Vector index rebuild for %s failed: %v", req.Model, err)
+			return
+		}
+		log.Printf("✓ Vector index for %s rebuilt in %s", req.Model, time.Since(start).Round(time.Millisecond))
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"model":  req.Model,
+		"config": h.embRepo.IndexConfig(),
+	})
+}
+
+// MeasureVectorIndexRecall compares index search with exact search on a sample of stored chunks
+func (h *Handler) MeasureVectorIndexRecall(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model    string `json:"model,omitempty"`  // Defaults to the active model
+		Sample   int    `json:"sample,omitempty"` // Query count (default 20, max 200)
+		K        int    `json:"k,omitempty"`      // Neighbours compared per query (default 10)
+		EFSearch int    `json:"ef_search,omitempty"`
+		Probes   int    `json:"probes,omitempty"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Model == "" {
+		req.Model = h.embRepo.Model()
+	}
+	if req.Sample <= 0 {
+		req.Sample = 20
+	}
+	if req.K <= 0 {
+		req.K = 10
+	}
+	if req.Sample > 200 || req.K > 1000 || req.EFSearch < 0 || req.EFSearch > 1000 || req.Probes < 0 {
+		http.Error(w, "sample must be at most 200, k at most 1000, ef_search 0-1000 and probes not negative", http.StatusBadRequest)
+		return
+	}
+
+	// Exact scans over a large corpus can outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	ctx := repository.WithSearchParams(r.Context(), models.VectorSearchParams{
+		EFSearch: req.EFSearch,
+		Probes:   req.Probes,
+	})
+	recall, err := h.embRepo.ForModel(req.Model).MeasureRecall(ctx, req.Sample, req.K)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recall)
+}
@@ -1,13 +1,18 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"ai-kms/internal/models"
 	"ai-kms/internal/openai"
+	"ai-kms/internal/prompts"
 	"ai-kms/internal/repository"
 	"ai-kms/internal/services"
 	"ai-kms/internal/services/collaboration"
@@ -18,13 +23,16 @@ import (
 // Handler handles HTTP requests
 // Learning: Uses INTERFACES defined in this package (consumer-driven)
 type Handler struct {
-	docRepo      *repository.DocumentRepositoryImpl  // Concrete type for now
-	embRepo      *repository.EmbeddingRepositoryImpl // Concrete type for now
-	embService   EmbeddingService                    // Interface defined in this package!
-	wsHandler    *collaboration.WebSocketHandler     // WebSocket for real-time collab
-	ragService   *services.RAGService                // RAG for AI features
-	openaiClient *openai.Client                      // OpenAI client
-	linkRepo     *repository.LinkRepositoryImpl      // Knowledge graph links
+	docRepo    *repository.DocumentRepositoryImpl  // Concrete type for now
+	embRepo    *repository.EmbeddingRepositoryImpl // Concrete type for now
+	embService EmbeddingService                    // Interface defined in this package!
+	wsHandler  *collaboration.WebSocketHandler     // WebSocket for real-time collab
+	ragService *services.RAGService                // RAG for AI features
+	search     SearchService                       // Semantic, keyword and hybrid search
+	linkRepo   *repository.LinkRepositoryImpl      // Knowledge graph links
+
+	conversations ConversationService // Multi-turn RAG chat
+	versions      VersionService      // Document version history
 }
 
 func NewHandler(
@@ -33,18 +41,64 @@ func NewHandler(
 	embService EmbeddingService, // Accept interface
 	wsHandler *collaboration.WebSocketHandler,
 	ragService *services.RAGService,
-	openaiClient *openai.Client,
+	search SearchService, // Accept interface
 	linkRepo *repository.LinkRepositoryImpl,
+	conversations ConversationService, // Accept interface
+	versions VersionService, // Accept interface
 ) *Handler {
 	return &Handler{
-		docRepo:      docRepo,
-		embRepo:      embRepo,
-		embService:   embService,
-		wsHandler:    wsHandler,
-		ragService:   ragService,
-		openaiClient: openaiClient,
-		linkRepo:     linkRepo,
+		docRepo:    docRepo,
+		embRepo:    embRepo,
+		embService: embService,
+		wsHandler:  wsHandler,
+		ragService: ragService,
+		search:     search,
+		linkRepo:   linkRepo,
+
+		conversations: conversations,
+		versions:      versions,
+	}
+}
+
+// aiErrorStatus maps AI provider errors to HTTP status codes
+// Learning: errors.Is works through wrapping, so service-level errors still match
+func aiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, openai.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, openai.ErrContextLength):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, openai.ErrAuth):
+		return http.StatusBadGateway // Our credentials, not the caller's
+	case errors.Is(err, prompts.ErrUnknownSet):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// generationOptions are the per-request overrides accepted by every AI endpoint
+type generationOptions struct {
+	PromptSet   string   `json:"prompt_set,omitempty"`  // Named prompt set from PROMPTS_DIR
+	Temperature *float64 `json:"temperature,omitempty"` // 0-2
+	MaxTokens   int      `json:"max_tokens,omitempty"`  // Completion length limit
+}
+
+// apply validates the overrides and attaches them to ctx
+// Learning: Services never see these - the prompt registry and the OpenAI
+// client read them from the context
+func (o generationOptions) apply(ctx context.Context) (context.Context, error) {
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2) {
+		return ctx, fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if o.MaxTokens < 0 {
+		return ctx, fmt.Errorf("max_tokens must not be negative")
 	}
+
+	if o.PromptSet != "" {
+		ctx = prompts.WithSet(ctx, o.PromptSet)
+	}
+	return openai.WithChatOptions(ctx, openai.ChatOptions{Temperature: o.Temperature, MaxTokens: o.MaxTokens}), nil
 }
 
 // Document handlers
@@ -67,17 +121,22 @@ func (h *Handler) CreateDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Learning: The document is saved from here on. Failing the request
+	// would make clients retry and create it twice, so what follows is
+	// logged instead
+
+	// First entry of the version history
+	if _, err := h.versions.Record(r.Context(), created, doc.Author, models.VersionSourceCreate); err != nil {
+		log.Printf("⚠️  Document %s created but version history failed: %v", created.ID, err)
+	}
+
 	// Automatically submit for embedding generation
 	// Learning: Submitting to worker pool is non-blocking
-	job := services.EmbeddingJob{
-		DocumentID: created.ID,
-		Content:    created.Content,
-	}
-	if err := h.embService.SubmitJob(job); err != nil {
+	job := services.EmbeddingJob{DocumentID: created.ID}
+	if _, err := h.embService.SubmitJob(job); err != nil {
 		// Log but don't fail the request
 		// The embedding can be regenerated later
-		http.Error(w, "Document created but embedding generation failed", http.StatusInternalServerError)
-		return
+		log.Printf("⚠️  Document %s created but embedding generation failed: %v", created.ID, err)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -142,19 +201,43 @@ func (h *Handler) UpdateDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Remember the current hash so we can tell whether the text really changed
+	before, err := h.docRepo.GetByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	previousHash := before.ContentHash
+
+	// Documents from before version history: keep the state being overwritten
+	if err := h.versions.EnsureBaseline(r.Context(), before); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	updated, err := h.docRepo.Update(r.Context(), id, &update)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// If content was updated, regenerate embeddings
-	if update.Content != nil {
-		job := services.EmbeddingJob{
-			DocumentID: updated.ID,
-			Content:    updated.Content,
+	// The update is saved: what follows is logged, not reported as a failure
+	if _, err := h.versions.Record(r.Context(), updated, update.Author, models.VersionSourceEdit); err != nil {
+		log.Printf("⚠️  Document %s updated but version history failed: %v", id, err)
+	}
+
+	// Regenerate embeddings only when the content changed
+	// Learning: Title/metadata-only edits (or re-saving identical text) skip the API bill
+	if update.Content != nil && updated.ContentHash != previousHash {
+		job := services.EmbeddingJob{DocumentID: updated.ID}
+		_, _ = h.embService.SubmitJob(job) // Best effort
+
+		// Open editors get the new text, and the next write-back of their
+		// edits doesn't bring the old one back. If this fails, that
+		// write-back still sees the content changed and takes it in
+		if err := h.wsHandler.SetContent(r.Context(), id, *update.Content); err != nil {
+			log.Printf("⚠️  Document %s updated but collaborative editing state wasn't: %v", id, err)
 		}
-		_ = h.embService.SubmitJob(job) // Best effort
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -180,6 +263,22 @@ func (h *Handler) DeleteDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Remove the document's chunks so it stops showing up in search
+	if err := h.embService.RemoveDocument(r.Context(), id, hardDelete); err != nil {
+		http.Error(w, fmt.Sprintf("Document deleted but embeddings cleanup failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if hardDelete {
+		if err := h.ragService.DeleteSummaries(r.Context(), id); err != nil {
+			http.Error(w, fmt.Sprintf("Document deleted but summary cleanup failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := h.versions.DeleteHistory(r.Context(), id); err != nil {
+			http.Error(w, fmt.Sprintf("Document deleted but version history cleanup failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -197,28 +296,78 @@ func (h *Handler) GenerateEmbeddings(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Submit to worker pool
-	job := services.EmbeddingJob{
-		DocumentID: doc.ID,
-		Content:    doc.Content,
-	}
-
-	if err := h.embService.SubmitJob(job); err != nil {
+	job, err := h.embService.SubmitJob(services.EmbeddingJob{DocumentID: doc.ID})
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":      "Embedding generation submitted",
 		"document_id":  doc.ID,
+		"job":          job,
 		"queue_length": h.embService.GetQueueLength(),
 	})
 }
 
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	job, err := h.embService.GetJob(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *Handler) GetEmbeddingStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Make sure the document exists so unknown IDs return 404, not "not searchable"
+	if _, err := h.docRepo.GetByID(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	status, err := h.embService.GetEmbeddingStatus(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
 func (h *Handler) SemanticSearch(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Query string `json:"query"`
 		Limit int    `json:"limit"`
+		Mode  string `json:"mode,omitempty"` // "semantic", "keyword" or "hybrid"
+
+		// Presentation - all optional
+		Cursor          string `json:"cursor,omitempty"`            // next_cursor of the previous page
+		GroupByDocument bool   `json:"group_by_document,omitempty"` // Best chunk per document
+
+		// Vector index tuning - higher is slower but finds more true neighbours
+		EFSearch int `json:"ef_search,omitempty"` // HNSW
+		Probes   int `json:"probes,omitempty"`    // IVFFlat
+
+		// Filters - all optional
+		Format      *models.DocumentFormat `json:"format,omitempty"`
+		MinScore    float32                `json:"min_score,omitempty"`
+		DateFrom    string                 `json:"date_from,omitempty"` // RFC 3339 or YYYY-MM-DD
+		DateTo      string                 `json:"date_to,omitempty"`   // Inclusive when a plain date
+		ExcludeIDs  []string               `json:"exclude_ids,omitempty"`
+		DocumentIDs []string               `json:"document_ids,omitempty"` // Search only these documents
+		Metadata    map[string]any         `json:"metadata,omitempty"`     // e.g. {"team": "infra"}
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -230,34 +379,90 @@ func (h *Handler) SemanticSearch(w http.ResponseWriter, r *http.Request) {
 		req.Limit = 10
 	}
 
-	// Generate embedding for query
-	embedding, err := h.openaiClient.CreateEmbeddings([]string{req.Query})
+	mode, err := services.ParseSearchMode(req.Mode)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to generate query embedding: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Perform semantic search
-	results, err := h.embRepo.SemanticSearch(r.Context(), embedding, req.Limit)
+	filters := services.SearchFilters{
+		Format:      req.Format,
+		MinScore:    req.MinScore,
+		ExcludeIDs:  req.ExcludeIDs,
+		DocumentIDs: req.DocumentIDs,
+		Metadata:    req.Metadata,
+	}
+	if filters.DateFrom, err = parseDateParam(req.DateFrom, false); err != nil {
+		http.Error(w, fmt.Sprintf("invalid date_from: %v", err), http.StatusBadRequest)
+		return
+	}
+	if filters.DateTo, err = parseDateParam(req.DateTo, true); err != nil {
+		http.Error(w, fmt.Sprintf("invalid date_to: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.EFSearch < 0 || req.EFSearch > 1000 || req.Probes < 0 {
+		http.Error(w, "ef_search must be 0-1000 and probes not negative", http.StatusBadRequest)
+		return
+	}
+	ctx := repository.WithSearchParams(r.Context(), models.VectorSearchParams{
+		EFSearch: req.EFSearch,
+		Probes:   req.Probes,
+	})
+
+	page, err := h.search.SearchPage(ctx, req.Query, services.SearchOptions{
+		Mode:            mode,
+		Filters:         filters,
+		Limit:           req.Limit,
+		Cursor:          req.Cursor,
+		GroupByDocument: req.GroupByDocument,
+	})
+	if errors.Is(err, services.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), aiErrorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"query":   req.Query,
-		"results": results,
-		"count":   len(results),
+		"query":       req.Query,
+		"mode":        mode,
+		"results":     page.Results,
+		"count":       len(page.Results),
+		"next_cursor": page.NextCursor,
 	})
 }
 
+// parseDateParam parses an RFC 3339 timestamp or a YYYY-MM-DD date
+// For an end date, a plain date means "through the end of that day"
+func parseDateParam(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, fmt.Errorf("expected RFC 3339 or YYYY-MM-DD, got %q", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
 // RAG handlers
 
 func (h *Handler) QueryWithRAG(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Query     string `json:"query"`
 		MaxChunks int    `json:"max_chunks,omitempty"`
+		generationOptions
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -269,17 +474,97 @@ func (h *Handler) QueryWithRAG(w http.ResponseWriter, r *http.Request) {
 		req.MaxChunks = 5
 	}
 
-	answer, sources, err := h.ragService.QueryWithContext(r.Context(), req.Query, req.MaxChunks)
+	ctx, err := req.apply(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.ragService.QueryWithContext(ctx, req.Query, req.MaxChunks)
+	if err != nil {
+		http.Error(w, err.Error(), aiErrorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"query":   req.Query,
-		"answer":  answer,
-		"sources": sources,
+		"query":          req.Query,
+		"answer":         result.Answer,
+		"sources":        result.Sources,
+		"context_tokens": result.ContextTokens,
+		"truncated":      result.Truncated,
+		"dropped_chunks": result.DroppedChunks,
+
+		"segments":          result.Segments,
+		"citations":         result.Citations,
+		"invalid_citations": result.InvalidCitations,
+		"grounded":          result.Grounded,
+	})
+}
+
+// QueryWithRAGStream answers like QueryWithRAG, but streams the answer as Server-Sent Events:
+// one "sources" event, then "delta" events with pieces of the answer, then "done" (or "error")
+func (h *Handler) QueryWithRAGStream(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string `json:"query"`
+		MaxChunks int    `json:"max_chunks,omitempty"`
+		generationOptions
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.MaxChunks == 0 {
+		req.MaxChunks = 5
+	}
+
+	ctx, err := req.apply(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Headers are sent lazily, so retrieval errors can still get a proper status code
+	var stream *sseWriter
+	result, err := h.ragService.StreamQueryWithContext(ctx, req.Query, req.MaxChunks,
+		func(sources *services.RAGAnswer) error {
+			stream = newSSEWriter(w)
+			return stream.Send("sources", map[string]interface{}{
+				"query":          req.Query,
+				"sources":        sources.Sources,
+				"context_tokens": sources.ContextTokens,
+				"truncated":      sources.Truncated,
+				"dropped_chunks": sources.DroppedChunks,
+			})
+		},
+		func(delta string) error {
+			return stream.Send("delta", map[string]string{"content": delta})
+		},
+	)
+	if err != nil {
+		if stream == nil {
+			http.Error(w, err.Error(), aiErrorStatus(err))
+			return
+		}
+		if r.Context().Err() == nil {
+			// Still connected - tell the client why the answer stopped
+			stream.Send("error", map[string]interface{}{
+				"error":  err.Error(),
+				"status": aiErrorStatus(err),
+			})
+		}
+		return
+	}
+
+	// Citations can only be checked once the whole answer is known
+	stream.Send("done", map[string]interface{}{
+		"answer":            result.Answer,
+		"segments":          result.Segments,
+		"citations":         result.Citations,
+		"invalid_citations": result.InvalidCitations,
+		"grounded":          result.Grounded,
 	})
 }
 
@@ -288,7 +573,10 @@ func (h *Handler) SummarizeDocument(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	var req struct {
-		MaxWords int `json:"max_words,omitempty"`
+		MaxWords int                `json:"max_words,omitempty"`
+		Mode     models.SummaryMode `json:"mode,omitempty"`    // "overall" (default) or "sections"
+		Refresh  bool               `json:"refresh,omitempty"` // Regenerate even if cached
+		generationOptions
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -300,25 +588,47 @@ func (h *Handler) SummarizeDocument(w http.ResponseWriter, r *http.Request) {
 		req.MaxWords = 150
 	}
 
+	switch req.Mode {
+	case "", models.SummaryModeOverall, models.SummaryModeSections:
+	default:
+		http.Error(w, fmt.Sprintf("unknown mode %q (expected \"overall\" or \"sections\")", req.Mode), http.StatusBadRequest)
+		return
+	}
+
+	// Cached summaries are served as-is - overrides only apply when one is (re)generated
+	ctx, err := req.apply(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get document
-	doc, err := h.docRepo.GetByID(r.Context(), id)
+	doc, err := h.docRepo.GetByID(ctx, id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	// Generate summary
-	summary, err := h.ragService.SummarizeDocument(r.Context(), doc.Content, req.MaxWords)
+	// Generate summary (or serve it from the cache)
+	summary, err := h.ragService.SummarizeDocument(ctx, doc, services.SummaryOptions{
+		MaxWords: req.MaxWords,
+		Mode:     req.Mode,
+		Refresh:  req.Refresh,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), aiErrorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"document_id": id,
-		"summary":     summary,
+		"summary":     summary.Summary,
+		"sections":    summary.Sections,
+		"mode":        summary.Mode,
 		"max_words":   req.MaxWords,
+		"chunk_count": summary.ChunkCount,
+		"cached":      summary.Cached,
 	})
 }
 
@@ -327,35 +637,58 @@ func (h *Handler) QueryDocument(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	var req struct {
-		Query string `json:"query"`
+		Query     string `json:"query"`
+		MaxChunks int    `json:"max_chunks,omitempty"`
+		generationOptions
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.MaxChunks == 0 {
+		req.MaxChunks = 5
+	}
+
+	ctx, err := req.apply(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Get document
-	doc, err := h.docRepo.GetByID(r.Context(), id)
+	doc, err := h.docRepo.GetByID(ctx, id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	// Use document as context for the query
-	answer, err := h.ragService.SummarizeDocument(r.Context(),
-		fmt.Sprintf("Based on this document:\n\n%s\n\nQuestion: %s", doc.Content, req.Query),
-		300,
-	)
+	// Answer from this document's chunks only
+	result, err := h.ragService.QueryDocument(ctx, doc, req.Query, req.MaxChunks)
+	if errors.Is(err, services.ErrDocumentNotIndexed) {
+		// Embedding will catch up - the client can retry once the status is "ready"
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), aiErrorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"document_id": id,
-		"query":       req.Query,
-		"answer":      answer,
+		"document_id":    id,
+		"query":          req.Query,
+		"answer":         result.Answer,
+		"sources":        result.Sources,
+		"context_tokens": result.ContextTokens,
+		"truncated":      result.Truncated,
+		"dropped_chunks": result.DroppedChunks,
+		"whole_document": result.WholeDocument,
+
+		"segments":          result.Segments,
+		"citations":         result.Citations,
+		"invalid_citations": result.InvalidCitations,
+		"grounded":          result.Grounded,
 	})
 }
 
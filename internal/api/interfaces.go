@@ -26,11 +26,44 @@ Benefits:
 // Only methods called by handlers are declared
 type EmbeddingService interface {
 	Start()
-	SubmitJob(job services.EmbeddingJob) error
+	SubmitJob(job services.EmbeddingJob) (*models.EmbeddingJob, error)
+	RemoveDocument(ctx context.Context, documentID string, hard bool) error
+	GetJob(ctx context.Context, id string) (*models.EmbeddingJob, error)
+	GetEmbeddingStatus(ctx context.Context, documentID string) (*models.EmbeddingStatus, error)
+	ListEmbeddingModels(ctx context.Context) ([]*models.EmbeddingModelStats, error)
+	MigrationStatus(ctx context.Context) (*models.EmbeddingMigrationStatus, error)
+	StartMigration(ctx context.Context) (int, error)
+	DropEmbeddingModel(ctx context.Context, model string) error
+	EmbeddingCacheStats() []*models.EmbeddingCacheStats
 	Shutdown()
 	GetQueueLength() int
 }
 
+// SearchService defines what handlers need for search endpoints
+type SearchService interface {
+	SearchPage(ctx context.Context, query string, opts services.SearchOptions) (*services.SearchPage, error)
+}
+
+// ConversationService defines what handlers need for multi-turn chat endpoints
+type ConversationService interface {
+	Create(ctx context.Context, title string) (*models.Conversation, error)
+	Get(ctx context.Context, id string) (*models.Conversation, error)
+	List(ctx context.Context, limit, offset int) ([]*models.Conversation, error)
+	Delete(ctx context.Context, id string) error
+	Reply(ctx context.Context, conversationID, question string, maxChunks int) (*services.ConversationReply, error)
+}
+
+// VersionService defines what handlers need for document version history
+type VersionService interface {
+	Record(ctx context.Context, doc *models.Document, author string, source models.VersionSource) (*models.DocumentVersion, error)
+	EnsureBaseline(ctx context.Context, doc *models.Document) error
+	List(ctx context.Context, documentID string, limit, offset int) ([]*models.DocumentVersion, error)
+	Get(ctx context.Context, documentID, id string) (*models.DocumentVersion, error)
+	Diff(ctx context.Context, documentID, from, to string) (*models.VersionDiff, error)
+	Restore(ctx context.Context, documentID, id, author string) (*models.Document, *models.DocumentVersion, error)
+	DeleteHistory(ctx context.Context, documentID string) error
+}
+
 // Future interfaces for other services used by handlers
 
 // AIService for chat and summarization endpoints
@@ -38,8 +71,3 @@ type AIService interface {
 	Summarize(ctx context.Context, text string) (string, error)
 	Chat(ctx context.Context, messages []services.ChatMessage) (string, error)
 }
-
-// SearchService for semantic search endpoints
-type SearchService interface {
-	Search(ctx context.Context, query string, limit int) ([]*models.SearchResult, error)
-}
@@ -27,8 +27,19 @@ func SetupRoutes(h *Handler) *mux.Router {
 	api.HandleFunc("/documents/{id}", h.UpdateDocument).Methods("PUT")
 	api.HandleFunc("/documents/{id}", h.DeleteDocument).Methods("DELETE")
 
+	// Version history endpoints
+	api.HandleFunc("/documents/{id}/versions", h.ListVersions).Methods("GET")
+	api.HandleFunc("/documents/{id}/versions/diff", h.DiffVersions).Methods("GET") // Before {version}
+	api.HandleFunc("/documents/{id}/versions/{version}", h.GetVersion).Methods("GET")
+	api.HandleFunc("/documents/{id}/versions/{version}/restore", h.RestoreVersion).Methods("POST")
+
+	// Presence of users editing a document (from WebSocket awareness)
+	api.HandleFunc("/documents/{id}/presence", h.GetPresence).Methods("GET")
+
 	// Embedding endpoints
 	api.HandleFunc("/documents/{id}/embed", h.GenerateEmbeddings).Methods("POST")
+	api.HandleFunc("/documents/{id}/embedding-status", h.GetEmbeddingStatus).Methods("GET")
+	api.HandleFunc("/jobs/{id}", h.GetJob).Methods("GET")
 	api.HandleFunc("/search", h.SemanticSearch).Methods("POST")
 
 	// RAG endpoints
@@ -38,14 +49,32 @@ func SetupRoutes(h *Handler) *mux.Router {
 	// AI/RAG endpoints
 	api.HandleFunc("/search", h.SemanticSearch).Methods("POST")
 	api.HandleFunc("/ai/query", h.QueryWithRAG).Methods("POST")
+	api.HandleFunc("/ai/query/stream", h.QueryWithRAGStream).Methods("POST")
 	api.HandleFunc("/ai/summarize/{id}", h.SummarizeDocument).Methods("POST")
 	api.HandleFunc("/ai/query/{id}", h.QueryDocument).Methods("POST")
 
+	// Conversation endpoints (multi-turn RAG)
+	api.HandleFunc("/conversations", h.CreateConversation).Methods("POST")
+	api.HandleFunc("/conversations", h.ListConversations).Methods("GET")
+	api.HandleFunc("/conversations/{id}", h.GetConversation).Methods("GET")
+	api.HandleFunc("/conversations/{id}", h.DeleteConversation).Methods("DELETE")
+	api.HandleFunc("/conversations/{id}/messages", h.SendConversationMessage).Methods("POST")
+
 	// Knowledge graph endpoints
 	api.HandleFunc("/graph", h.GetKnowledgeGraph).Methods("GET")
 	api.HandleFunc("/graph/generate", h.GenerateKnowledgeGraph).Methods("POST")
 	api.HandleFunc("/graph/nodes/{id}", h.GetGraphNode).Methods("GET")
 
+	// Embedding model administration (side-by-side models and migrations)
+	api.HandleFunc("/admin/embeddings/models", h.ListEmbeddingModels).Methods("GET")
+	api.HandleFunc("/admin/embeddings/models/{model}", h.DropEmbeddingModel).Methods("DELETE")
+	api.HandleFunc("/admin/embeddings/migration", h.GetEmbeddingMigration).Methods("GET")
+	api.HandleFunc("/admin/embeddings/migration", h.StartEmbeddingMigration).Methods("POST")
+	api.HandleFunc("/admin/embeddings/cache", h.GetEmbeddingCacheStats).Methods("GET")
+	api.HandleFunc("/admin/embeddings/index", h.GetVectorIndexStats).Methods("GET")
+	api.HandleFunc("/admin/embeddings/index/rebuild", h.RebuildVectorIndex).Methods("POST")
+	api.HandleFunc("/admin/embeddings/index/recall", h.MeasureVectorIndexRecall).Methods("POST")
+
 	// Health check endpoint
 	api.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -54,6 +83,7 @@ func SetupRoutes(h *Handler) *mux.Router {
 
 	// WebSocket routes
 	r.HandleFunc("/ws/document/{id}", h.HandleDocumentWebSocket)
+	r.HandleFunc("/ws/document/{id}/{room}", h.HandleDocumentWebSocket) // y-websocket appends the room name
 	r.HandleFunc("/ws/updates", h.HandleUpdatesWebSocket)
 
 	// Serve HTML files
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+/*
+LEARNING: SERVER-SENT EVENTS (SSE)
+
+SSE is a one-way stream from server to browser over a plain HTTP response:
+
+  Content-Type: text/event-stream
+
+  event: sources
+  data: {"sources": [...]}
+
+  event: delta
+  data: {"content": "Restart the"}
+
+Each event is "event:" + "data:" lines followed by a blank line, and must be
+flushed immediately - otherwise it sits in a buffer until the handler returns.
+
+The browser reads it with fetch() + a stream reader (EventSource only supports
+GET). When the client goes away, the request context is cancelled, which
+stops the upstream model request too.
+*/
+
+// sseWriter writes Server-Sent Events to a response
+type sseWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// newSSEWriter sends the event-stream headers
+// Learning: Streams outlive the server's WriteTimeout, so the deadline is lifted
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{}) // Best effort - not every writer supports it
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	return &sseWriter{w: w, rc: rc}
+}
+
+// Send writes one event with a JSON payload and flushes it to the client
+func (s *sseWriter) Send(event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event, err)
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
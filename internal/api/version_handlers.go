@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"ai-kms/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// Version history endpoints
+
+// ListVersions lists a document's versions, newest first
+func (h *Handler) ListVersions(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	limit := 50
+	offset := 0
+
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && parsed >= 0 {
+		offset = parsed
+	}
+
+	versions, err := h.versions.List(r.Context(), id, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"versions": versions,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// GetVersion returns one version with its content
+func (h *Handler) GetVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	version, err := h.versions.Get(r.Context(), vars["id"], vars["version"])
+	if errors.Is(err, services.ErrVersionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
+
+// DiffVersions compares two versions line by line
+// ?from=<version>&to=<version> - "to" defaults to the current content
+func (h *Handler) DiffVersions(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+
+	if from == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := h.versions.Diff(r.Context(), id, from, to)
+	if errors.Is(err, services.ErrVersionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Learning: Plain unified diff for tools like `patch` or `delta`
+	if r.URL.Query().Get("format") == "unified" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		w.Write([]byte(diff.Diff))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// RestoreVersion makes an earlier version the current content, as a new edit
+func (h *Handler) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req struct {
+		Author string `json:"author,omitempty"`
+	}
+	// The body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, err := h.docRepo.GetByID(r.Context(), vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	doc, version, err := h.versions.Restore(r.Context(), vars["id"], vars["version"], req.Author)
+	if errors.Is(err, services.ErrVersionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Re-embed like any other edit that changes the text
+	if doc.ContentHash != before.ContentHash {
+		_, _ = h.embService.SubmitJob(services.EmbeddingJob{DocumentID: doc.ID}) // Best effort
+
+		// Learning: Open editors still hold the text being replaced. The
+		// restore goes into the room as an edit; otherwise their next
+		// write-back would quietly undo it. The restore is saved either way,
+		// so a failure is logged - that write-back still sees the content
+		// changed and takes it in
+		if err := h.wsHandler.SetContent(r.Context(), doc.ID, doc.Content); err != nil {
+			log.Printf("⚠️  Document %s restored but collaborative editing state wasn't: %v", doc.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"document": doc,
+		"version":  version,
+	})
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+
+	"github.com/gorilla/mux"
 )
 
 // WebSocket endpoints
@@ -15,3 +18,22 @@ func (h *Handler) HandleDocumentWebSocket(w http.ResponseWriter, r *http.Request
 func (h *Handler) HandleUpdatesWebSocket(w http.ResponseWriter, r *http.Request) {
 	h.wsHandler.HandleUpdatesConnection(w, r)
 }
+
+// GetPresence lists the users editing a document, with their colors and cursors
+func (h *Handler) GetPresence(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if _, err := h.docRepo.GetByID(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	users := h.wsHandler.Presence(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"document_id": id,
+		"users":       users,
+		"count":       len(users),
+	})
+}
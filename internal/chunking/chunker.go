@@ -0,0 +1,325 @@
+package chunking
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"ai-kms/internal/models"
+	"ai-kms/internal/tokenizer"
+)
+
+/*
+LEARNING: STRUCTURE-AWARE CHUNKING
+
+Embeddings work best on coherent, reasonably-sized pieces of text.
+Splitting every N words cuts sentences in half and loses the section a
+chunk came from. Instead, each document format gets its own chunker:
+
+  markdown → split on the heading hierarchy, keep "Guide > Setup > Docker" as context
+  json     → split on object paths, keep "$.services.api" as context
+  text     → split on paragraphs, then sentences
+
+All chunkers share the same two steps:
+
+1. Split the document into SEGMENTS that each fit the token budget
+   (paragraph → sentence → line → word, going finer only when needed)
+2. PACK consecutive segments into chunks up to MaxTokens, repeating the
+   last OverlapTokens worth of segments at the start of the next chunk
+   so a fact on a boundary is still retrievable
+
+Offsets are byte offsets into the original content, so callers can map a
+chunk back to where it came from.
+*/
+
+// Chunk is a piece of a document ready to be embedded
+type Chunk struct {
+	Text        string // Chunk text, exactly as it appears in the document
+	Context     string // Heading path / JSON path the chunk belongs to
+	StartOffset int    // Byte offset of Text in the original content
+	EndOffset   int
+}
+
+// EmbeddingText is what gets sent to the embedding model
+// Learning: Prefixing the section path lets "How do I restart it?" under
+// "Postgres > Failover" match a query that mentions Postgres
+func (c Chunk) EmbeddingText() string {
+	if c.Context == "" {
+		return c.Text
+	}
+	return c.Context + "\n\n" + c.Text
+}
+
+// Chunker splits document content into chunks
+type Chunker interface {
+	Chunk(content string) []Chunk
+}
+
+// Options controls chunk sizes
+type Options struct {
+	MaxTokens      int                      // Upper bound per chunk
+	OverlapTokens  int                      // Tokens repeated between consecutive chunks
+	CountTokens    func(string) int         // Defaults to tokenizer.Estimator
+	TruncateTokens func(string, int) string // Longest prefix within a token count; defaults to tokenizer.Estimator
+}
+
+// withDefaults fills in zero values
+func (o Options) withDefaults() Options {
+	if o.MaxTokens <= 0 {
+		o.MaxTokens = 400
+	}
+	if o.OverlapTokens < 0 || o.OverlapTokens >= o.MaxTokens {
+		o.OverlapTokens = 0
+	}
+	if o.CountTokens == nil {
+		o.CountTokens = tokenizer.Estimator{}.Count
+	}
+	if o.TruncateTokens == nil {
+		o.TruncateTokens = tokenizer.Estimator{}.Truncate
+	}
+	return o
+}
+
+// ForFormat returns the chunker for a document format
+// Unknown formats fall back to plain text
+func ForFormat(format models.DocumentFormat, opts Options) Chunker {
+	opts = opts.withDefaults()
+
+	switch format {
+	case models.FormatMarkdown:
+		return &MarkdownChunker{opts: opts}
+	case models.FormatJSON:
+		return &JSONChunker{opts: opts}
+	default:
+		return &TextChunker{opts: opts}
+	}
+}
+
+// span is a [start, end) byte range in the content
+type span struct {
+	start, end int
+}
+
+// trimSpan shrinks a span to exclude surrounding whitespace
+func trimSpan(content string, s span) span {
+	for s.start < s.end && isSpace(content[s.start]) {
+		s.start++
+	}
+	for s.end > s.start && isSpace(content[s.end-1]) {
+		s.end--
+	}
+	return s
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// pack groups consecutive segments into chunks of at most MaxTokens,
+// overlapping by up to OverlapTokens
+func pack(content string, segs []span, context string, opts Options) []Chunk {
+	if len(segs) == 0 {
+		return nil
+	}
+
+	counts := make([]int, len(segs))
+	for i, s := range segs {
+		counts[i] = opts.CountTokens(content[s.start:s.end])
+	}
+
+	var chunks []Chunk
+	i := 0
+	for i < len(segs) {
+		// Grow the chunk while it fits (always take at least one segment)
+		j, total := i, 0
+		for j < len(segs) && (j == i || total+counts[j] <= opts.MaxTokens) {
+			total += counts[j]
+			j++
+		}
+
+		start, end := segs[i].start, segs[j-1].end
+		chunks = append(chunks, Chunk{
+			Text:        content[start:end],
+			Context:     context,
+			StartOffset: start,
+			EndOffset:   end,
+		})
+
+		if j >= len(segs) {
+			break
+		}
+
+		// Step back to repeat up to OverlapTokens of trailing segments,
+		// but only if the next segment still fits after the overlap
+		k, overlap := j, 0
+		for k-1 > i && overlap+counts[k-1] <= opts.OverlapTokens &&
+			overlap+counts[k-1]+counts[j] <= opts.MaxTokens {
+			overlap += counts[k-1]
+			k--
+		}
+		i = k
+	}
+
+	return chunks
+}
+
+// splitter breaks a span into smaller spans, or returns it unchanged if it can't
+type splitter func(content string, s span) []span
+
+// fit recursively splits a span until every piece fits MaxTokens,
+// trying each splitter in order from coarsest to finest
+func fit(content string, s span, opts Options, splitters ...splitter) []span {
+	s = trimSpan(content, s)
+	if s.start >= s.end {
+		return nil
+	}
+	if opts.CountTokens(content[s.start:s.end]) <= opts.MaxTokens {
+		return []span{s}
+	}
+
+	for idx, split := range splitters {
+		parts := split(content, s)
+		if len(parts) <= 1 {
+			continue
+		}
+
+		var result []span
+		for _, p := range parts {
+			result = append(result, fit(content, p, opts, splitters[idx:]...)...)
+		}
+		return result
+	}
+
+	// Nothing could split it - fall back to fixed-size word windows
+	return splitWords(content, s, opts)
+}
+
+// splitParagraphs splits on blank lines
+func splitParagraphs(content string, s span) []span {
+	var parts []span
+	start := s.start
+	for i := s.start; i < s.end; i++ {
+		if content[i] != '\n' {
+			continue
+		}
+		// Look for a following line that is empty (only whitespace)
+		j := i + 1
+		for j < s.end && (content[j] == ' ' || content[j] == '\t' || content[j] == '\r') {
+			j++
+		}
+		if j < s.end && content[j] == '\n' {
+			parts = appendSpan(content, parts, span{start, i})
+			start = j + 1
+			i = j
+		}
+	}
+	return appendSpan(content, parts, span{start, s.end})
+}
+
+// splitLines splits on single newlines
+func splitLines(content string, s span) []span {
+	var parts []span
+	start := s.start
+	for i := s.start; i < s.end; i++ {
+		if content[i] == '\n' {
+			parts = appendSpan(content, parts, span{start, i})
+			start = i + 1
+		}
+	}
+	return appendSpan(content, parts, span{start, s.end})
+}
+
+// splitSentences splits after ". ", "! " and "? "
+func splitSentences(content string, s span) []span {
+	var parts []span
+	start := s.start
+	for i := s.start; i < s.end-1; i++ {
+		c := content[i]
+		if (c == '.' || c == '!' || c == '?') && isSpace(content[i+1]) {
+			parts = appendSpan(content, parts, span{start, i + 1})
+			start = i + 1
+		}
+	}
+	return appendSpan(content, parts, span{start, s.end})
+}
+
+// splitWords cuts a span into windows of words that fit MaxTokens
+func splitWords(content string, s span, opts Options) []span {
+	var words []span
+	inWord := false
+	for i, size := s.start, 0; i < s.end; i += size {
+		// Learning: Decode whole runes - bytes like 0xA0 inside "à" would
+		// otherwise count as spaces and cut the character in half
+		var r rune
+		r, size = utf8.DecodeRuneInString(content[i:s.end])
+		space := unicode.IsSpace(r)
+		if !space && !inWord {
+			words = append(words, span{start: i})
+			inWord = true
+		} else if space && inWord {
+			words[len(words)-1].end = i
+			inWord = false
+		}
+	}
+	if inWord {
+		words[len(words)-1].end = s.end
+	}
+	words = splitLongWords(content, words, opts)
+
+	var parts []span
+	for i := 0; i < len(words); {
+		j := i + 1
+		for j < len(words) && opts.CountTokens(content[words[i].start:words[j].end]) <= opts.MaxTokens {
+			j++
+		}
+		parts = append(parts, span{words[i].start, words[j-1].end})
+		i = j
+	}
+	return parts
+}
+
+// bytesPerTokenWindow bounds how much of a long word each cut looks at
+const bytesPerTokenWindow = 8
+
+// splitLongWords cuts words longer than MaxTokens on token boundaries
+// Learning: A base64 blob, minified JSON or a long URL has no whitespace to
+// split on - as one chunk it would exceed the embedding model's context,
+// and that error fails the whole document for good. Each cut only looks at
+// a window of the word: counting the whole remainder for every cut makes a
+// long blob quadratic
+func splitLongWords(content string, words []span, opts Options) []span {
+	window := opts.MaxTokens * bytesPerTokenWindow
+	var result []span
+	for _, w := range words {
+		if opts.CountTokens(content[w.start:w.end]) <= opts.MaxTokens {
+			result = append(result, w)
+			continue
+		}
+		for w.start < w.end {
+			rest := content[w.start:w.end]
+			if len(rest) <= window && opts.CountTokens(rest) <= opts.MaxTokens {
+				result = append(result, w)
+				break
+			}
+			end := min(len(rest), window)
+			for end < len(rest) && end > 0 && !utf8.RuneStart(rest[end]) {
+				end--
+			}
+			n := len(opts.TruncateTokens(rest[:end], opts.MaxTokens))
+			if n == 0 {
+				_, n = utf8.DecodeRuneInString(rest) // Always make progress
+			}
+			result = append(result, span{w.start, w.start + n})
+			w.start += n
+		}
+	}
+	return result
+}
+
+// appendSpan appends a trimmed, non-empty span
+func appendSpan(content string, parts []span, s span) []span {
+	s = trimSpan(content, s)
+	if s.start < s.end {
+		parts = append(parts, s)
+	}
+	return parts
+}
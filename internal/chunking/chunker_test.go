@@ -0,0 +1,74 @@
+package chunking
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"ai-kms/internal/models"
+	"ai-kms/internal/tokenizer"
+)
+
+// checkChunks verifies every chunk is valid UTF-8, within the token budget
+// and exactly the content at its offsets
+func checkChunks(t *testing.T, content string, chunks []Chunk, maxTokens int) {
+	t.Helper()
+	if len(chunks) == 0 {
+		t.Fatal("no chunks")
+	}
+	for i, c := range chunks {
+		if !utf8.ValidString(c.Text) {
+			t.Fatalf("chunk %d isn't valid UTF-8: %q", i, c.Text)
+		}
+		if n := (tokenizer.Estimator{}).Count(c.Text); n > maxTokens {
+			t.Errorf("chunk %d has %d tokens, more than %d", i, n, maxTokens)
+		}
+		if content[c.StartOffset:c.EndOffset] != c.Text {
+			t.Errorf("chunk %d doesn't match content[%d:%d]", i, c.StartOffset, c.EndOffset)
+		}
+	}
+}
+
+func TestChunkMultibyteWords(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		// "à" is C3 A0, and 0xA0 on its own would be a no-break space
+		{"latin", strings.Repeat("voilà ", 2000)},
+		{"next line byte", strings.Repeat("ą…ą ", 2000)}, // "…" is E2 80 A6, "ą" is C4 85
+		{"cjk", strings.Repeat("日本語のテキスト", 2000)},
+		{"emoji", strings.Repeat("🙂", 5000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := ForFormat(models.FormatText, Options{MaxTokens: 50}).Chunk(tt.content)
+			checkChunks(t, tt.content, chunks, 50)
+		})
+	}
+}
+
+func TestChunkLongBlob(t *testing.T) {
+	raw := make([]byte, 120_000)
+	rand.Read(raw)
+	blob := base64.StdEncoding.EncodeToString(raw) // 160 KB without whitespace
+	content := "Attachment:\n\n" + blob
+
+	start := time.Now()
+	chunks := ForFormat(models.FormatText, Options{MaxTokens: 400}).Chunk(content)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("chunking took %s", elapsed)
+	}
+	checkChunks(t, content, chunks, 400)
+
+	var joined strings.Builder
+	for _, c := range chunks[1:] {
+		joined.WriteString(c.Text)
+	}
+	if joined.String() != blob {
+		t.Error("blob chunks don't add up to the blob")
+	}
+}
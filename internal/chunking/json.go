@@ -0,0 +1,149 @@
+package chunking
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// JSONChunker splits JSON documents along object paths
+// Small values are grouped with their siblings; large objects and arrays
+// are descended into so each chunk carries the path it came from ($.a.b[2])
+type JSONChunker struct {
+	opts Options
+}
+
+// jsonNode is a value in the document, with the span of "key": value
+type jsonNode struct {
+	path     string
+	span     span // Includes the key for object members
+	children []*jsonNode
+}
+
+// Chunk splits JSON into chunks; invalid JSON is chunked as plain text
+func (c *JSONChunker) Chunk(content string) []Chunk {
+	if !json.Valid([]byte(content)) {
+		return (&TextChunker{opts: c.opts}).Chunk(content)
+	}
+
+	p := &jsonParser{content: content}
+	p.skipSpace()
+	root := p.parseValue("$", p.pos)
+
+	return c.chunkNode(content, root)
+}
+
+// chunkNode emits a node whole if it fits, otherwise packs its children
+func (c *JSONChunker) chunkNode(content string, node *jsonNode) []Chunk {
+	text := content[node.span.start:node.span.end]
+	if c.opts.CountTokens(text) <= c.opts.MaxTokens || len(node.children) == 0 {
+		// Fits (or is a huge scalar, which gets split by lines/words)
+		segs := fit(content, node.span, c.opts, splitLines)
+		return pack(content, segs, node.path, c.opts)
+	}
+
+	var chunks []Chunk
+	var run []span // Consecutive children small enough to share a chunk
+
+	flushRun := func() {
+		chunks = append(chunks, pack(content, run, node.path, c.opts)...)
+		run = nil
+	}
+
+	for _, child := range node.children {
+		if c.opts.CountTokens(content[child.span.start:child.span.end]) <= c.opts.MaxTokens {
+			run = append(run, child.span)
+			continue
+		}
+		flushRun()
+		chunks = append(chunks, c.chunkNode(content, child)...)
+	}
+	flushRun()
+
+	return chunks
+}
+
+// jsonParser is a minimal scanner recording the byte span of every value
+// Learning: encoding/json loses key order and offsets, which we need here.
+// The input is validated first, so the scanner can stay simple.
+type jsonParser struct {
+	content string
+	pos     int
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.content) && isSpace(p.content[p.pos]) {
+		p.pos++
+	}
+}
+
+// parseValue parses the value at p.pos; start is where the node's span begins
+// (the key position for object members)
+func (p *jsonParser) parseValue(path string, start int) *jsonNode {
+	node := &jsonNode{path: path}
+
+	switch p.content[p.pos] {
+	case '{':
+		p.pos++
+		for {
+			p.skipSpace()
+			if p.content[p.pos] == '}' {
+				p.pos++
+				break
+			}
+			keyStart := p.pos
+			key := p.parseString()
+			p.skipSpace()
+			p.pos++ // ':'
+			p.skipSpace()
+			node.children = append(node.children, p.parseValue(path+"."+key, keyStart))
+			p.skipSpace()
+			if p.content[p.pos] == ',' {
+				p.pos++
+			}
+		}
+	case '[':
+		p.pos++
+		for i := 0; ; i++ {
+			p.skipSpace()
+			if p.content[p.pos] == ']' {
+				p.pos++
+				break
+			}
+			node.children = append(node.children, p.parseValue(path+"["+strconv.Itoa(i)+"]", p.pos))
+			p.skipSpace()
+			if p.content[p.pos] == ',' {
+				p.pos++
+			}
+		}
+	case '"':
+		p.parseString()
+	default:
+		// Number, true, false or null
+		for p.pos < len(p.content) && !isSpace(p.content[p.pos]) &&
+			p.content[p.pos] != ',' && p.content[p.pos] != '}' && p.content[p.pos] != ']' {
+			p.pos++
+		}
+	}
+
+	node.span = span{start, p.pos}
+	return node
+}
+
+// parseString consumes a JSON string and returns its decoded value
+func (p *jsonParser) parseString() string {
+	start := p.pos
+	p.pos++ // Opening quote
+	for p.content[p.pos] != '"' {
+		if p.content[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	p.pos++ // Closing quote
+
+	var s string
+	if err := json.Unmarshal([]byte(p.content[start:p.pos]), &s); err != nil {
+		return p.content[start+1 : p.pos-1]
+	}
+	return s
+}
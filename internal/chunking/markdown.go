@@ -0,0 +1,198 @@
+package chunking
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MarkdownChunker splits markdown on its heading hierarchy
+// Code blocks and tables are kept whole whenever they fit the budget
+type MarkdownChunker struct {
+	opts Options
+}
+
+var headingPattern = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+
+// mdSection is the text under one heading (up to the next heading)
+type mdSection struct {
+	path    []string // Titles from the top-level heading down to this one
+	span    span
+	hasBody bool // False for a heading directly followed by another heading
+}
+
+// mdBlock is a paragraph, fenced code block, table or heading line
+type mdBlock struct {
+	span span
+	code bool // Fenced code or table: split by lines, never by sentences
+}
+
+// Chunk splits markdown into chunks that each carry their heading path
+func (c *MarkdownChunker) Chunk(content string) []Chunk {
+	var chunks []Chunk
+
+	for _, sec := range markdownSections(content) {
+		if !sec.hasBody {
+			continue // Its title is still part of every child section's path
+		}
+
+		var segs []span
+		for _, b := range markdownBlocks(content, sec.span) {
+			if b.code {
+				segs = append(segs, fit(content, b.span, c.opts, splitLines)...)
+			} else {
+				segs = append(segs, fit(content, b.span, c.opts, splitSentences, splitLines)...)
+			}
+		}
+
+		chunks = append(chunks, pack(content, segs, strings.Join(sec.path, " > "), c.opts)...)
+	}
+
+	return chunks
+}
+
+// lineSpans returns the span of every line (without the newline)
+func lineSpans(content string, s span) []span {
+	var lines []span
+	start := s.start
+	for i := s.start; i < s.end; i++ {
+		if content[i] == '\n' {
+			lines = append(lines, span{start, i})
+			start = i + 1
+		}
+	}
+	if start < s.end {
+		lines = append(lines, span{start, s.end})
+	}
+	return lines
+}
+
+// fenceMarker returns "```" or "~~~" if the line opens/closes a fenced code block
+func fenceMarker(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if strings.HasPrefix(trimmed, "```") {
+		return "```"
+	}
+	if strings.HasPrefix(trimmed, "~~~") {
+		return "~~~"
+	}
+	return ""
+}
+
+// markdownSections splits content at ATX headings (ignoring "#" inside code fences)
+func markdownSections(content string) []mdSection {
+	type heading struct {
+		level int
+		title string
+	}
+
+	var sections []mdSection
+	var stack []heading
+	current := mdSection{span: span{0, 0}}
+	fence := ""
+
+	closeSection := func(end int) {
+		current.span.end = end
+		if current.span.start < current.span.end {
+			sections = append(sections, current)
+		}
+	}
+
+	for _, ln := range lineSpans(content, span{0, len(content)}) {
+		line := content[ln.start:ln.end]
+
+		if fence != "" {
+			if fenceMarker(line) == fence {
+				fence = ""
+			}
+			current.hasBody = true
+			continue
+		}
+		if marker := fenceMarker(line); marker != "" {
+			fence = marker
+			current.hasBody = true
+			continue
+		}
+
+		m := headingPattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			if strings.TrimSpace(line) != "" {
+				current.hasBody = true
+			}
+			continue
+		}
+
+		// New heading: close the previous section and update the path
+		closeSection(ln.start)
+
+		level := len(m[1])
+		for len(stack) > 0 && stack[len(stack)-1].level >= level {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, heading{level: level, title: strings.TrimSpace(m[2])})
+
+		path := make([]string, len(stack))
+		for i, h := range stack {
+			path[i] = h.title
+		}
+		current = mdSection{path: path, span: span{ln.start, ln.start}}
+	}
+
+	closeSection(len(content))
+	return sections
+}
+
+// markdownBlocks splits a section into paragraphs, code blocks and tables
+func markdownBlocks(content string, s span) []mdBlock {
+	var blocks []mdBlock
+	var cur *mdBlock
+	fence := ""
+
+	flush := func() {
+		if cur != nil {
+			cur.span = trimSpan(content, cur.span)
+			if cur.span.start < cur.span.end {
+				blocks = append(blocks, *cur)
+			}
+			cur = nil
+		}
+	}
+	extend := func(ln span, code bool) {
+		if cur == nil || cur.code != code {
+			flush()
+			cur = &mdBlock{span: ln, code: code}
+		}
+		cur.span.end = ln.end
+	}
+
+	for _, ln := range lineSpans(content, s) {
+		line := content[ln.start:ln.end]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case fence != "":
+			// Inside a code block: everything belongs to it
+			extend(ln, true)
+			if fenceMarker(line) == fence {
+				fence = ""
+				flush()
+			}
+		case fenceMarker(line) != "":
+			flush()
+			fence = fenceMarker(line)
+			extend(ln, true)
+		case strings.HasPrefix(trimmed, "|"):
+			extend(ln, true) // Table row
+		case trimmed == "":
+			flush()
+		case headingPattern.MatchString(trimmed):
+			flush()
+			extend(ln, false)
+			flush() // Headings are their own block
+		default:
+			extend(ln, false)
+		}
+	}
+	flush()
+
+	return blocks
+}
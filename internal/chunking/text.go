@@ -0,0 +1,12 @@
+package chunking
+
+// TextChunker splits plain text on paragraphs, then sentences
+type TextChunker struct {
+	opts Options
+}
+
+// Chunk splits plain text into chunks
+func (c *TextChunker) Chunk(content string) []Chunk {
+	segs := fit(content, span{0, len(content)}, c.opts, splitParagraphs, splitSentences, splitLines)
+	return pack(content, segs, "", c.opts)
+}
@@ -3,10 +3,13 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
 
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
 type Config struct {
 	DBHost     string
 	DBPort     string
@@ -15,14 +18,64 @@ type Config struct {
 	DBName     string
 	DBSSLMode  string
 
-	OpenAIAPIKey string
+	// AI provider: "openai" or "local" (offline, deterministic)
+	LLMProvider             string
+	OpenAIAPIKey            string
+	OpenAIBaseURL           string // Any OpenAI-compatible server, e.g. http://localhost:11434/v1
+	OpenAIMaxRetries        int    // Retries for 429/5xx/network errors
+	OpenAIRequestsPerMinute int    // Client-side limit shared by all workers (0 = off)
+	LocalEmbeddingDims      int
+
+	// Models and generation
+	EmbeddingModel  string // Serves searches; "local-<dims>" with LLM_PROVIDER=local
+	EmbeddingTarget string // Model being filled in the background for a cutover (empty = none)
+	ChatModel       string
+	ChatTemperature *float64 // 0-2; lower is more deterministic (nil = server default)
+	ChatMaxTokens   int      // Completion length limit (0 = server default)
+	PromptsDir      string   // Prompt template overrides and sets; built-in templates if empty
 
 	ServerPort string
 	ServerHost string
 
 	// Worker pool configuration
-	EmbeddingWorkers   int
-	EmbeddingQueueSize int
+	EmbeddingWorkers     int
+	EmbeddingBatchSize   int // Chunks sent per embeddings API request
+	EmbeddingMaxAttempts int // Attempts per job before it is marked failed
+
+	// Embedding cache (content hash → vector)
+	EmbeddingCache     string // "postgres" (memory + table), "memory" or "off"
+	EmbeddingCacheSize int    // Texts kept in the in-process LRU per model
+
+	// Chunking and prompt budgeting
+	ChunkMaxTokens     int    // Upper bound per chunk
+	ChunkOverlapTokens int    // Tokens repeated between consecutive chunks
+	PromptMaxTokens    int    // Token budget for a whole prompt (context + question)
+	TokenizerBPEFile   string // Local cl100k_base.tiktoken file; downloaded and cached if empty
+
+	// Search
+	SearchDefaultMode    string  // "semantic", "keyword" or "hybrid" when a request doesn't say
+	HybridSemanticWeight float64 // Reciprocal rank fusion weight of the vector ranking
+	HybridKeywordWeight  float64 // Reciprocal rank fusion weight of the full-text ranking
+	HybridRRFK           int     // Reciprocal rank fusion damping constant
+
+	// Vector indexes (one per embedding model)
+	VectorIndexType    string // "hnsw" or "ivfflat"
+	HNSWM              int    // Graph links per node
+	HNSWEFConstruction int    // Candidates considered per insert
+	HNSWEFSearch       int    // Candidates kept per query (recall vs speed)
+	IVFFlatLists       int    // Clusters (0 = derived from the row count)
+	IVFFlatProbes      int    // Clusters scanned per query (recall vs speed)
+
+	// Re-ranking
+	Reranker        string  // "none", "llm" or "lexical"
+	RerankOverfetch int     // Candidates retrieved per chunk that ends up in the prompt
+	MMRLambda       float64 // 1 = pure relevance, lower values favor diverse chunks
+
+	// Collaboration
+	CollabSyncInterval    int // Seconds between writing collaborative edits back to documents
+	CollabCompactUpdates  int // Compact a document's Yjs log once it has this many updates...
+	CollabCompactBytes    int // ...or this many bytes (0 = never; both 0 turns compaction off)
+	CollabVersionInterval int // Seconds between version history snapshots of a live editing session
 
 	// Observability
 	JaegerEndpoint string
@@ -40,19 +93,124 @@ func Load() (*Config, error) {
 		DBName:     getEnv("DB_NAME", "ai_kms"),
 		DBSSLMode:  getEnv("DB_SSLMODE", "disable"),
 
-		OpenAIAPIKey: getEnv("OPENAI_API_KEY", ""),
+		LLMProvider:             getEnv("LLM_PROVIDER", "openai"),
+		OpenAIAPIKey:            getEnv("OPENAI_API_KEY", ""),
+		OpenAIBaseURL:           getEnv("OPENAI_BASE_URL", defaultOpenAIBaseURL),
+		OpenAIMaxRetries:        getEnvInt("OPENAI_MAX_RETRIES", 3),
+		OpenAIRequestsPerMinute: getEnvInt("OPENAI_REQUESTS_PER_MINUTE", 3000),
+		LocalEmbeddingDims:      getEnvInt("LOCAL_EMBEDDING_DIMS", 1536),
+
+		EmbeddingModel:  getEnv("EMBEDDING_MODEL", "text-embedding-ada-002"),
+		EmbeddingTarget: getEnv("EMBEDDING_MIGRATE_TO", ""),
+		ChatModel:       getEnv("CHAT_MODEL", "gpt-3.5-turbo"),
+		ChatTemperature: getEnvFloatPtr("CHAT_TEMPERATURE"),
+		ChatMaxTokens:   getEnvInt("CHAT_MAX_TOKENS", 0),
+		PromptsDir:      getEnv("PROMPTS_DIR", ""),
 
 		ServerPort: getEnv("SERVER_PORT", "8080"),
 		ServerHost: getEnv("SERVER_HOST", "localhost"),
 
-		EmbeddingWorkers:   getEnvInt("EMBEDDING_WORKERS", 5),
-		EmbeddingQueueSize: getEnvInt("EMBEDDING_QUEUE_SIZE", 100),
+		EmbeddingWorkers:     getEnvInt("EMBEDDING_WORKERS", 5),
+		EmbeddingBatchSize:   getEnvInt("EMBEDDING_BATCH_SIZE", 64),
+		EmbeddingMaxAttempts: getEnvInt("EMBEDDING_MAX_ATTEMPTS", 3),
+
+		EmbeddingCache:     getEnv("EMBEDDING_CACHE", "postgres"),
+		EmbeddingCacheSize: getEnvInt("EMBEDDING_CACHE_SIZE", 10000),
+
+		ChunkMaxTokens:     getEnvInt("CHUNK_MAX_TOKENS", 400),
+		ChunkOverlapTokens: getEnvInt("CHUNK_OVERLAP_TOKENS", 50),
+		PromptMaxTokens:    getEnvInt("PROMPT_MAX_TOKENS", 3000),
+		TokenizerBPEFile:   getEnv("TOKENIZER_BPE_FILE", ""),
+
+		SearchDefaultMode:    getEnv("SEARCH_DEFAULT_MODE", "semantic"),
+		HybridSemanticWeight: getEnvFloat("HYBRID_SEMANTIC_WEIGHT", 1.0),
+		HybridKeywordWeight:  getEnvFloat("HYBRID_KEYWORD_WEIGHT", 1.0),
+		HybridRRFK:           getEnvInt("HYBRID_RRF_K", 60),
+
+		VectorIndexType:    getEnv("VECTOR_INDEX_TYPE", "hnsw"),
+		HNSWM:              getEnvInt("HNSW_M", 16),
+		HNSWEFConstruction: getEnvInt("HNSW_EF_CONSTRUCTION", 64),
+		HNSWEFSearch:       getEnvInt("HNSW_EF_SEARCH", 40),
+		IVFFlatLists:       getEnvInt("IVFFLAT_LISTS", 0),
+		IVFFlatProbes:      getEnvInt("IVFFLAT_PROBES", 10),
+
+		Reranker:        getEnv("RERANKER", "none"),
+		RerankOverfetch: getEnvInt("RERANK_OVERFETCH", 4),
+		MMRLambda:       getEnvFloat("MMR_LAMBDA", 0.7),
+
+		CollabSyncInterval:    getEnvInt("COLLAB_SYNC_INTERVAL", 10),
+		CollabCompactUpdates:  getEnvInt("COLLAB_COMPACT_UPDATES", 0),
+		CollabCompactBytes:    getEnvInt("COLLAB_COMPACT_BYTES", 0),
+		CollabVersionInterval: getEnvInt("COLLAB_VERSION_INTERVAL", 300),
 
 		JaegerEndpoint: getEnv("JAEGER_ENDPOINT", "http://localhost:14268/api/traces"),
 	}
 
-	if cfg.OpenAIAPIKey == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY is required")
+	switch cfg.LLMProvider {
+	case "openai":
+		// Local OpenAI-compatible servers usually don't check keys
+		if cfg.OpenAIAPIKey == "" && cfg.OpenAIBaseURL == defaultOpenAIBaseURL {
+			return nil, fmt.Errorf("OPENAI_API_KEY is required (or set LLM_PROVIDER=local)")
+		}
+	case "local":
+		// No credentials needed - everything runs in-process
+		cfg.EmbeddingModel = getEnv("EMBEDDING_MODEL", fmt.Sprintf("local-%d", cfg.LocalEmbeddingDims))
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q (expected \"openai\" or \"local\")", cfg.LLMProvider)
+	}
+
+	if cfg.EmbeddingTarget == cfg.EmbeddingModel {
+		return nil, fmt.Errorf("EMBEDDING_MIGRATE_TO must differ from EMBEDDING_MODEL (unset it after the cutover)")
+	}
+
+	switch cfg.EmbeddingCache {
+	case "postgres", "memory", "off":
+	default:
+		return nil, fmt.Errorf("unknown EMBEDDING_CACHE %q (expected postgres, memory or off)", cfg.EmbeddingCache)
+	}
+	if cfg.EmbeddingCacheSize < 0 {
+		return nil, fmt.Errorf("EMBEDDING_CACHE_SIZE must not be negative")
+	}
+
+	if t := cfg.ChatTemperature; t != nil && (*t < 0 || *t > 2) {
+		return nil, fmt.Errorf("CHAT_TEMPERATURE must be between 0 and 2, got %g", *t)
+	}
+	if cfg.ChatMaxTokens < 0 {
+		return nil, fmt.Errorf("CHAT_MAX_TOKENS must not be negative")
+	}
+
+	switch cfg.SearchDefaultMode {
+	case "semantic", "keyword", "hybrid":
+	default:
+		return nil, fmt.Errorf("unknown SEARCH_DEFAULT_MODE %q (expected semantic, keyword or hybrid)", cfg.SearchDefaultMode)
+	}
+
+	switch cfg.VectorIndexType {
+	case "hnsw", "ivfflat":
+	default:
+		return nil, fmt.Errorf("unknown VECTOR_INDEX_TYPE %q (expected hnsw or ivfflat)", cfg.VectorIndexType)
+	}
+	if cfg.HNSWM < 2 || cfg.HNSWEFConstruction < 2*cfg.HNSWM {
+		return nil, fmt.Errorf("HNSW_M must be at least 2 and HNSW_EF_CONSTRUCTION at least twice HNSW_M")
+	}
+	if cfg.HNSWEFSearch < 1 || cfg.HNSWEFSearch > 1000 || cfg.IVFFlatProbes < 1 || cfg.IVFFlatLists < 0 {
+		return nil, fmt.Errorf("HNSW_EF_SEARCH must be 1-1000, IVFFLAT_PROBES positive and IVFFLAT_LISTS not negative")
+	}
+
+	switch cfg.Reranker {
+	case "none", "llm", "lexical":
+	default:
+		return nil, fmt.Errorf("unknown RERANKER %q (expected none, llm or lexical)", cfg.Reranker)
+	}
+
+	if cfg.CollabSyncInterval < 1 {
+		return nil, fmt.Errorf("COLLAB_SYNC_INTERVAL must be at least 1 second")
+	}
+	if cfg.CollabCompactUpdates < 0 || cfg.CollabCompactUpdates == 1 || cfg.CollabCompactBytes < 0 {
+		return nil, fmt.Errorf("COLLAB_COMPACT_UPDATES must be 0 (off) or at least 2, and COLLAB_COMPACT_BYTES 0 (off) or more")
+	}
+	if cfg.CollabVersionInterval < 1 {
+		return nil, fmt.Errorf("COLLAB_VERSION_INTERVAL must be at least 1 second")
 	}
 
 	return cfg, nil
@@ -81,3 +239,24 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvFloatPtr returns nil when the variable is unset, so "not configured"
+// stays distinguishable from 0
+// Learning: Some models and OpenAI-compatible servers reject temperature
+// altogether - only send one when it was asked for
+func getEnvFloatPtr(key string) *float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return &f
+		}
+	}
+	return nil
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
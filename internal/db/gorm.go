@@ -40,20 +40,96 @@ func NewGorm(cfg *config.Config) (*GormDB, error) {
 	if err := db.AutoMigrate(
 		&models.Document{},
 		&models.Embedding{},
-		&models.Link{},      // Knowledge graph links
-		&models.YjsUpdate{}, // CRDT updates
+		&models.Link{},                // Knowledge graph links
+		&models.YjsUpdate{},           // CRDT updates
+		&models.EmbeddingJob{},        // Durable embedding queue
+		&models.EmbeddingCacheEntry{}, // Embeddings by content hash
+		&models.DocumentSummary{},     // Cached map-reduce summaries
+		&models.Conversation{},        // Chat sessions
+		&models.ConversationMessage{}, // Chat history with sources
+		&models.DocumentVersion{},     // Version history
 	); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	// Create vector index for embeddings
-	// Note: This is done manually since GORM doesn't have built-in vector index support
+	// Summaries are now also keyed by prompt set and chat model
+	if err := db.Exec("DROP INDEX IF EXISTS idx_document_summaries_key").Error; err != nil {
+		return nil, fmt.Errorf("failed to drop old summary cache index: %w", err)
+	}
+
+	// Embeddings from before model tagging
+	// Learning: The column used to be vector(1536) with one IVFFlat index. It
+	// now holds any dimension, with a partial index per model (created by the
+	// embedding repository), and old rows are labeled with the configured model.
+	var columnType string
+	err = db.Raw(`
+		SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = 'embeddings'::regclass AND attname = 'embedding'
+	`).Scan(&columnType).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect embeddings table: %w", err)
+	}
+	if columnType != "vector" {
+		err = db.Exec(`
+			DROP INDEX IF EXISTS idx_embeddings_vector;
+			ALTER TABLE embeddings ALTER COLUMN embedding TYPE vector;
+		`).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate embedding column: %w", err)
+		}
+	}
+	err = db.Exec(`
+		UPDATE embeddings SET model = ?, dimensions = vector_dims(embedding)
+		WHERE model = ''
+	`, cfg.EmbeddingModel).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to label embeddings with their model: %w", err)
+	}
+
+	// Embeddings from before character offsets were stored
+	// Learning: Only chunks whose bytes still sit at their offsets are
+	// counted - there the offset is a character boundary, so convert_from
+	// can't fail. The rest get theirs when the document is re-embedded
+	err = db.Exec(`
+		UPDATE embeddings e SET
+			char_start = char_length(convert_from(substring(convert_to(d.content, 'UTF8') from 1 for e.start_offset), 'UTF8')),
+			char_end = char_length(convert_from(substring(convert_to(d.content, 'UTF8') from 1 for e.start_offset), 'UTF8')) + char_length(e.chunk_text)
+		FROM documents d
+		WHERE d.id = e.document_id AND e.char_end = 0 AND e.chunk_text <> ''
+			AND substring(convert_to(d.content, 'UTF8') from e.start_offset + 1 for e.end_offset - e.start_offset) = convert_to(e.chunk_text, 'UTF8')
+	`).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count embedding character offsets: %w", err)
+	}
+
+	// Full-text search columns for keyword and hybrid search
+	// Learning: Generated columns keep the tsvector in sync on every write.
+	// 'english' stems words (restarting → restart), 'simple' keeps exact
+	// tokens like error codes and acronyms. Documents only index the title -
+	// a tsvector is capped at 1MB, which a whole document can exceed.
+	// The metadata index serves the @> containment used by search filters.
 	err = db.Exec(`
-		CREATE INDEX IF NOT EXISTS idx_embeddings_vector 
-		ON embeddings USING ivfflat (embedding vector_cosine_ops)
+		ALTER TABLE embeddings ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(section, '')), 'A') ||
+			setweight(to_tsvector('english', chunk_text), 'B') ||
+			to_tsvector('simple', coalesce(section, '') || ' ' || chunk_text)
+		) STORED;
+		CREATE INDEX IF NOT EXISTS idx_embeddings_search
+		ON embeddings USING gin (search_vector);
+
+		ALTER TABLE documents ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			to_tsvector('english', title) || to_tsvector('simple', title)
+		) STORED;
+		CREATE INDEX IF NOT EXISTS idx_documents_search
+		ON documents USING gin (search_vector);
+
+		CREATE INDEX IF NOT EXISTS idx_documents_metadata
+		ON documents USING gin (metadata jsonb_path_ops);
 	`).Error
 	if err != nil {
-		return nil, fmt.Errorf("failed to create vector index: %w", err)
+		return nil, fmt.Errorf("failed to create search indexes: %w", err)
 	}
 
 	log.Println("✓ Database connected and migrated successfully")
@@ -0,0 +1,246 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+LEARNING: LINE DIFFS (MYERS)
+
+A diff is the shortest edit script turning one list of lines into another:
+keep, delete or insert, one line at a time. Myers' algorithm finds it by
+exploring edit scripts in order of length - D deletions plus insertions -
+and remembering, per diagonal k = x - y, how far along both texts it got.
+
+  a: Restart the pod      b: Restart the pgbouncer pod
+     Check logs              Check logs
+     Done                    Escalate
+                             Done
+
+  shortest script (D = 3): -"Restart the pod" +"Restart the pgbouncer pod" +"Escalate"
+
+Matching lines are free diagonal moves ("snakes"), so similar documents are
+diffed in roughly O((N+M)·D) time instead of the O(N·M) of a full table.
+
+The result is printed as a unified diff: changed lines with a few lines of
+context, grouped into "@@ -old +new @@" hunks.
+*/
+
+// Op is the kind of an edit
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit is one line of an edit script
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// Lines diffs two lists of lines
+func Lines(a, b []string) []Edit {
+	// Common prefix and suffix don't need the search
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]Edit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		edits = append(edits, Edit{Op: Equal, Line: line})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, Edit{Op: Equal, Line: line})
+	}
+	return edits
+}
+
+// myers returns a shortest edit script from a to b
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3) // v[k+offset]: furthest x reached on diagonal k
+	var trace [][]int          // trace[d]: v before step d, diagonals -d-1..d+1
+
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset] // Down: insert b[y]
+			} else {
+				x = v[k-1+offset] + 1 // Right: delete a[x]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+offset] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, d)
+			}
+		}
+	}
+	return nil // Unreachable: D = N+M always reaches the end
+}
+
+// backtrack walks the saved frontiers back from the end to recover the script
+func backtrack(a, b []string, trace [][]int, d int) []Edit {
+	edits := make([]Edit, 0, len(a)+len(b))
+	x, y := len(a), len(b)
+
+	for ; d >= 0; d-- {
+		v := trace[d]
+		offset := d + 1
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+offset]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, Edit{Op: Equal, Line: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				edits = append(edits, Edit{Op: Insert, Line: b[y]})
+			} else {
+				x--
+				edits = append(edits, Edit{Op: Delete, Line: a[x]})
+			}
+		}
+	}
+
+	// Built from the end
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// Stats counts inserted and deleted lines
+func Stats(edits []Edit) (added, removed int) {
+	for _, e := range edits {
+		switch e.Op {
+		case Insert:
+			added++
+		case Delete:
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// Unified renders an edit script as a unified diff with context lines
+// around each change; "" when nothing changed
+func Unified(edits []Edit, fromName, toName string, context int) string {
+	var b strings.Builder
+
+	for start := 0; start < len(edits); {
+		// Find the next change
+		for start < len(edits) && edits[start].Op == Equal {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+
+		// Extend the hunk while changes are within 2*context lines of each other
+		end := start
+		for i := start; i < len(edits); i++ {
+			if edits[i].Op != Equal {
+				end = i + 1
+			} else if i-end >= 2*context {
+				break
+			}
+		}
+
+		from := max(start-context, 0)
+		to := min(end+context, len(edits))
+
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		oldStart, newStart := position(edits, from)
+		oldLines, newLines := 0, 0
+		for _, e := range edits[from:to] {
+			if e.Op != Insert {
+				oldLines++
+			}
+			if e.Op != Delete {
+				newLines++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldLines), hunkRange(newStart, newLines))
+
+		for _, e := range edits[from:to] {
+			switch e.Op {
+			case Equal:
+				b.WriteString(" ")
+			case Delete:
+				b.WriteString("-")
+			case Insert:
+				b.WriteString("+")
+			}
+			b.WriteString(e.Line)
+			b.WriteString("\n")
+		}
+
+		start = to
+	}
+
+	return b.String()
+}
+
+// position returns the 0-based old and new line numbers at edit i
+func position(edits []Edit, i int) (oldLine, newLine int) {
+	for _, e := range edits[:i] {
+		if e.Op != Insert {
+			oldLine++
+		}
+		if e.Op != Delete {
+			newLine++
+		}
+	}
+	return oldLine, newLine
+}
+
+// hunkRange formats "start,count" the way diff(1) does: 1-based, and an
+// empty range names the line before it
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// SplitLines splits text into lines; a trailing newline doesn't start another line
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
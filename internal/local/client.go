@@ -0,0 +1,395 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"ai-kms/internal/openai"
+)
+
+/*
+LEARNING: OFFLINE AI PROVIDER
+
+This package implements the same methods as openai.Client without any network
+calls, so the whole server can run on air-gapped machines and in CI.
+
+Embeddings use the "hashing trick":
+  text → lowercase words (+ word bigrams) → hash each term into one of N buckets
+       → add ±1 (sign also comes from the hash) → L2-normalize
+
+Texts sharing words end up with a high cosine similarity, which is enough
+for keyword-ish semantic search to behave sensibly.
+
+Chat completions are extractive: instead of generating new text, we pick the
+sentences from the task's inputs that best match the question (or that best
+represent the document, for summaries) and return them in a fixed template.
+The prompt itself is never read - callers say what they want in a ChatTask.
+
+Both are fully deterministic - the same input always gives the same output.
+*/
+
+// Client is an offline, deterministic stand-in for the OpenAI client
+type Client struct {
+	dims int
+}
+
+// NewClient creates a local client producing vectors with the given dimensions
+// Learning: Different dims are different embedding models (see ModelName)
+func NewClient(dims int) *Client {
+	if dims <= 0 {
+		dims = 1536
+	}
+	return &Client{dims: dims}
+}
+
+// ModelName is the embedding model name of a local client with the given dimensions
+func ModelName(dims int) string {
+	return fmt.Sprintf("local-%d", dims)
+}
+
+// ModelDimensions parses a local embedding model name ("local-384")
+func ModelDimensions(model string) (int, error) {
+	dims, err := strconv.Atoi(strings.TrimPrefix(model, "local-"))
+	if !strings.HasPrefix(model, "local-") || err != nil || dims <= 0 {
+		return 0, fmt.Errorf("invalid local embedding model %q (expected local-<dimensions>)", model)
+	}
+	return dims, nil
+}
+
+// Dimensions returns the size of the vectors produced by CreateEmbeddings
+func (c *Client) Dimensions() int {
+	return c.dims
+}
+
+// CreateEmbeddings returns one hashed bag-of-words vector per input text
+func (c *Client) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no input texts")
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = c.embed(text)
+	}
+	return embeddings, nil
+}
+
+// embed computes the hashed bag-of-words vector for a single text
+func (c *Client) embed(text string) []float32 {
+	vec := make([]float32, c.dims)
+	words := tokenize(text)
+
+	add := func(term string, weight float32) {
+		h := fnv.New64a()
+		h.Write([]byte(term))
+		sum := h.Sum64()
+
+		idx := int(sum % uint64(c.dims))
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vec[idx] += weight
+	}
+
+	for i, w := range words {
+		add(w, 1)
+		if i > 0 {
+			add(words[i-1]+" "+w, 0.5) // Bigrams capture a bit of word order
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		// Empty text: return a fixed unit vector so cosine distance stays defined
+		vec[0] = 1
+		return vec
+	}
+
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vec {
+		vec[i] *= scale
+	}
+	return vec
+}
+
+// ChatModelName names the chat model, for caches of generated text
+func (c *Client) ChatModelName() string {
+	return "local-extractive"
+}
+
+// ChatCompletion answers with sentences extracted from the task's inputs
+// Learning: Keeps the RAG pipeline working end-to-end without an LLM. The
+// messages are ignored - the task says what was asked, whatever the wording
+func (c *Client) ChatCompletion(ctx context.Context, task openai.ChatTask, messages []openai.ChatMessage) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	switch task.Kind {
+	case openai.TaskKeywords:
+		count := task.Count
+		if count <= 0 {
+			count = 5
+		}
+		return extractKeywords(task.Text, count), nil
+
+	case openai.TaskCondense:
+		// Add the conversation's main topics to the question
+		if topics := extractKeywords(task.Text, 3); topics != "" {
+			return task.Query + " (" + topics + ")", nil
+		}
+		return task.Query, nil
+
+	case openai.TaskSummarize:
+		// Pick the most representative sentences
+		sentences := splitSentences(task.Text)
+		picked := topSentences(sentences, termFrequencies(sentences), 3)
+		if len(picked) == 0 {
+			return "The document is empty.", nil
+		}
+		return "Summary: " + strings.Join(picked, " "), nil
+
+	case openai.TaskRerank:
+		return gradePassages(task.Query, task.Passages), nil
+
+	case openai.TaskAnswer:
+		return extractAnswer(task.Query, task.Passages), nil
+
+	default:
+		return "", fmt.Errorf("unsupported chat task %q", task.Kind)
+	}
+}
+
+// extractAnswer picks the context sentences sharing the most terms with the question
+func extractAnswer(question string, passages []string) string {
+	questionTerms := queryTerms(question)
+
+	// Cite each picked sentence the way the RAG prompt asks a real model to
+	var sentences []string
+	var sources []int
+	for i, p := range passages {
+		for _, s := range splitSentences(p) {
+			sentences = append(sentences, s)
+			sources = append(sources, i+1)
+		}
+	}
+	picked := topSentenceIndices(sentences, questionTerms, 3)
+	if len(picked) == 0 {
+		return "I couldn't find an answer to that in the provided context."
+	}
+
+	cited := make([]string, len(picked))
+	for i, idx := range picked {
+		cited[i] = fmt.Sprintf("%s [%d]", sentences[idx], sources[idx])
+	}
+	return "Based on the provided context: " + strings.Join(cited, " ")
+}
+
+// gradePassages grades each passage 0-10 by the share of the question's
+// terms it contains, as the JSON array the reranker expects
+func gradePassages(question string, passages []string) string {
+	terms := queryTerms(question)
+
+	grades := make([]string, len(passages))
+	for i, p := range passages {
+		found := make(map[string]bool)
+		for _, w := range tokenize(p) {
+			if terms[w] > 0 {
+				found[w] = true
+			}
+		}
+		grade := 0
+		if len(terms) > 0 {
+			grade = int(math.Round(10 * float64(len(found)) / float64(len(terms))))
+		}
+		grades[i] = strconv.Itoa(grade)
+	}
+	return "[" + strings.Join(grades, ", ") + "]"
+}
+
+// queryTerms returns the non-stopword terms of a question, each weighted 1
+func queryTerms(question string) map[string]int {
+	terms := make(map[string]int)
+	for _, w := range tokenize(question) {
+		if !stopWords[w] {
+			terms[w] = 1
+		}
+	}
+	return terms
+}
+
+// ChatCompletionStream delivers the extractive answer one word at a time
+// Learning: Nothing is generated incrementally here, but streaming callers
+// exercise the same code path they would against the real API
+func (c *Client) ChatCompletionStream(ctx context.Context, task openai.ChatTask, messages []openai.ChatMessage, onDelta func(string) error) (string, error) {
+	answer, err := c.ChatCompletion(ctx, task, messages)
+	if err != nil {
+		return "", err
+	}
+
+	var sent strings.Builder
+	for _, word := range strings.SplitAfter(answer, " ") {
+		if err := ctx.Err(); err != nil {
+			return sent.String(), err
+		}
+		if err := onDelta(word); err != nil {
+			return sent.String(), err
+		}
+		sent.WriteString(word)
+	}
+	return answer, nil
+}
+
+// extractKeywords returns the most frequent non-stopword terms
+func extractKeywords(text string, count int) string {
+	freq := make(map[string]int)
+	var order []string
+	for _, w := range tokenize(text) {
+		if stopWords[w] || len(w) < 3 {
+			continue
+		}
+		if freq[w] == 0 {
+			order = append(order, w)
+		}
+		freq[w]++
+	}
+
+	// Stable sort keeps first-seen order for ties (deterministic output)
+	sort.SliceStable(order, func(i, j int) bool {
+		return freq[order[i]] > freq[order[j]]
+	})
+
+	if len(order) > count {
+		order = order[:count]
+	}
+	return strings.Join(order, ", ")
+}
+
+// termFrequencies counts non-stopword terms across all sentences
+func termFrequencies(sentences []string) map[string]int {
+	freq := make(map[string]int)
+	for _, s := range sentences {
+		for _, w := range tokenize(s) {
+			if !stopWords[w] {
+				freq[w]++
+			}
+		}
+	}
+	return freq
+}
+
+// topSentences scores sentences by the weights of the terms they contain
+// and returns the best n in their original order
+func topSentences(sentences []string, weights map[string]int, n int) []string {
+	picked := topSentenceIndices(sentences, weights, n)
+	result := make([]string, len(picked))
+	for i, idx := range picked {
+		result[i] = sentences[idx]
+	}
+	return result
+}
+
+// topSentenceIndices is topSentences, returning positions instead of text
+func topSentenceIndices(sentences []string, weights map[string]int, n int) []int {
+	type scored struct {
+		idx   int
+		score float64
+	}
+
+	candidates := make([]scored, 0, len(sentences))
+	for i, s := range sentences {
+		words := tokenize(s)
+		if len(words) == 0 {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var score float64
+		for _, w := range words {
+			if !seen[w] {
+				score += float64(weights[w])
+				seen[w] = true
+			}
+		}
+		if score == 0 {
+			continue
+		}
+
+		// Normalize so long sentences don't always win
+		candidates = append(candidates, scored{idx: i, score: score / math.Sqrt(float64(len(words)))})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].idx < candidates[j].idx
+	})
+
+	result := make([]int, len(candidates))
+	for i, c := range candidates {
+		result[i] = c.idx
+	}
+	return result
+}
+
+// splitSentences breaks text into sentences on terminal punctuation and newlines
+func splitSentences(text string) []string {
+	var sentences []string
+	var current strings.Builder
+
+	flush := func() {
+		s := strings.TrimSpace(current.String())
+		if s != "" {
+			sentences = append(sentences, s)
+		}
+		current.Reset()
+	}
+
+	runes := []rune(text)
+	for i, r := range runes {
+		if r == '\n' {
+			flush()
+			continue
+		}
+		current.WriteRune(r)
+		if (r == '.' || r == '!' || r == '?') && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])) {
+			flush()
+		}
+	}
+	flush()
+
+	return sentences
+}
+
+// tokenize lowercases text and splits it into letter/digit runs
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "can": true, "do": true, "does": true, "for": true,
+	"from": true, "has": true, "have": true, "how": true, "i": true, "in": true,
+	"is": true, "it": true, "its": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "to": true, "was": true, "we": true,
+	"what": true, "when": true, "where": true, "which": true, "who": true,
+	"why": true, "will": true, "with": true, "you": true, "your": true,
+}
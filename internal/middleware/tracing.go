@@ -155,6 +155,13 @@ func (w *responseWriterWrapper) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController
+// Learning: Without it, handlers behind this middleware can't flush
+// (streaming) or lift the server's write timeout
+func (w *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // Helper functions for creating spans in application code
 
 // StartSpan creates a new span from the given context
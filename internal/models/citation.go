@@ -0,0 +1,18 @@
+package models
+
+// Citation links a [n] marker in an answer to the chunk it refers to
+type Citation struct {
+	Marker     int    `json:"marker"` // The n in [n], as numbered in the prompt context
+	DocumentID string `json:"document_id"`
+	ChunkIndex int    `json:"chunk_index"`
+	Title      string `json:"title"`
+	Section    string `json:"section,omitempty"`
+}
+
+// AnswerSegment is a piece of an answer and the sources it cites
+// Learning: Segments let a UI render each claim next to its sources, and
+// make uncited claims easy to spot
+type AnswerSegment struct {
+	Text      string `json:"text"`                // Answer text with the markers removed
+	Citations []int  `json:"citations,omitempty"` // Markers supporting this text
+}
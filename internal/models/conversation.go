@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"gorm.io/gorm"
+)
+
+// Conversation is a multi-turn chat with the knowledge base
+type Conversation struct {
+	ID        string                `json:"id" gorm:"type:char(27);primaryKey"`
+	Title     string                `json:"title" gorm:"type:text;not null"`
+	Messages  []ConversationMessage `json:"messages,omitempty" gorm:"foreignKey:ConversationID;constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time             `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time             `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+// BeforeCreate hook generates KSUID before inserting
+func (c *Conversation) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == "" {
+		c.ID = ksuid.New().String()
+	}
+	return nil
+}
+
+// TableName override
+func (Conversation) TableName() string {
+	return "conversations"
+}
+
+// ConversationMessage is one user or assistant turn
+// Learning: Assistant turns keep the chunks they were grounded on, so an
+// answer can be audited long after the documents have changed
+type ConversationMessage struct {
+	ID             string `json:"id" gorm:"type:char(27);primaryKey"`
+	ConversationID string `json:"conversation_id" gorm:"type:char(27);not null;index:idx_conversation_messages_conv,priority:1"`
+	Role           string `json:"role" gorm:"type:varchar(20);not null"` // "user" or "assistant"
+	Content        string `json:"content" gorm:"type:text;not null"`
+
+	// Assistant turns only
+	RetrievalQuery   string          `json:"retrieval_query,omitempty" gorm:"type:text"` // Standalone query used for search
+	Sources          []*SearchResult `json:"sources,omitempty" gorm:"type:jsonb;serializer:json"`
+	Citations        []Citation      `json:"citations,omitempty" gorm:"type:jsonb;serializer:json"`
+	Grounded         bool            `json:"grounded"`
+	PromptTokens     int             `json:"prompt_tokens,omitempty"`
+	CompletionTokens int             `json:"completion_tokens,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime;index:idx_conversation_messages_conv,priority:2"`
+}
+
+// BeforeCreate hook generates KSUID before inserting
+func (m *ConversationMessage) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == "" {
+		m.ID = ksuid.New().String()
+	}
+	return nil
+}
+
+// TableName override
+func (ConversationMessage) TableName() string {
+	return "conversation_messages"
+}
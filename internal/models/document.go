@@ -1,6 +1,8 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
 	"github.com/segmentio/ksuid"
@@ -22,14 +24,16 @@ const (
 // - Smaller string representation (27 chars vs 36 for UUID)
 // - No collisions across distributed systems
 type Document struct {
-	ID        string         `json:"id" gorm:"type:char(27);primaryKey"`
-	Title     string         `json:"title" gorm:"type:text;not null"`
-	Content   string         `json:"content" gorm:"type:text;not null"`
-	Format    DocumentFormat `json:"format" gorm:"type:varchar(50);not null;default:'markdown'"`
-	Metadata  map[string]any `json:"metadata" gorm:"type:jsonb;default:'{}'"`
-	CreatedAt time.Time      `json:"created_at" gorm:"column:created_at;autoCreateTime"`
-	UpdatedAt time.Time      `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
-	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"column:deleted_at;index"` // Soft delete support
+	ID       string         `json:"id" gorm:"type:char(27);primaryKey"`
+	Title    string         `json:"title" gorm:"type:text;not null"`
+	Content  string         `json:"content" gorm:"type:text;not null"`
+	Format   DocumentFormat `json:"format" gorm:"type:varchar(50);not null;default:'markdown'"`
+	Metadata map[string]any `json:"metadata" gorm:"type:jsonb;default:'{}'"`
+	// ContentHash is the SHA-256 of Content - lets us skip re-embedding unchanged text
+	ContentHash string         `json:"content_hash" gorm:"type:char(64)"`
+	CreatedAt   time.Time      `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt   time.Time      `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+	DeletedAt   gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"column:deleted_at;index"` // Soft delete support
 }
 
 // BeforeCreate hook generates KSUID before inserting
@@ -37,14 +41,25 @@ func (d *Document) BeforeCreate(tx *gorm.DB) error {
 	if d.ID == "" {
 		d.ID = ksuid.New().String()
 	}
+	if d.ContentHash == "" {
+		d.ContentHash = HashContent(d.Content)
+	}
 	return nil
 }
 
+// HashContent returns the hex SHA-256 of document content
+// Learning: Comparing hashes is a cheap way to tell whether text really changed
+func HashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
 type DocumentCreate struct {
 	Title    string         `json:"title"`
 	Content  string         `json:"content"`
 	Format   DocumentFormat `json:"format"`
 	Metadata map[string]any `json:"metadata"`
+	Author   string         `json:"author,omitempty"` // Recorded in version history
 }
 
 type DocumentUpdate struct {
@@ -52,4 +67,5 @@ type DocumentUpdate struct {
 	Content  *string         `json:"content,omitempty"`
 	Format   *DocumentFormat `json:"format,omitempty"`
 	Metadata map[string]any  `json:"metadata,omitempty"`
+	Author   string          `json:"author,omitempty"` // Recorded in version history
 }
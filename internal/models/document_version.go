@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"gorm.io/gorm"
+)
+
+// VersionSource tells how a document version came about
+type VersionSource string
+
+const (
+	VersionSourceBaseline      VersionSource = "baseline"      // State before the first recorded edit (documents older than version history)
+	VersionSourceCreate        VersionSource = "create"        // Document created through the API
+	VersionSourceEdit          VersionSource = "edit"          // Update through the API
+	VersionSourceCollaboration VersionSource = "collaboration" // Periodic snapshot of a live editing session
+	VersionSourceRestore       VersionSource = "restore"       // An earlier version restored as a new edit
+)
+
+// DocumentVersion is a snapshot of a document's title and content
+// Learning: Versions are never modified - restoring one records a new
+// version, so history only ever grows and every state stays reachable
+type DocumentVersion struct {
+	ID           string         `json:"id" gorm:"type:char(27);primaryKey"` // KSUID
+	DocumentID   string         `json:"document_id" gorm:"type:char(27);not null;index:idx_document_versions_history,priority:1"`
+	Title        string         `json:"title" gorm:"type:text;not null"`
+	Content      string         `json:"content,omitempty" gorm:"type:text;not null"` // Left out of listings
+	Format       DocumentFormat `json:"format" gorm:"type:varchar(50);not null"`
+	ContentHash  string         `json:"content_hash" gorm:"type:char(64);not null"`
+	Author       string         `json:"author" gorm:"type:varchar(255)"`
+	Source       VersionSource  `json:"source" gorm:"type:varchar(20);not null"`
+	RestoredFrom *string        `json:"restored_from,omitempty" gorm:"type:char(27)"` // Version this one restored
+	CreatedAt    time.Time      `json:"created_at" gorm:"column:created_at;autoCreateTime;index:idx_document_versions_history,priority:2"`
+}
+
+// BeforeCreate hook generates KSUID before inserting
+func (v *DocumentVersion) BeforeCreate(tx *gorm.DB) error {
+	if v.ID == "" {
+		v.ID = ksuid.New().String()
+	}
+	return nil
+}
+
+// TableName override
+func (DocumentVersion) TableName() string {
+	return "document_versions"
+}
+
+// VersionDiff is a line-based comparison of two versions of a document
+type VersionDiff struct {
+	DocumentID string `json:"document_id"`
+	From       string `json:"from"` // Version ID
+	To         string `json:"to"`   // Version ID, or "current"
+	Added      int    `json:"added"`
+	Removed    int    `json:"removed"`
+	Diff       string `json:"diff"` // Unified diff; empty when the contents are equal
+}
@@ -8,16 +8,45 @@ import (
 	"gorm.io/gorm"
 )
 
+/*
+LEARNING: SEVERAL EMBEDDING MODELS IN ONE TABLE
+
+Vectors from different models can't be compared - not even when they happen
+to have the same size. So every row records the model that produced it, and
+the column is a plain "vector" without a fixed dimension:
+
+  model                    dimensions  embedding
+  text-embedding-ada-002   1536        [0.01, -0.2, ...]
+  text-embedding-3-small   512         [0.3, 0.07, ...]
+
+An ANN index needs a fixed dimension, so each model gets a PARTIAL index
+over a cast of the column:
+
+  CREATE INDEX ... USING ivfflat ((embedding::vector(512)) vector_cosine_ops)
+  WHERE model = 'text-embedding-3-small'
+
+Queries filter on the model and use the same cast, so Postgres picks that
+model's index. This lets a new model be filled in the background while the
+old one keeps serving searches.
+*/
+
 // Embedding represents a document chunk with its vector embedding
 // Using KSUID for time-ordered IDs and better database performance
 type Embedding struct {
-	ID         string          `json:"id" gorm:"type:char(27);primaryKey"`
-	DocumentID string          `json:"document_id" gorm:"type:char(27);not null;index"`
-	ChunkIndex int             `json:"chunk_index" gorm:"not null"`
-	ChunkText  string          `json:"chunk_text" gorm:"type:text;not null"`
-	Embedding  pgvector.Vector `json:"embedding" gorm:"type:vector(1536);not null"`
-	CreatedAt  time.Time       `json:"created_at" gorm:"column:created_at;autoCreateTime"`
-	DeletedAt  gorm.DeletedAt  `json:"deleted_at,omitempty" gorm:"column:deleted_at;index"` // Soft delete
+	ID          string          `json:"id" gorm:"type:char(27);primaryKey"`
+	DocumentID  string          `json:"document_id" gorm:"type:char(27);not null;index"`
+	ChunkIndex  int             `json:"chunk_index" gorm:"not null"`
+	ChunkText   string          `json:"chunk_text" gorm:"type:text;not null"`
+	Section     string          `json:"section,omitempty" gorm:"type:text"` // Heading path / JSON path of the chunk
+	StartOffset int             `json:"start_offset"`                       // Byte offsets of the chunk in Document.Content
+	EndOffset   int             `json:"end_offset"`
+	CharStart   int             `json:"char_start" gorm:"not null;default:0"` // The same in characters, as search results report them
+	CharEnd     int             `json:"char_end" gorm:"not null;default:0"`
+	Model       string          `json:"model" gorm:"type:varchar(100);not null;default:'';index"` // Embedding model that produced the vector
+	Dimensions  int             `json:"dimensions" gorm:"not null;default:0"`
+	Embedding   pgvector.Vector `json:"embedding" gorm:"type:vector;not null"` // No fixed size - see the per-model indexes
+	CreatedAt   time.Time       `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	DeletedAt   gorm.DeletedAt  `json:"deleted_at,omitempty" gorm:"column:deleted_at;index"` // Soft delete
 
 	// Relationship
 	Document Document `json:"document,omitempty" gorm:"foreignKey:DocumentID;constraint:OnDelete:CASCADE"`
@@ -31,10 +60,62 @@ func (e *Embedding) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// EmbeddingModelStats describes the chunks stored for one embedding model
+type EmbeddingModelStats struct {
+	Model      string `json:"model"`
+	Dimensions int    `json:"dimensions"`
+	Documents  int64  `json:"documents"`
+	Chunks     int64  `json:"chunks"`
+	Active     bool   `json:"active"` // Serves searches
+	Target     bool   `json:"target"` // Being filled by a migration
+}
+
+// EmbeddingCoverage tells which documents still lack chunks for a model
+type EmbeddingCoverage struct {
+	Model     string   `json:"model"`
+	Documents int64    `json:"documents"` // Live documents with content
+	Missing   []string `json:"-"`         // IDs of those without chunks for the model
+}
+
+// EmbeddingMigrationStatus reports progress of re-embedding the corpus into a new model
+type EmbeddingMigrationStatus struct {
+	ActiveModel string `json:"active_model"`
+	TargetModel string `json:"target_model,omitempty"`
+	Documents   int64  `json:"documents"`
+	Remaining   int    `json:"remaining"`    // Documents without target-model chunks
+	PendingJobs int64  `json:"pending_jobs"` // Queued or running migration jobs
+	Complete    bool   `json:"complete"`     // Safe to cut over
+}
+
 // SearchResult represents a semantic search result
 type SearchResult struct {
-	DocumentID string  `json:"document_id"`
-	Title      string  `json:"title"`
-	ChunkText  string  `json:"chunk_text"`
-	Score      float32 `json:"score"` // Similarity score (0-1)
+	DocumentID   string  `json:"document_id"`
+	Title        string  `json:"title"`
+	ChunkText    string  `json:"chunk_text"`
+	Section      string  `json:"section,omitempty"` // Heading path the chunk came from
+	ChunkIndex   int     `json:"chunk_index"`
+	StartOffset  int     `json:"start_offset"` // Character offsets of the chunk in the document
+	EndOffset    int     `json:"end_offset"`
+	Score        float32 `json:"score"`                   // Similarity score (0-1)
+	Snippet      string  `json:"snippet,omitempty"`       // HTML-escaped excerpt with <mark> around query terms
+	OtherMatches int     `json:"other_matches,omitempty"` // More matching chunks in the same document (grouped results)
+}
+
+// HybridWeights tunes reciprocal rank fusion in hybrid search
+type HybridWeights struct {
+	Semantic float64 // Weight of the vector ranking
+	Keyword  float64 // Weight of the full-text ranking
+	K        int     // Damping constant; higher values flatten the rank curve
+}
+
+// SearchFilters narrows a search to a subset of documents
+// Zero values mean "no filter"
+type SearchFilters struct {
+	Format      *DocumentFormat `json:"format,omitempty"`
+	MinScore    float32         `json:"min_score,omitempty"`    // Drop results scoring below this
+	DateFrom    *time.Time      `json:"date_from,omitempty"`    // Documents created at or after
+	DateTo      *time.Time      `json:"date_to,omitempty"`      // Documents created before
+	ExcludeIDs  []string        `json:"exclude_ids,omitempty"`  // Document IDs to leave out
+	DocumentIDs []string        `json:"document_ids,omitempty"` // Only search these documents
+	Metadata    map[string]any  `json:"metadata,omitempty"`     // Documents whose metadata contains all these keys/values
 }
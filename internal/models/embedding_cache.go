@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// EmbeddingCacheEntry is the stored embedding of one piece of text
+// Learning: Keyed by the SHA-256 of the exact text sent to the model, so
+// any chunk or query seen before - in any document - is free to embed again
+type EmbeddingCacheEntry struct {
+	Model       string          `json:"model" gorm:"type:varchar(100);primaryKey"`
+	ContentHash string          `json:"content_hash" gorm:"type:char(64);primaryKey"`
+	Embedding   pgvector.Vector `json:"-" gorm:"type:vector;not null"`
+	CreatedAt   time.Time       `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+// TableName override
+func (EmbeddingCacheEntry) TableName() string {
+	return "embedding_cache"
+}
+
+// EmbeddingCacheStats counts where the embeddings of one model came from
+// since the process started
+type EmbeddingCacheStats struct {
+	Model      string  `json:"model"`
+	MemoryHits int64   `json:"memory_hits"` // Served from the in-process LRU
+	StoreHits  int64   `json:"store_hits"`  // Served from the embedding_cache table
+	Misses     int64   `json:"misses"`      // Sent to the embedding provider
+	HitRate    float64 `json:"hit_rate"`    // (memory + store hits) / lookups, 0-1
+	Entries    int     `json:"entries"`     // Texts currently held in memory
+	Capacity   int     `json:"capacity"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"gorm.io/gorm"
+)
+
+/*
+LEARNING: DURABLE JOB QUEUE IN POSTGRES
+
+Instead of an in-memory channel, embedding jobs are rows in a table.
+Workers "claim" a row with SELECT ... FOR UPDATE SKIP LOCKED:
+- FOR UPDATE locks the row so no other worker can take it
+- SKIP LOCKED makes other workers skip it instead of waiting
+
+Lifecycle:
+  pending → running → succeeded
+                    ↘ pending (retry with backoff) → ... → failed
+
+Jobs survive restarts, and their status can be queried at any time.
+*/
+
+// JobStatus is the lifecycle state of an embedding job
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+// EmbeddingJob is a persisted request to (re)generate a document's embeddings
+type EmbeddingJob struct {
+	ID          string     `json:"id" gorm:"type:char(27);primaryKey"`
+	DocumentID  string     `json:"document_id" gorm:"type:char(27);not null;index:idx_embedding_jobs_document,priority:1"`
+	Model       string     `json:"model,omitempty" gorm:"type:varchar(100);not null;default:''"` // Empty = the active model (plus a migration target)
+	Status      JobStatus  `json:"status" gorm:"type:varchar(20);not null;default:'pending';index:idx_embedding_jobs_claim,priority:1"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int        `json:"max_attempts" gorm:"not null;default:3"`
+	LastError   string     `json:"last_error,omitempty" gorm:"type:text"`
+	RunAfter    time.Time  `json:"run_after" gorm:"not null;index:idx_embedding_jobs_claim,priority:2"` // Earliest time a worker may pick it up
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime;index:idx_embedding_jobs_document,priority:2"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+// BeforeCreate hook generates KSUID before inserting
+func (j *EmbeddingJob) BeforeCreate(tx *gorm.DB) error {
+	if j.ID == "" {
+		j.ID = ksuid.New().String()
+	}
+	if j.RunAfter.IsZero() {
+		j.RunAfter = time.Now()
+	}
+	return nil
+}
+
+// TableName override
+func (EmbeddingJob) TableName() string {
+	return "embedding_jobs"
+}
+
+// EmbeddingStatus answers "is my document searchable yet?"
+type EmbeddingStatus struct {
+	DocumentID string        `json:"document_id"`
+	Searchable bool          `json:"searchable"`  // Has at least one stored chunk
+	ChunkCount int64         `json:"chunk_count"` // Chunks currently indexed
+	UpToDate   bool          `json:"up_to_date"`  // No pending/running/failed job newer than the index
+	LatestJob  *EmbeddingJob `json:"latest_job,omitempty"`
+}
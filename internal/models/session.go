@@ -19,10 +19,13 @@ type Session struct {
 // AwarenessState represents user presence information (cursor, selection, etc.)
 // Learning: This is separate from document content - it's ephemeral user state
 type AwarenessState struct {
-	ClientID int                    `json:"client_id"`
-	User     *UserInfo              `json:"user,omitempty"`
-	Cursor   *CursorPosition        `json:"cursor,omitempty"`
-	State    map[string]interface{} `json:"state,omitempty"`
+	ClientID    int                    `json:"client_id"`
+	SessionID   string                 `json:"session_id"` // Connection the state arrived on
+	User        *UserInfo              `json:"user,omitempty"`
+	Cursor      *CursorPosition        `json:"cursor,omitempty"`
+	State       map[string]interface{} `json:"state,omitempty"` // As the client sent it; nil once it went offline
+	Clock       uint64                 `json:"clock"`           // Bumped by the client on every change
+	LastUpdated time.Time              `json:"last_updated"`
 }
 
 // UserInfo represents information about a connected user
@@ -33,6 +36,7 @@ type UserInfo struct {
 }
 
 // CursorPosition represents where a user's cursor is in the document
+// Both are 1-based, counted in the document's text content
 type CursorPosition struct {
 	Line   int `json:"line"`
 	Column int `json:"column"`
@@ -46,13 +50,15 @@ type YjsMessage struct {
 }
 
 // MessageType defines types of messages in the collaboration protocol
+// Learning: The Yjs types are y-protocols' - the first varint of every
+// binary frame a y-websocket client sends or expects
 type MessageType int
 
 const (
-	// Yjs sync protocol messages
-	MessageTypeSync          MessageType = 0 // Sync step 1: Send state vector
-	MessageTypeSyncUpdate    MessageType = 1 // Sync step 2: Send missing updates
-	MessageTypeAwareness     MessageType = 2 // Awareness (cursors, users)
+	// Yjs protocol messages
+	MessageTypeSync           MessageType = 0 // Document sync, see SyncStep
+	MessageTypeAwareness      MessageType = 1 // Awareness (cursors, users)
+	MessageTypeAuth           MessageType = 2 // Permission denied
 	MessageTypeQueryAwareness MessageType = 3 // Request awareness state
 
 	// Custom application messages
@@ -61,6 +67,15 @@ const (
 	MessageTypeError MessageType = 99 // Error message
 )
 
+// SyncStep is the second varint of a sync message
+type SyncStep int
+
+const (
+	SyncStep1  SyncStep = 0 // State vector: "send me what I'm missing"
+	SyncStep2  SyncStep = 1 // The missing updates, answering step 1
+	SyncUpdate SyncStep = 2 // An edit, as it happens
+)
+
 func NewSession(documentID, userID, userName string) *Session {
 	return &Session{
 		ID:           ksuid.New().String(),
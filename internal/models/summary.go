@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"gorm.io/gorm"
+)
+
+// SummaryMode selects the shape of a document summary
+type SummaryMode string
+
+const (
+	SummaryModeOverall  SummaryMode = "overall"  // One summary for the whole document
+	SummaryModeSections SummaryMode = "sections" // One summary per section, plus an overall one
+)
+
+// DocumentSummary is a cached summary of one version of a document
+// Learning: Keyed by the content hash, so an edit automatically misses the
+// cache and re-summarizing an unchanged document costs nothing
+type DocumentSummary struct {
+	ID          string           `json:"id" gorm:"type:char(27);primaryKey"`
+	DocumentID  string           `json:"document_id" gorm:"type:char(27);not null;uniqueIndex:idx_document_summaries_cache,priority:1"`
+	ContentHash string           `json:"content_hash" gorm:"type:char(64);not null;uniqueIndex:idx_document_summaries_cache,priority:2"`
+	Mode        SummaryMode      `json:"mode" gorm:"type:varchar(20);not null;uniqueIndex:idx_document_summaries_cache,priority:3"`
+	MaxWords    int              `json:"max_words" gorm:"not null;uniqueIndex:idx_document_summaries_cache,priority:4"`
+	PromptSet   string           `json:"prompt_set,omitempty" gorm:"type:varchar(100);not null;default:'';uniqueIndex:idx_document_summaries_cache,priority:5"` // "" is the default set
+	ChatModel   string           `json:"chat_model" gorm:"type:varchar(100);not null;default:'';uniqueIndex:idx_document_summaries_cache,priority:6"`
+	Summary     string           `json:"summary" gorm:"type:text;not null"`
+	Sections    []SectionSummary `json:"sections,omitempty" gorm:"type:jsonb;serializer:json"`
+	ChunkCount  int              `json:"chunk_count"` // Chunks that went into the summary
+	CreatedAt   time.Time        `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+
+	Cached bool `json:"cached" gorm:"-"` // Served from the cache rather than generated
+}
+
+// SectionSummary is the summary of one heading path (markdown) or JSON path
+type SectionSummary struct {
+	Section string `json:"section"`
+	Summary string `json:"summary"`
+}
+
+// BeforeCreate hook generates KSUID before inserting
+func (s *DocumentSummary) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = ksuid.New().String()
+	}
+	return nil
+}
+
+// TableName override
+func (DocumentSummary) TableName() string {
+	return "document_summaries"
+}
@@ -0,0 +1,55 @@
+package models
+
+// Vector index types
+const (
+	VectorIndexHNSW    = "hnsw"
+	VectorIndexIVFFlat = "ivfflat"
+)
+
+// VectorIndexConfig selects and tunes the ANN index built for each embedding model
+type VectorIndexConfig struct {
+	Type           string `json:"type"`                      // "hnsw" or "ivfflat"
+	M              int    `json:"m,omitempty"`               // HNSW: graph links per node
+	EFConstruction int    `json:"ef_construction,omitempty"` // HNSW: candidates considered while inserting
+	Lists          int    `json:"lists,omitempty"`           // IVFFlat: clusters; 0 = derived from the row count
+
+	Search VectorSearchParams `json:"search"` // Query-time defaults
+}
+
+// VectorSearchParams trade query speed for recall
+// Zero values mean "server default"
+type VectorSearchParams struct {
+	EFSearch int `json:"ef_search,omitempty"` // HNSW: candidates kept while searching the graph
+	Probes   int `json:"probes,omitempty"`    // IVFFlat: clusters scanned
+}
+
+// VectorIndexStats describes the vector index of one embedding model
+type VectorIndexStats struct {
+	Model      string            `json:"model"`
+	Dimensions int               `json:"dimensions"`
+	Chunks     int64             `json:"chunks"`
+	Name       string            `json:"name,omitempty"` // Empty when the model isn't indexed (exact scans)
+	Type       string            `json:"type,omitempty"` // Actual type - may differ from the config until a rebuild
+	Options    string            `json:"options,omitempty"`
+	SizeBytes  int64             `json:"size_bytes,omitempty"`
+	Valid      bool              `json:"valid"` // False while a concurrent build hasn't finished (or failed)
+	Building   *VectorIndexBuild `json:"building,omitempty"`
+}
+
+// VectorIndexBuild is the progress of an index build in progress
+type VectorIndexBuild struct {
+	Phase       string `json:"phase"`
+	TuplesDone  int64  `json:"tuples_done"`
+	TuplesTotal int64  `json:"tuples_total"`
+}
+
+// VectorIndexRecall compares index search against exact search on sample queries
+type VectorIndexRecall struct {
+	Model          string             `json:"model"`
+	Sample         int                `json:"sample"` // Queries run (stored chunks used as queries)
+	K              int                `json:"k"`
+	Recall         float64            `json:"recall"` // Share of the exact top k also found by the index (0-1)
+	Params         VectorSearchParams `json:"params"`
+	IndexLatencyMs float64            `json:"index_latency_ms"` // Average per query
+	ExactLatencyMs float64            `json:"exact_latency_ms"`
+}
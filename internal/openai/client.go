@@ -4,25 +4,59 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math/rand"
 	"net/http"
+	"time"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
 )
 
 type Client struct {
-	APIKey  string
-	BaseURL string
+	APIKey     string
+	BaseURL    string // Any OpenAI-compatible server works, e.g. http://localhost:11434/v1
+	MaxRetries int    // Retries after the first attempt for 429/5xx/network errors
+
+	// Models and generation defaults - overridable per request with WithChatOptions
+	EmbeddingModel string
+	ChatModel      string
+	Temperature    *float64 // nil leaves it to the server
+	MaxTokens      int      // Completion length limit; 0 leaves it to the server
+
 	client  *http.Client
+	limiter *RateLimiter // Optional, shared by all callers of this client
 }
 
 func NewClient(apiKey string) *Client {
 	return &Client{
-		APIKey:  apiKey,
-		BaseURL: "https://api.openai.com/v1",
-		client:  &http.Client{},
+		APIKey:         apiKey,
+		BaseURL:        "https://api.openai.com/v1",
+		MaxRetries:     3,
+		EmbeddingModel: "text-embedding-ada-002",
+		ChatModel:      "gpt-3.5-turbo", // or "gpt-4" for better quality
+		client:         &http.Client{},
 	}
 }
 
+// WithEmbeddingModel returns a copy of the client that embeds with another model
+// The copy shares the HTTP client and the rate limiter
+func (c *Client) WithEmbeddingModel(model string) *Client {
+	clone := *c
+	clone.EmbeddingModel = model
+	return &clone
+}
+
+// SetRateLimiter sets the client-side limiter applied before every request
+func (c *Client) SetRateLimiter(limiter *RateLimiter) {
+	c.limiter = limiter
+}
+
 type EmbeddingRequest struct {
 	Input []string `json:"input"`
 	Model string   `json:"model"`
@@ -37,9 +71,11 @@ type EmbeddingResponse struct {
 }
 
 type ChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream,omitempty"`
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Stream      bool      `json:"stream,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"` // Pointer, because 0 is a valid temperature
+	MaxTokens   int       `json:"max_tokens,omitempty"`
 }
 
 type ChatResponse struct {
@@ -68,50 +104,70 @@ type Message struct {
 	Content string `json:"content"`
 }
 
-func (c *Client) CreateEmbeddings(texts []string) ([]float32, error) {
+// CreateEmbeddings generates one embedding per input text
+// Learning: The API accepts a batch of inputs in a single request, which saves
+// round trips. Results are placed by their Index so output order matches input order.
+func (c *Client) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no input texts")
+	}
+
 	req := EmbeddingRequest{
 		Input: texts,
-		Model: "text-embedding-ada-002",
+		Model: c.EmbeddingModel,
 	}
 
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	var embResp EmbeddingResponse
+	if err := c.postJSON(ctx, "/embeddings", req, &embResp); err != nil {
+		return nil, err
 	}
 
-	httpReq, err := http.NewRequest("POST", c.BaseURL+"/embeddings", bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	if len(embResp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embResp.Data))
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
-
-	resp, err := c.client.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	embeddings := make([][]float32, len(texts))
+	for _, d := range embResp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	for i, emb := range embeddings {
+		if emb == nil {
+			return nil, fmt.Errorf("missing embedding for input %d", i)
+		}
 	}
 
-	var embResp EmbeddingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
+	return embeddings, nil
+}
 
-	if len(embResp.Data) == 0 {
-		return nil, fmt.Errorf("no embeddings returned")
-	}
-	return embResp.Data[0].Embedding, nil
+// ChatModelName names the chat model, for caches of generated text
+func (c *Client) ChatModelName() string {
+	return c.ChatModel
 }
 
 // ChatCompletion generates a chat completion using GPT models
 // Learning: This is used for RAG to generate answers with context
-func (c *Client) ChatCompletion(ctx context.Context, messages []ChatMessage) (string, error) {
+// The model only needs the messages - task is for providers that can't read them
+func (c *Client) ChatCompletion(ctx context.Context, task ChatTask, messages []ChatMessage) (string, error) {
+	req := c.chatRequest(ctx, messages, false)
+
+	var chatResp ChatResponse
+	if err := c.postJSON(ctx, "/chat/completions", req, &chatResp); err != nil {
+		return "", err
+	}
+
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("no completion returned")
+	}
+
+	return chatResp.Choices[0].Message.Content, nil
+}
+
+// chatRequest builds a chat request from the client defaults and the
+// per-request options in ctx
+func (c *Client) chatRequest(ctx context.Context, messages []ChatMessage, stream bool) ChatRequest {
 	// Convert to OpenAI message format
 	apiMessages := make([]Message, len(messages))
 	for i, msg := range messages {
@@ -122,19 +178,117 @@ func (c *Client) ChatCompletion(ctx context.Context, messages []ChatMessage) (st
 	}
 
 	req := ChatRequest{
-		Model:    "gpt-3.5-turbo", // or "gpt-4" for better quality
-		Messages: apiMessages,
-		Stream:   false,
+		Model:       c.ChatModel,
+		Messages:    apiMessages,
+		Stream:      stream,
+		Temperature: c.Temperature,
+		MaxTokens:   c.MaxTokens,
+	}
+
+	opts := ChatOptionsFrom(ctx)
+	if opts.Temperature != nil {
+		req.Temperature = opts.Temperature
+	}
+	if opts.MaxTokens > 0 {
+		req.MaxTokens = opts.MaxTokens
 	}
+	return req
+}
+
+// ChatOptions overrides generation parameters for one request
+type ChatOptions struct {
+	Temperature *float64
+	MaxTokens   int
+}
+
+type chatOptionsKey struct{}
+
+// WithChatOptions attaches generation overrides to every chat completion made with ctx
+// Learning: Carrying them in the context means the services in between
+// don't need an extra parameter on every method
+func WithChatOptions(ctx context.Context, opts ChatOptions) context.Context {
+	return context.WithValue(ctx, chatOptionsKey{}, opts)
+}
 
-	reqBody, err := json.Marshal(req)
+// ChatOptionsFrom returns the generation overrides in ctx (zero if none)
+func ChatOptionsFrom(ctx context.Context) ChatOptions {
+	opts, _ := ctx.Value(chatOptionsKey{}).(ChatOptions)
+	return opts
+}
+
+// postJSON sends a JSON request and decodes the JSON response, retrying
+// transient failures with exponential backoff
+// Learning: Retry-After tells us exactly how long to wait; otherwise we use
+// base * 2^attempt with jitter so many workers don't retry in lockstep
+func (c *Client) postJSON(ctx context.Context, path string, reqBody any, out any) error {
+	body, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/chat/completions", bytes.NewBuffer(reqBody))
+	return c.withRetry(ctx, path, func() (time.Duration, error) {
+		return c.send(ctx, path, body, out)
+	})
+}
+
+// withRetry runs attempt until it succeeds, fails permanently or runs out of retries
+// attempt returns the server-requested retry delay (if any) alongside its error
+func (c *Client) withRetry(ctx context.Context, path string, attempt func() (time.Duration, error)) error {
+	for n := 0; ; n++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		retryAfter, err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		if !isRetryable(err) || n >= c.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff(n)
+		if retryAfter > 0 {
+			delay = retryAfter
+			c.limiter.Pause(time.Now().Add(retryAfter)) // Other workers should wait too
+		}
+
+		log.Printf("⚠️  OpenAI %s failed (attempt %d/%d), retrying in %s: %v",
+			path, n+1, c.MaxRetries+1, delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send performs a single HTTP attempt
+// Returns the server-requested retry delay (if any) alongside the error
+func (c *Client) send(ctx context.Context, path string, body []byte, out any) (time.Duration, error) {
+	resp, retryAfter, err := c.do(ctx, path, body)
+	if err != nil {
+		return retryAfter, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return 0, nil
+}
+
+// do sends a POST request and returns the response if its status is 200
+// On any other status the body is read into an APIError and closed
+func (c *Client) do(ctx context.Context, path string, body []byte) (*http.Response, time.Duration, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+path, bytes.NewReader(body))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
@@ -142,23 +296,38 @@ func (c *Client) ChatCompletion(ctx context.Context, messages []ChatMessage) (st
 
 	resp, err := c.client.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
+
+	c.limiter.Observe(resp.Header)
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		retryAfter, _ := parseRetryAfter(resp.Header)
+		return nil, retryAfter, newAPIError(resp.StatusCode, respBody)
 	}
 
-	var chatResp ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
+	return resp, 0, nil
+}
 
-	if len(chatResp.Choices) == 0 {
-		return "", fmt.Errorf("no completion returned")
+// isRetryable reports whether an error from send is worth retrying
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
 	}
+	// Network errors (connection reset, timeouts) are transient,
+	// but a cancelled context is not
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
 
-	return chatResp.Choices[0].Message.Content, nil
+// backoff returns the delay before retry number attempt (0-based), with jitter
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	// Full jitter between 50% and 100% of the delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
 }
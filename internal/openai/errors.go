@@ -0,0 +1,94 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+/*
+LEARNING: TYPED (SENTINEL) ERRORS
+
+Callers shouldn't parse error strings to decide what to do. Instead we expose
+sentinel errors and wrap them, so callers can branch with errors.Is:
+
+  errors.Is(err, openai.ErrRateLimited)   → back off and retry later
+  errors.Is(err, openai.ErrAuth)          → retrying won't help
+  errors.Is(err, openai.ErrContextLength) → split the input and try again
+
+APIError carries the details (status, code, message) and unwraps to the sentinel.
+*/
+
+var (
+	// ErrRateLimited means the API returned 429 (too many requests or quota exhausted)
+	ErrRateLimited = errors.New("openai: rate limited")
+
+	// ErrContextLength means the input was longer than the model's context window
+	ErrContextLength = errors.New("openai: context length exceeded")
+
+	// ErrAuth means the API key is missing, invalid or lacks permission
+	ErrAuth = errors.New("openai: authentication failed")
+)
+
+// APIError is a non-2xx response from the OpenAI API
+type APIError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+	kind       error // One of the sentinel errors above, or nil
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("API request failed with status %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap lets errors.Is match the sentinel errors
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// Retryable reports whether the same request might succeed later
+// Learning: 429 and 5xx are transient; quota exhaustion and 4xx are not
+func (e *APIError) Retryable() bool {
+	if e.StatusCode == http.StatusTooManyRequests {
+		return e.Code != "insufficient_quota"
+	}
+	return e.StatusCode >= 500
+}
+
+// newAPIError builds an APIError from a response status and body
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode, Message: string(body)}
+
+	// OpenAI error bodies look like {"error": {"message": ..., "type": ..., "code": ...}}
+	var parsed struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    any    `json:"code"` // Sometimes a string, sometimes null
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil && parsed.Error.Message != "" {
+		apiErr.Message = parsed.Error.Message
+		apiErr.Type = parsed.Error.Type
+		if code, ok := parsed.Error.Code.(string); ok {
+			apiErr.Code = code
+		}
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		apiErr.kind = ErrAuth
+	case statusCode == http.StatusTooManyRequests:
+		apiErr.kind = ErrRateLimited
+	case apiErr.Code == "context_length_exceeded":
+		apiErr.kind = ErrContextLength
+	}
+
+	return apiErr
+}
@@ -0,0 +1,169 @@
+package openai
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+LEARNING: CLIENT-SIDE RATE LIMITING (TOKEN BUCKET)
+
+A bucket holds up to `burst` tokens and refills at `rate` tokens per second.
+Every request takes one token; when the bucket is empty, callers wait.
+
+  refill ──► [● ● ● ○ ○] ──► request
+             burst = 5
+
+One limiter is shared by every goroutine using the client (e.g. all embedding
+workers), so together they never exceed the configured rate.
+
+The API also tells us how much budget is left via x-ratelimit-* headers.
+When it says we're out, Pause() stops everyone until the reset time instead
+of letting each worker discover the 429 on its own.
+*/
+
+// RateLimiter is a token bucket shared across goroutines
+type RateLimiter struct {
+	mu          sync.Mutex
+	rate        float64   // Tokens added per second
+	burst       float64   // Bucket capacity
+	tokens      float64   // Tokens currently available
+	last        time.Time // Last refill
+	pausedUntil time.Time // Set from rate-limit headers / Retry-After
+}
+
+// NewRateLimiter allows requestsPerMinute requests, with bursts of up to burst
+// A non-positive requestsPerMinute disables limiting
+func NewRateLimiter(requestsPerMinute, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   float64(requestsPerMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a request may be sent or ctx is cancelled
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		delay := l.reserve()
+		if delay == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token if one is available, otherwise returns how long to wait
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+
+	if l.rate <= 0 {
+		return 0 // Limiting disabled
+	}
+
+	// Refill based on elapsed time
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	missing := 1 - l.tokens
+	return time.Duration(missing / l.rate * float64(time.Second))
+}
+
+// Pause blocks all callers until the given time
+func (l *RateLimiter) Pause(until time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// Observe inspects x-ratelimit-* response headers and pauses when the budget is exhausted
+// Learning: OpenAI reports remaining requests/tokens and when each window resets
+func (l *RateLimiter) Observe(header http.Header) {
+	if l == nil {
+		return
+	}
+
+	for _, kind := range []string{"requests", "tokens"} {
+		remaining := header.Get("x-ratelimit-remaining-" + kind)
+		if remaining != "0" {
+			continue
+		}
+		if reset, ok := parseResetDuration(header.Get("x-ratelimit-reset-" + kind)); ok {
+			l.Pause(time.Now().Add(reset))
+		}
+	}
+}
+
+// parseResetDuration parses reset values such as "1s", "6m0s" or "20ms"
+func parseResetDuration(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, true
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// parseRetryAfter reads Retry-After (seconds or HTTP date) or retry-after-ms
+func parseRetryAfter(header http.Header) (time.Duration, bool) {
+	if ms := header.Get("retry-after-ms"); ms != "" {
+		if v, err := strconv.ParseFloat(ms, 64); err == nil {
+			return time.Duration(v * float64(time.Millisecond)), true
+		}
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
@@ -0,0 +1,107 @@
+package openai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+LEARNING: STREAMING COMPLETIONS
+
+With "stream": true the API answers with Server-Sent Events instead of one
+JSON body. Each event carries the next few tokens of the answer:
+
+  data: {"choices":[{"delta":{"role":"assistant"}}]}
+  data: {"choices":[{"delta":{"content":"Restart"}}]}
+  data: {"choices":[{"delta":{"content":" the pod"}}]}
+  data: {"choices":[{"delta":{},"finish_reason":"stop"}]}
+  data: [DONE]
+
+The first token arrives after a few hundred milliseconds, while the complete
+answer can take tens of seconds, so streaming makes long answers feel fast.
+
+Retries only cover opening the stream - once tokens have been handed to the
+caller, starting over would repeat them.
+*/
+
+// ChatStreamChunk is one server-sent event of a streaming chat completion
+type ChatStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// ChatCompletionStream generates a chat completion, calling onDelta with each
+// piece of the answer as it arrives
+// Returns the complete answer; an error from onDelta stops the stream
+func (c *Client) ChatCompletionStream(ctx context.Context, task ChatTask, messages []ChatMessage, onDelta func(string) error) (string, error) {
+	body, err := json.Marshal(c.chatRequest(ctx, messages, true))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var resp *http.Response
+	err = c.withRetry(ctx, "/chat/completions", func() (time.Duration, error) {
+		var retryAfter time.Duration
+		var err error
+		resp, retryAfter, err = c.do(ctx, "/chat/completions", body)
+		return retryAfter, err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() // Also aborts the request when we stop early
+
+	var answer strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue // Blank separators and comments
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return answer.String(), nil
+		}
+
+		var chunk ChatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return answer.String(), fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return answer.String(), fmt.Errorf("stream failed (%s): %s", chunk.Error.Type, chunk.Error.Message)
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			answer.WriteString(choice.Delta.Content)
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return answer.String(), err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return answer.String(), ctx.Err()
+		}
+		return answer.String(), fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return answer.String(), fmt.Errorf("stream ended before [DONE]")
+}
@@ -0,0 +1,24 @@
+package openai
+
+// TaskKind names what a chat completion is asked to do
+type TaskKind string
+
+const (
+	TaskAnswer    TaskKind = "answer"    // Answer a question from numbered context passages
+	TaskSummarize TaskKind = "summarize" // Summarize a text
+	TaskKeywords  TaskKind = "keywords"  // List a text's keywords, comma-separated
+	TaskCondense  TaskKind = "condense"  // Rephrase a follow-up as a standalone question
+	TaskRerank    TaskKind = "rerank"    // Grade passages 0-10 as a JSON array
+)
+
+// ChatTask is the structured input a prompt was rendered from
+// Learning: A model reads the prompt, so the API client ignores this. The
+// offline provider can't read, and answers from the task instead - prompt
+// templates can then be reworded freely without breaking it
+type ChatTask struct {
+	Kind     TaskKind
+	Query    string   // Answer, Condense, Rerank: the question
+	Text     string   // Summarize, Keywords: the content; Condense: the conversation so far
+	Passages []string // Answer: the context chunks, cited as [1], [2]...; Rerank: the passages to grade
+	Count    int      // Keywords: how many to return
+}
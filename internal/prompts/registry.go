@@ -0,0 +1,259 @@
+package prompts
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+/*
+LEARNING: PROMPTS AS TEMPLATES
+
+Prompts change far more often than code - a wording tweak shouldn't need a
+rebuild. Every prompt is a Go text/template:
+
+  rag_user.tmpl:
+    Context:
+    {{.Context}}
+
+    Question: {{.Query}}
+
+The defaults are embedded in the binary. PROMPTS_DIR can override any of
+them, and each subdirectory is a named SET that overrides some templates
+again for requests asking for it ("prompt_set": "support"):
+
+  prompts/
+    rag_system.tmpl          ← replaces the default for everyone
+    support/
+      rag_system.tmpl        ← only for prompt_set "support"
+
+Templates are parsed and test-rendered at startup, so a typo fails the
+deploy instead of the first request.
+
+The offline provider (LLM_PROVIDER=local) doesn't read prompts at all - it
+gets the same inputs as an openai.ChatTask - so any wording works with it.
+*/
+
+// Template names
+const (
+	RAGSystem      = "rag_system"
+	RAGUser        = "rag_user"
+	SummarySystem  = "summary_system"
+	SummaryUser    = "summary_user"
+	KeywordsSystem = "keywords_system"
+	KeywordsUser   = "keywords_user"
+	CondenseSystem = "condense_system"
+	CondenseUser   = "condense_user"
+	RerankSystem   = "rerank_system"
+	RerankUser     = "rerank_user"
+)
+
+// RAG is the data for the rag_* templates
+type RAG struct {
+	Query   string
+	Context string // Numbered "[Document N]" chunks
+}
+
+// Summary is the data for the summary_* templates
+type Summary struct {
+	MaxWords int
+	Content  string
+}
+
+// Keywords is the data for the keywords_* templates
+type Keywords struct {
+	Count   int
+	Content string
+}
+
+// Condense is the data for the condense_* templates
+type Condense struct {
+	History  string // "role: content" lines, oldest first
+	Question string
+}
+
+// Rerank is the data for the rerank_* templates
+// Learning: The user template must ask for one JSON array of grades, one
+// per passage in order - that's what the reranker parses
+type Rerank struct {
+	Query    string
+	Passages []RerankPassage
+}
+
+// RerankPassage is one candidate to grade
+type RerankPassage struct {
+	Number int // 1-based
+	Text   string
+}
+
+// samples is test data used to validate templates at load time
+var samples = map[string]any{
+	RAGSystem:      RAG{},
+	RAGUser:        RAG{Query: "q", Context: "c"},
+	SummarySystem:  Summary{},
+	SummaryUser:    Summary{MaxWords: 100, Content: "c"},
+	KeywordsSystem: Keywords{},
+	KeywordsUser:   Keywords{Count: 5, Content: "c"},
+	CondenseSystem: Condense{},
+	CondenseUser:   Condense{History: "h", Question: "q"},
+	RerankSystem:   Rerank{},
+	RerankUser:     Rerank{Query: "q", Passages: []RerankPassage{{Number: 1, Text: "p"}}},
+}
+
+// ErrUnknownSet means a request asked for a prompt set that doesn't exist
+var ErrUnknownSet = errors.New("unknown prompt set")
+
+//go:embed templates/*.tmpl
+var defaults embed.FS
+
+// Registry holds the parsed prompt templates of every set
+type Registry struct {
+	sets map[string]*template.Template // "" is the default set
+}
+
+// Defaults returns a registry with only the built-in templates
+func Defaults() *Registry {
+	r, err := Load("")
+	if err != nil {
+		panic(fmt.Sprintf("built-in prompt templates are invalid: %v", err)) // A bug, not a config error
+	}
+	return r
+}
+
+// Load reads the built-in templates, then the overrides and sets in dir
+// An empty dir means built-in templates only
+func Load(dir string) (*Registry, error) {
+	base, err := readTemplates(defaults, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]map[string]string{"": {}}
+	if dir != "" {
+		root := os.DirFS(dir)
+		if overrides[""], err = readTemplates(root, "."); err != nil {
+			return nil, err
+		}
+
+		entries, err := fs.ReadDir(root, ".")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompts dir: %w", err)
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				if overrides[e.Name()], err = readTemplates(root, e.Name()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	r := &Registry{sets: make(map[string]*template.Template)}
+	for set, files := range overrides {
+		sources := make(map[string]string, len(base))
+		for name, text := range base {
+			sources[name] = text
+		}
+		if set != "" {
+			// Named sets build on the directory-wide overrides
+			for name, text := range overrides[""] {
+				sources[name] = text
+			}
+		}
+		for name, text := range files {
+			if _, ok := base[name]; !ok {
+				return nil, fmt.Errorf("unknown prompt template %q in set %q", name, set)
+			}
+			sources[name] = text
+		}
+
+		t, err := parseSet(sources)
+		if err != nil {
+			return nil, fmt.Errorf("prompt set %q: %w", set, err)
+		}
+		r.sets[set] = t
+	}
+
+	return r, nil
+}
+
+// readTemplates returns the *.tmpl files in dir, keyed by name without extension
+func readTemplates(fsys fs.FS, dir string) (map[string]string, error) {
+	matches, err := fs.Glob(fsys, filepath.ToSlash(filepath.Join(dir, "*.tmpl")))
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]string, len(matches))
+	for _, path := range matches {
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt template %s: %w", path, err)
+		}
+		templates[strings.TrimSuffix(filepath.Base(path), ".tmpl")] = string(content)
+	}
+	return templates, nil
+}
+
+// parseSet parses one set's templates and renders each with sample data
+func parseSet(sources map[string]string) (*template.Template, error) {
+	root := template.New("").Option("missingkey=error")
+	for name, text := range sources {
+		if _, err := root.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+	}
+	for name := range sources {
+		var b strings.Builder
+		if err := root.ExecuteTemplate(&b, name, samples[name]); err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", name, err)
+		}
+	}
+	return root, nil
+}
+
+// Sets returns the names of the available prompt sets ("" is the default)
+func (r *Registry) Sets() []string {
+	names := make([]string, 0, len(r.sets))
+	for name := range r.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render executes a template of the set selected in ctx (see WithSet)
+// Leading and trailing whitespace is trimmed
+func (r *Registry) Render(ctx context.Context, name string, data any) (string, error) {
+	set := SetFrom(ctx)
+	t, ok := r.sets[set]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownSet, set)
+	}
+
+	var b strings.Builder
+	if err := t.ExecuteTemplate(&b, name, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s: %w", name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+type setKey struct{}
+
+// WithSet selects a named prompt set for everything rendered with ctx
+func WithSet(ctx context.Context, set string) context.Context {
+	return context.WithValue(ctx, setKey{}, set)
+}
+
+// SetFrom returns the prompt set selected in ctx, or "" for the default set
+func SetFrom(ctx context.Context) string {
+	set, _ := ctx.Value(setKey{}).(string)
+	return set
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ai-kms/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ConversationRepositoryImpl handles chat conversations and their messages
+type ConversationRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewConversationRepository creates a new conversation repository
+func NewConversationRepository(db *gorm.DB) *ConversationRepositoryImpl {
+	return &ConversationRepositoryImpl{db: db}
+}
+
+// Create stores a new, empty conversation
+func (r *ConversationRepositoryImpl) Create(ctx context.Context, title string) (*models.Conversation, error) {
+	conv := &models.Conversation{Title: title}
+
+	if err := r.db.WithContext(ctx).Create(conv).Error; err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+
+	return conv, nil
+}
+
+// GetByID returns a conversation with its messages in chronological order
+// Returns nil (and no error) if it doesn't exist
+func (r *ConversationRepositoryImpl) GetByID(ctx context.Context, id string) (*models.Conversation, error) {
+	var conv models.Conversation
+
+	err := r.db.WithContext(ctx).
+		Preload("Messages", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC, id ASC")
+		}).
+		First(&conv, "id = ?", id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+
+	return &conv, nil
+}
+
+// List returns conversations without their messages, most recently active first
+func (r *ConversationRepositoryImpl) List(ctx context.Context, limit, offset int) ([]*models.Conversation, error) {
+	var convs []*models.Conversation
+
+	err := r.db.WithContext(ctx).
+		Order("updated_at DESC, id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&convs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+
+	return convs, nil
+}
+
+// AddMessages appends messages to a conversation and marks it as active
+// Learning: Question and answer are written together, so a failed request
+// never leaves a question without its answer in the history
+func (r *ConversationRepositoryImpl) AddMessages(ctx context.Context, conversationID string, messages ...*models.ConversationMessage) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, msg := range messages {
+			msg.ConversationID = conversationID
+		}
+		if err := tx.Create(messages).Error; err != nil {
+			return fmt.Errorf("failed to store messages: %w", err)
+		}
+
+		if err := tx.Model(&models.Conversation{}).
+			Where("id = ?", conversationID).
+			Update("updated_at", time.Now()).Error; err != nil {
+			return fmt.Errorf("failed to touch conversation: %w", err)
+		}
+		return nil
+	})
+}
+
+// SetTitle renames a conversation
+func (r *ConversationRepositoryImpl) SetTitle(ctx context.Context, id, title string) error {
+	if err := r.db.WithContext(ctx).Model(&models.Conversation{}).
+		Where("id = ?", id).
+		UpdateColumn("title", title).Error; err != nil {
+		return fmt.Errorf("failed to set conversation title: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a conversation and all of its messages
+func (r *ConversationRepositoryImpl) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Delete(&models.Conversation{}, "id = ?", id)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete conversation: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("conversation not found: %s", id)
+	}
+
+	return nil
+}
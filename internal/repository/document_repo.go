@@ -96,6 +96,7 @@ func (r *DocumentRepositoryImpl) Update(ctx context.Context, id string, update *
 	}
 	if update.Content != nil {
 		updates["content"] = *update.Content
+		updates["content_hash"] = models.HashContent(*update.Content)
 	}
 	if update.Format != nil {
 		updates["format"] = *update.Format
@@ -112,6 +113,23 @@ func (r *DocumentRepositoryImpl) Update(ctx context.Context, id string, update *
 	return &doc, nil
 }
 
+// ReplaceContent sets a document's content, but only if its hash is still previousHash
+// Returns false, changing nothing, when the content changed in the meantime
+// Learning: The check and the write are one UPDATE, so nothing can slip in
+// between - unlike reading the document first and updating it afterwards
+func (r *DocumentRepositoryImpl) ReplaceContent(ctx context.Context, id, previousHash, content string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.Document{}).
+		Where("id = ? AND COALESCE(content_hash, '') = ?", id, previousHash).
+		Updates(map[string]interface{}{
+			"content":      content,
+			"content_hash": models.HashContent(content),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update document: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
 // Delete performs a soft delete on the document
 // Learning: GORM automatically sets DeletedAt timestamp instead of removing the row
 // This allows data recovery and audit trails
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"ai-kms/internal/models"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// embeddingCacheBatchSize bounds the rows per INSERT (and hashes per IN list)
+const embeddingCacheBatchSize = 500
+
+// EmbeddingCacheRepositoryImpl stores embeddings by content hash
+type EmbeddingCacheRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewEmbeddingCacheRepository creates a new embedding cache repository
+func NewEmbeddingCacheRepository(db *gorm.DB) *EmbeddingCacheRepositoryImpl {
+	return &EmbeddingCacheRepositoryImpl{db: db}
+}
+
+// GetMany returns the cached embeddings of a model for the given content hashes
+// Hashes that aren't cached are simply absent from the result
+func (r *EmbeddingCacheRepositoryImpl) GetMany(ctx context.Context, model string, hashes []string) (map[string][]float32, error) {
+	found := make(map[string][]float32, len(hashes))
+
+	for start := 0; start < len(hashes); start += embeddingCacheBatchSize {
+		end := min(start+embeddingCacheBatchSize, len(hashes))
+
+		var entries []models.EmbeddingCacheEntry
+		if err := r.db.WithContext(ctx).
+			Where("model = ? AND content_hash IN ?", model, hashes[start:end]).
+			Find(&entries).Error; err != nil {
+			return nil, fmt.Errorf("failed to read embedding cache: %w", err)
+		}
+		for _, e := range entries {
+			found[e.ContentHash] = e.Embedding.Slice()
+		}
+	}
+
+	return found, nil
+}
+
+// PutMany stores embeddings of a model keyed by content hash
+// Learning: Embeddings are deterministic per model, so when two workers
+// race to cache the same text either row is correct - keep the first
+func (r *EmbeddingCacheRepositoryImpl) PutMany(ctx context.Context, model string, vectors map[string][]float32) error {
+	if len(vectors) == 0 {
+		return nil
+	}
+
+	entries := make([]models.EmbeddingCacheEntry, 0, len(vectors))
+	for hash, vec := range vectors {
+		entries = append(entries, models.EmbeddingCacheEntry{
+			Model:       model,
+			ContentHash: hash,
+			Embedding:   pgvector.NewVector(vec),
+		})
+	}
+
+	if err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(entries, embeddingCacheBatchSize).Error; err != nil {
+		return fmt.Errorf("failed to write embedding cache: %w", err)
+	}
+	return nil
+}
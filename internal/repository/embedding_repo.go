@@ -2,24 +2,41 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"ai-kms/internal/models"
 
 	"github.com/pgvector/pgvector-go"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // EmbeddingRepositoryImpl handles vector operations using pgvector
 // This is the IMPLEMENTATION - doesn't know about interfaces
+// Learning: A repository is scoped to one embedding model - reads, searches and
+// replacements only see that model's rows (see models.Embedding)
 type EmbeddingRepositoryImpl struct {
-	db *gorm.DB
+	db    *gorm.DB
+	model string
+	index *vectorIndexState // Shared by ForModel copies
 }
 
-// NewEmbeddingRepository creates a new embedding repository
+// NewEmbeddingRepository creates a new embedding repository for an embedding model
 // Returns concrete type - consumer will use interface
-func NewEmbeddingRepository(db *gorm.DB) *EmbeddingRepositoryImpl {
-	return &EmbeddingRepositoryImpl{db: db}
+func NewEmbeddingRepository(db *gorm.DB, model string) *EmbeddingRepositoryImpl {
+	return &EmbeddingRepositoryImpl{db: db, model: model, index: newVectorIndexState()}
+}
+
+// ForModel returns a repository for another embedding model on the same database
+func (r *EmbeddingRepositoryImpl) ForModel(model string) *EmbeddingRepositoryImpl {
+	return &EmbeddingRepositoryImpl{db: r.db, model: model, index: r.index}
+}
+
+// Model returns the embedding model this repository reads and writes
+func (r *EmbeddingRepositoryImpl) Model() string {
+	return r.model
 }
 
 // StoreEmbedding saves a document chunk embedding to the database
@@ -31,12 +48,70 @@ func (r *EmbeddingRepositoryImpl) StoreEmbedding(ctx context.Context, embedding
 	return nil
 }
 
+// ReplaceEmbeddings atomically swaps a document's embeddings for a new set
+// computed from content with the given hash
+// Returns false (and changes nothing) when the document's content changed
+// since - the caller embedded a stale read
+// Learning: Delete + bulk insert run in one transaction, so searches never see
+// a half-indexed document and a failed insert leaves the old chunks in place.
+// Only this repository's model is replaced - other models' chunks stay.
+// The old chunks are hard-deleted: soft-deleted rows would stay in the
+// model's ANN index, and since deleted_at is filtered after the index scan,
+// every re-embed would leave dead candidates that crowd out live ones.
+func (r *EmbeddingRepositoryImpl) ReplaceEmbeddings(ctx context.Context, docID, contentHash string, embeddings []*models.Embedding) (bool, error) {
+	for _, e := range embeddings {
+		e.Model = r.model
+		e.Dimensions = len(e.Embedding.Slice())
+	}
+
+	replaced := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Learning: Two jobs for one document can run at once, and the one that
+		// read the older text may finish last. Checking the hash under a share
+		// lock means an edit can't slip in between the check and the commit
+		var current []string
+		if err := tx.Model(&models.Document{}).
+			Clauses(clause.Locking{Strength: "SHARE"}).
+			Where("id = ?", docID).
+			Pluck("COALESCE(content_hash, '')", &current).Error; err != nil {
+			return fmt.Errorf("failed to check document content: %w", err)
+		}
+		if len(current) == 0 || current[0] != contentHash {
+			return nil
+		}
+
+		if err := tx.Unscoped().Where("document_id = ? AND model = ?", docID, r.model).Delete(&models.Embedding{}).Error; err != nil {
+			return fmt.Errorf("failed to delete old embeddings: %w", err)
+		}
+
+		replaced = true
+		if len(embeddings) == 0 {
+			return nil
+		}
+
+		// CreateInBatches issues multi-row INSERTs instead of one per chunk
+		if err := tx.CreateInBatches(embeddings, 100).Error; err != nil {
+			return fmt.Errorf("failed to store embeddings: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if !replaced || len(embeddings) == 0 {
+		return replaced, nil
+	}
+
+	// The first chunks of a new model also create its index
+	return true, r.EnsureVectorIndex(ctx, embeddings[0].Dimensions)
+}
+
 // GetEmbeddingsByDocumentID retrieves all embeddings for a document
 func (r *EmbeddingRepositoryImpl) GetEmbeddingsByDocumentID(ctx context.Context, docID string) ([]*models.Embedding, error) {
 	var embeddings []*models.Embedding
 
 	err := r.db.WithContext(ctx).
-		Where("document_id = ?", docID).
+		Where("document_id = ? AND model = ?", docID, r.model).
 		Order("chunk_index").
 		Find(&embeddings).Error
 
@@ -47,36 +122,246 @@ func (r *EmbeddingRepositoryImpl) GetEmbeddingsByDocumentID(ctx context.Context,
 	return embeddings, nil
 }
 
+// CountEmbeddingsByDocumentID returns how many chunks are indexed for a document
+func (r *EmbeddingRepositoryImpl) CountEmbeddingsByDocumentID(ctx context.Context, docID string) (int64, error) {
+	var count int64
+
+	if err := r.db.WithContext(ctx).Model(&models.Embedding{}).
+		Where("document_id = ? AND model = ?", docID, r.model).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count embeddings: %w", err)
+	}
+
+	return count, nil
+}
+
 // SemanticSearch performs vector similarity search using cosine distance
 // Learning: The <=> operator from pgvector calculates cosine distance
 // Lower distance = more similar documents
-func (r *EmbeddingRepositoryImpl) SemanticSearch(ctx context.Context, queryEmbedding []float32, limit int) ([]*models.SearchResult, error) {
+func (r *EmbeddingRepositoryImpl) SemanticSearch(ctx context.Context, queryEmbedding []float32, filters models.SearchFilters, limit int) ([]*models.SearchResult, error) {
 	vec := pgvector.NewVector(queryEmbedding)
 
+	where, whereArgs, err := filterSQL(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// Learning: ORDER BY embedding <=> ? is what lets Postgres use the vector
+	// index, but an approximate index scan followed by a filter on a few
+	// documents can come back nearly empty. Ordering by the computed score
+	// instead forces an exact scan, which is cheap for a handful of documents.
+	distance := vectorExpr(queryEmbedding) + " <=> ?"
+	orderBy, orderArgs := distance, []any{vec}
+	if len(filters.DocumentIDs) > 0 {
+		orderBy, orderArgs = "score DESC", nil
+	}
+
 	var results []*models.SearchResult
 
 	// Using raw SQL for vector operations since GORM doesn't have native support
 	// The <=> operator is from pgvector and calculates cosine distance
-	err := r.db.WithContext(ctx).Raw(`
-		SELECT 
+	err = r.withSearchParams(ctx, limit, func(tx *gorm.DB) error {
+		return tx.Raw(withChunkDetails(`
+			SELECT 
+				e.id,
+				1 - (`+distance+`) as score
+			FROM embeddings e
+			JOIN documents d ON d.id = e.document_id
+			WHERE e.deleted_at IS NULL AND d.deleted_at IS NULL AND e.model = ?`+where+`
+			ORDER BY `+orderBy+`
+			LIMIT ?
+		`), args(vec, r.model, whereArgs, orderArgs, limit)...).Scan(&results).Error
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform semantic search: %w", err)
+	}
+
+	return results, nil
+}
+
+// withChunkDetails wraps a ranking query returning (id, score) rows with the
+// columns of models.SearchResult
+// Learning: The UI needs character offsets. They are stored next to the byte
+// offsets when the chunk is embedded, so nothing here reads the document's
+// current content - which may have changed since
+func withChunkDetails(ranking string) string {
+	return `
+		SELECT
 			e.document_id,
 			d.title,
 			e.chunk_text,
-			1 - (e.embedding <=> ?) as score
+			e.section,
+			e.chunk_index,
+			e.char_start AS start_offset,
+			e.char_end AS end_offset,
+			ranked.score
+		FROM (` + ranking + `) ranked
+		JOIN embeddings e ON e.id = ranked.id
+		JOIN documents d ON d.id = e.document_id
+		ORDER BY ranked.score DESC, e.id`
+}
+
+// keywordQuery matches the chunk's search_vector or the document title
+// Learning: websearch_to_tsquery accepts what people type into a search box
+// ("pg_dump" -restore "connection refused") and never errors on bad syntax
+const keywordQuery = `(websearch_to_tsquery('english', ?) || websearch_to_tsquery('simple', ?))`
+
+// KeywordSearch ranks chunks with Postgres full-text search
+// Learning: ts_rank_cd rewards terms that appear close together; normalization
+// flag 32 maps the rank into 0-1 so it reads like a similarity score
+func (r *EmbeddingRepositoryImpl) KeywordSearch(ctx context.Context, query string, filters models.SearchFilters, limit int) ([]*models.SearchResult, error) {
+	where, whereArgs, err := filterSQL(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*models.SearchResult
+
+	err = r.db.WithContext(ctx).Raw(withChunkDetails(`
+		WITH q AS (SELECT `+keywordQuery+` AS query)
+		SELECT
+			e.id,
+			ts_rank_cd(e.search_vector, q.query, 32) + ts_rank_cd(d.search_vector, q.query, 32) / 2 AS score
 		FROM embeddings e
 		JOIN documents d ON d.id = e.document_id
-		WHERE e.deleted_at IS NULL AND d.deleted_at IS NULL
-		ORDER BY e.embedding <=> ?
+		CROSS JOIN q
+		WHERE e.deleted_at IS NULL AND d.deleted_at IS NULL AND e.model = ?`+where+`
+			AND (e.search_vector @@ q.query OR d.search_vector @@ q.query)
+		ORDER BY score DESC
 		LIMIT ?
-	`, vec, vec, limit).Scan(&results).Error
+	`), args(query, query, r.model, whereArgs, limit)...).Scan(&results).Error
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to perform semantic search: %w", err)
+		return nil, fmt.Errorf("failed to perform keyword search: %w", err)
+	}
+
+	return results, nil
+}
+
+// HybridSearch fuses semantic and keyword rankings with reciprocal rank fusion
+// Learning: RRF only looks at ranks, not raw scores, so cosine similarity and
+// ts_rank don't need to be on the same scale:
+//
+//	score(chunk) = Σ weight / (K + rank)
+//
+// A chunk ranked well by both searches beats one ranked first by only one.
+// Scores are divided by the best possible score, so 1.0 means "first in both".
+func (r *EmbeddingRepositoryImpl) HybridSearch(ctx context.Context, queryEmbedding []float32, query string, filters models.SearchFilters, limit int, w models.HybridWeights) ([]*models.SearchResult, error) {
+	vec := pgvector.NewVector(queryEmbedding)
+
+	where, whereArgs, err := filterSQL(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each ranking contributes a larger candidate pool than the final limit,
+	// so a chunk ranked 15th by one search and 2nd by the other can still win
+	candidates := max(limit*4, 50)
+	best := (w.Semantic + w.Keyword) / float64(w.K+1)
+	distance := vectorExpr(queryEmbedding) + " <=> ?"
+
+	var results []*models.SearchResult
+
+	err = r.withSearchParams(ctx, candidates, func(tx *gorm.DB) error {
+		return tx.Raw(withChunkDetails(`
+			WITH q AS (SELECT `+keywordQuery+` AS query),
+			semantic AS (
+				SELECT e.id, ROW_NUMBER() OVER (ORDER BY `+distance+`) AS rank
+				FROM embeddings e
+				JOIN documents d ON d.id = e.document_id
+				WHERE e.deleted_at IS NULL AND d.deleted_at IS NULL AND e.model = ?`+where+`
+				ORDER BY `+distance+`
+				LIMIT ?
+			),
+			keyword AS (
+				SELECT e.id, ROW_NUMBER() OVER (
+					ORDER BY ts_rank_cd(e.search_vector, q.query, 32) + ts_rank_cd(d.search_vector, q.query, 32) / 2 DESC
+				) AS rank
+				FROM embeddings e
+				JOIN documents d ON d.id = e.document_id
+				CROSS JOIN q
+				WHERE e.deleted_at IS NULL AND d.deleted_at IS NULL AND e.model = ?`+where+`
+					AND (e.search_vector @@ q.query OR d.search_vector @@ q.query)
+				ORDER BY rank
+				LIMIT ?
+			)
+			SELECT
+				COALESCE(s.id, k.id) AS id,
+				(COALESCE(?::float8 / (?::float8 + s.rank), 0) + COALESCE(?::float8 / (?::float8 + k.rank), 0)) / ?::float8 AS score
+			FROM semantic s
+			FULL OUTER JOIN keyword k ON k.id = s.id
+			ORDER BY score DESC
+			LIMIT ?
+		`), args(
+			query, query,
+			vec, r.model, whereArgs, vec, candidates,
+			r.model, whereArgs, candidates,
+			w.Semantic, w.K, w.Keyword, w.K, best,
+			limit,
+		)...).Scan(&results).Error
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform hybrid search: %w", err)
 	}
 
 	return results, nil
 }
 
+// filterSQL turns search filters into extra predicates on the documents table (d)
+// Returns a fragment starting with " AND", or "" when there are no filters
+func filterSQL(f models.SearchFilters) (string, []any, error) {
+	var sql strings.Builder
+	var params []any
+
+	if f.Format != nil {
+		sql.WriteString(" AND d.format = ?")
+		params = append(params, *f.Format)
+	}
+	if f.DateFrom != nil {
+		sql.WriteString(" AND d.created_at >= ?")
+		params = append(params, *f.DateFrom)
+	}
+	if f.DateTo != nil {
+		sql.WriteString(" AND d.created_at < ?")
+		params = append(params, *f.DateTo)
+	}
+	if len(f.DocumentIDs) > 0 {
+		sql.WriteString(" AND d.id IN ?")
+		params = append(params, f.DocumentIDs)
+	}
+	if len(f.ExcludeIDs) > 0 {
+		sql.WriteString(" AND d.id NOT IN ?")
+		params = append(params, f.ExcludeIDs)
+	}
+	if len(f.Metadata) > 0 {
+		// Learning: @> is JSONB containment - {"team": "infra", "tier": 1}
+		// matches any metadata that has at least those keys with those values
+		metadata, err := json.Marshal(f.Metadata)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid metadata filter: %w", err)
+		}
+		sql.WriteString(" AND d.metadata @> ?::jsonb")
+		params = append(params, string(metadata))
+	}
+
+	return sql.String(), params, nil
+}
+
+// args flattens query arguments, expanding []any filter parameters in place
+func args(values ...any) []any {
+	var flat []any
+	for _, v := range values {
+		if group, ok := v.([]any); ok {
+			flat = append(flat, group...)
+		} else {
+			flat = append(flat, v)
+		}
+	}
+	return flat
+}
+
 // DeleteEmbeddingsByDocumentID performs soft delete on all embeddings for a document
 func (r *EmbeddingRepositoryImpl) DeleteEmbeddingsByDocumentID(ctx context.Context, docID string) error {
 	if err := r.db.WithContext(ctx).Where("document_id = ?", docID).Delete(&models.Embedding{}).Error; err != nil {
@@ -84,3 +369,98 @@ func (r *EmbeddingRepositoryImpl) DeleteEmbeddingsByDocumentID(ctx context.Conte
 	}
 	return nil
 }
+
+// PurgeEmbeddingsByDocumentID permanently removes all embeddings for a document
+// Used when the document itself is hard deleted
+func (r *EmbeddingRepositoryImpl) PurgeEmbeddingsByDocumentID(ctx context.Context, docID string) error {
+	if err := r.db.WithContext(ctx).Unscoped().Where("document_id = ?", docID).Delete(&models.Embedding{}).Error; err != nil {
+		return fmt.Errorf("failed to purge embeddings: %w", err)
+	}
+	return nil
+}
+
+// ListModels returns per-model chunk counts across all embedding models
+func (r *EmbeddingRepositoryImpl) ListModels(ctx context.Context) ([]*models.EmbeddingModelStats, error) {
+	var stats []*models.EmbeddingModelStats
+
+	err := r.db.WithContext(ctx).Model(&models.Embedding{}).
+		Select("model, dimensions, COUNT(DISTINCT document_id) AS documents, COUNT(*) AS chunks").
+		Group("model, dimensions").
+		Order("model").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list embedding models: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Coverage counts the live documents and lists those without chunks for this model
+// Documents without content are left out - they never get chunks
+func (r *EmbeddingRepositoryImpl) Coverage(ctx context.Context) (*models.EmbeddingCoverage, error) {
+	coverage := &models.EmbeddingCoverage{Model: r.model}
+
+	err := r.db.WithContext(ctx).Model(&models.Document{}).
+		Where("btrim(content) <> ''").
+		Count(&coverage.Documents).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents: %w", err)
+	}
+
+	err = r.db.WithContext(ctx).Model(&models.Document{}).
+		Where("btrim(content) <> ''").
+		Where("NOT EXISTS (?)", r.db.Model(&models.Embedding{}).
+			Select("1").
+			Where("embeddings.document_id = documents.id AND embeddings.model = ?", r.model)).
+		Order("id").
+		Pluck("id", &coverage.Missing).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unembedded documents: %w", err)
+	}
+
+	return coverage, nil
+}
+
+// purgeBatchSize is how many rows each DELETE of PurgeModel removes
+const purgeBatchSize = 5000
+
+// PurgeModel permanently removes every chunk of an embedding model, its index
+// and its cached embeddings
+// Learning: A model can have millions of rows. One big transaction (and a
+// plain DROP INDEX in it) would hold a lock on embeddings until the very end,
+// blocking every search - so the index is dropped CONCURRENTLY first and the
+// rows go in small batches, each committed on its own. An interrupted purge
+// can simply be run again.
+func (r *EmbeddingRepositoryImpl) PurgeModel(ctx context.Context, model string) error {
+	db := r.db.WithContext(ctx)
+
+	// CONCURRENTLY can't run inside a transaction block
+	if err := db.Exec("DROP INDEX CONCURRENTLY IF EXISTS " + vectorIndexName(model)).Error; err != nil {
+		return fmt.Errorf("failed to drop vector index: %w", err)
+	}
+	r.index.indexed.Delete(model) // Only once the index is really gone
+
+	if err := deleteInBatches(db, "embeddings", model); err != nil {
+		return fmt.Errorf("failed to purge embeddings: %w", err)
+	}
+	if err := deleteInBatches(db, models.EmbeddingCacheEntry{}.TableName(), model); err != nil {
+		return fmt.Errorf("failed to purge cached embeddings: %w", err)
+	}
+	return nil
+}
+
+// deleteInBatches deletes a model's rows from a table, purgeBatchSize at a time
+func deleteInBatches(db *gorm.DB, table, model string) error {
+	for {
+		result := db.Exec(
+			"DELETE FROM "+table+" WHERE ctid IN (SELECT ctid FROM "+table+" WHERE model = ? LIMIT ?)",
+			model, purgeBatchSize,
+		)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected < purgeBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ai-kms/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+LEARNING: POSTGRES AS A JOB QUEUE
+
+Claiming a job is a short transaction:
+
+  BEGIN;
+  SELECT * FROM embedding_jobs
+   WHERE status = 'pending' AND run_after <= now()
+   ORDER BY run_after, id
+   LIMIT 1
+   FOR UPDATE SKIP LOCKED;          -- other workers skip this row
+  UPDATE embedding_jobs SET status = 'running', attempts = attempts + 1 ...;
+  COMMIT;
+
+Many workers (even across server instances) can claim concurrently
+without ever picking the same job.
+*/
+
+// JobRepositoryImpl handles embedding job persistence
+type JobRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewJobRepository creates a new job repository
+func NewJobRepository(db *gorm.DB) *JobRepositoryImpl {
+	return &JobRepositoryImpl{db: db}
+}
+
+// Enqueue creates a new pending job for a document
+func (r *JobRepositoryImpl) Enqueue(ctx context.Context, documentID string, maxAttempts int) (*models.EmbeddingJob, error) {
+	job := &models.EmbeddingJob{
+		DocumentID:  documentID,
+		Status:      models.JobStatusPending,
+		MaxAttempts: maxAttempts,
+	}
+
+	if err := r.db.WithContext(ctx).Create(job).Error; err != nil {
+		return nil, fmt.Errorf("failed to enqueue embedding job: %w", err)
+	}
+
+	return job, nil
+}
+
+// EnqueueForModel creates pending jobs embedding documents into a specific model
+// Documents that already have a queued or running job for the model are skipped
+// Returns the number of jobs created
+func (r *JobRepositoryImpl) EnqueueForModel(ctx context.Context, documentIDs []string, model string, maxAttempts int) (int, error) {
+	if len(documentIDs) == 0 {
+		return 0, nil
+	}
+
+	var queued []string
+	if err := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("model = ? AND status IN ? AND document_id IN ?",
+			model, []models.JobStatus{models.JobStatusPending, models.JobStatusRunning}, documentIDs).
+		Pluck("document_id", &queued).Error; err != nil {
+		return 0, fmt.Errorf("failed to check queued jobs: %w", err)
+	}
+	skip := make(map[string]bool, len(queued))
+	for _, id := range queued {
+		skip[id] = true
+	}
+
+	jobs := make([]*models.EmbeddingJob, 0, len(documentIDs))
+	for _, id := range documentIDs {
+		if !skip[id] {
+			jobs = append(jobs, &models.EmbeddingJob{
+				DocumentID:  id,
+				Model:       model,
+				Status:      models.JobStatusPending,
+				MaxAttempts: maxAttempts,
+			})
+		}
+	}
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+
+	if err := r.db.WithContext(ctx).CreateInBatches(jobs, 500).Error; err != nil {
+		return 0, fmt.Errorf("failed to enqueue embedding jobs: %w", err)
+	}
+	return len(jobs), nil
+}
+
+// ClaimNext atomically takes the oldest runnable job and marks it running
+// Returns nil (and no error) when the queue is empty
+func (r *JobRepositoryImpl) ClaimNext(ctx context.Context) (*models.EmbeddingJob, error) {
+	var claimed *models.EmbeddingJob
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var job models.EmbeddingJob
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_after <= ?", models.JobStatusPending, time.Now()).
+			Order("model <> '', run_after, id"). // Edits first - a migration shouldn't delay fresh documents
+			First(&job).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil // Nothing to do
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		job.Status = models.JobStatusRunning
+		job.Attempts++
+		job.StartedAt = &now
+
+		if err := tx.Model(&job).Updates(map[string]interface{}{
+			"status":     job.Status,
+			"attempts":   job.Attempts,
+			"started_at": now,
+		}).Error; err != nil {
+			return err
+		}
+
+		claimed = &job
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim embedding job: %w", err)
+	}
+
+	return claimed, nil
+}
+
+// MarkSucceeded records a successful run
+func (r *JobRepositoryImpl) MarkSucceeded(ctx context.Context, id string) error {
+	err := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusSucceeded,
+			"last_error":  "",
+			"finished_at": time.Now(),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark job succeeded: %w", err)
+	}
+	return nil
+}
+
+// MarkRetry puts a failed job back in the queue, to run no earlier than runAfter
+func (r *JobRepositoryImpl) MarkRetry(ctx context.Context, id string, jobErr error, runAfter time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     models.JobStatusPending,
+			"last_error": jobErr.Error(),
+			"run_after":  runAfter,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to reschedule job: %w", err)
+	}
+	return nil
+}
+
+// Requeue puts a job back in the queue to run right away, without counting
+// the attempt it just made
+func (r *JobRepositoryImpl) Requeue(ctx context.Context, id string) error {
+	err := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":    models.JobStatusPending,
+			"attempts":  gorm.Expr("GREATEST(attempts - 1, 0)"),
+			"run_after": time.Now(),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records a permanent failure (no more retries)
+func (r *JobRepositoryImpl) MarkFailed(ctx context.Context, id string, jobErr error) error {
+	err := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusFailed,
+			"last_error":  jobErr.Error(),
+			"finished_at": time.Now(),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark job failed: %w", err)
+	}
+	return nil
+}
+
+// RequeueStale resets jobs stuck in "running" for longer than olderThan
+// Learning: A worker that crashed mid-job never marks it finished, so
+// without this the job would stay "running" forever
+func (r *JobRepositoryImpl) RequeueStale(ctx context.Context, olderThan time.Duration) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("status = ? AND started_at < ?", models.JobStatusRunning, time.Now().Add(-olderThan)).
+		Updates(map[string]interface{}{
+			"status":    models.JobStatusPending,
+			"run_after": time.Now(),
+		})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to requeue stale jobs: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// CancelPending marks a document's queued jobs as failed
+// Used when the document is deleted, so workers don't index a ghost
+func (r *JobRepositoryImpl) CancelPending(ctx context.Context, documentID string, reason string) error {
+	err := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("document_id = ? AND status = ?", documentID, models.JobStatusPending).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusFailed,
+			"last_error":  reason,
+			"finished_at": time.Now(),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to cancel pending jobs: %w", err)
+	}
+	return nil
+}
+
+// GetByID retrieves a job by its KSUID
+func (r *JobRepositoryImpl) GetByID(ctx context.Context, id string) (*models.EmbeddingJob, error) {
+	var job models.EmbeddingJob
+
+	err := r.db.WithContext(ctx).First(&job, "id = ?", id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("job not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// GetLatestByDocumentID returns the most recently submitted job for a document
+// Migration jobs for a specific model are left out
+// Returns nil (and no error) if the document was never submitted
+func (r *JobRepositoryImpl) GetLatestByDocumentID(ctx context.Context, documentID string) (*models.EmbeddingJob, error) {
+	var job models.EmbeddingJob
+
+	err := r.db.WithContext(ctx).
+		Where("document_id = ? AND model = ''", documentID).
+		// Learning: KSUIDs only have 1-second resolution (the rest is random),
+		// so a create and an update in the same second could come back swapped
+		Order("created_at DESC, id DESC").
+		First(&job).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest job: %w", err)
+	}
+
+	return &job, nil
+}
+
+// CountByStatus returns how many jobs are in the given state
+func (r *JobRepositoryImpl) CountByStatus(ctx context.Context, status models.JobStatus) (int64, error) {
+	var count int64
+
+	if err := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("status = ?", status).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count jobs: %w", err)
+	}
+
+	return count, nil
+}
+
+// CountByModel returns how many jobs for an embedding model are in the given state
+func (r *JobRepositoryImpl) CountByModel(ctx context.Context, model string, status models.JobStatus) (int64, error) {
+	var count int64
+
+	if err := r.db.WithContext(ctx).Model(&models.EmbeddingJob{}).
+		Where("model = ? AND status = ?", model, status).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count jobs: %w", err)
+	}
+
+	return count, nil
+}
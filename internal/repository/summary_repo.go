@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"ai-kms/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SummaryRepositoryImpl handles cached document summaries
+type SummaryRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewSummaryRepository creates a new summary repository
+func NewSummaryRepository(db *gorm.DB) *SummaryRepositoryImpl {
+	return &SummaryRepositoryImpl{db: db}
+}
+
+// Get returns the cached summary for a document version, written with the
+// given prompt set and chat model
+// Returns nil (and no error) on a cache miss
+func (r *SummaryRepositoryImpl) Get(ctx context.Context, documentID, contentHash string, mode models.SummaryMode, maxWords int, promptSet, chatModel string) (*models.DocumentSummary, error) {
+	var summary models.DocumentSummary
+
+	err := r.db.WithContext(ctx).
+		Where("document_id = ? AND content_hash = ? AND mode = ? AND max_words = ? AND prompt_set = ? AND chat_model = ?",
+			documentID, contentHash, mode, maxWords, promptSet, chatModel).
+		First(&summary).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get summary: %w", err)
+	}
+
+	return &summary, nil
+}
+
+// Save stores a summary and drops summaries of older versions of the document
+func (r *SummaryRepositoryImpl) Save(ctx context.Context, summary *models.DocumentSummary) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("document_id = ? AND content_hash <> ?", summary.DocumentID, summary.ContentHash).
+			Delete(&models.DocumentSummary{}).Error; err != nil {
+			return fmt.Errorf("failed to delete stale summaries: %w", err)
+		}
+
+		// Two concurrent requests may race to fill the same cache entry - last one wins
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{
+				{Name: "document_id"}, {Name: "content_hash"}, {Name: "mode"}, {Name: "max_words"},
+				{Name: "prompt_set"}, {Name: "chat_model"},
+			},
+			DoUpdates: clause.AssignmentColumns([]string{"summary", "sections", "chunk_count", "created_at"}),
+		}).Create(summary).Error; err != nil {
+			return fmt.Errorf("failed to store summary: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteByDocumentID removes every cached summary of a document
+func (r *SummaryRepositoryImpl) DeleteByDocumentID(ctx context.Context, documentID string) error {
+	if err := r.db.WithContext(ctx).Where("document_id = ?", documentID).Delete(&models.DocumentSummary{}).Error; err != nil {
+		return fmt.Errorf("failed to delete summaries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-kms/internal/models"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+/*
+LEARNING: HNSW VS IVFFLAT
+
+Both indexes trade exactness for speed - they return APPROXIMATE nearest
+neighbours. How much they miss is tunable at query time:
+
+  HNSW     A layered graph; a query walks from node to closer node.
+           Build: m (links per node), ef_construction (candidates per insert)
+           Query: hnsw.ef_search - candidates kept while walking (default 40).
+           Works from the first row, handles inserts well, uses more memory.
+
+  IVFFlat  Rows are clustered around `lists` centroids when the index is
+           built; a query only scans the nearest clusters.
+           Query: ivfflat.probes - clusters scanned (default 1!)
+           Centroids come from the data present at build time, so an index
+           built on an empty table is useless. Rebuild as the data grows.
+
+Query settings are applied with SET LOCAL, which only lasts until the end
+of the transaction - so every vector query runs in a short transaction.
+
+Recall is measured by running stored chunks as queries twice: once through
+the index and once with index scans disabled (exact), and comparing the top k.
+*/
+
+const (
+	maxIndexedDimensions = 2000 // Largest vector pgvector can index; larger models use exact scans
+	maxEFSearch          = 1000 // pgvector's upper bound for hnsw.ef_search
+	ivfflatMinRows       = 1000 // Below this, exact scans are fast and clusters would be meaningless
+)
+
+// ErrIndexBuildInProgress means a rebuild of the same index is already running
+var ErrIndexBuildInProgress = errors.New("vector index build already in progress")
+
+// DefaultVectorIndexConfig are pgvector's defaults, with more IVFFlat probes
+var DefaultVectorIndexConfig = models.VectorIndexConfig{
+	Type:           models.VectorIndexHNSW,
+	M:              16,
+	EFConstruction: 64,
+	Search:         models.VectorSearchParams{EFSearch: 40, Probes: 10},
+}
+
+// vectorIndexState is shared by all model-scoped copies of a repository
+type vectorIndexState struct {
+	config   models.VectorIndexConfig
+	indexed  sync.Map // Models whose vector index is known to exist
+	building sync.Map // Models with a rebuild in progress
+}
+
+func newVectorIndexState() *vectorIndexState {
+	return &vectorIndexState{config: DefaultVectorIndexConfig}
+}
+
+// SetIndexConfig selects the index type and parameters for indexes built from now on
+// Existing indexes keep their settings until they are rebuilt
+func (r *EmbeddingRepositoryImpl) SetIndexConfig(config models.VectorIndexConfig) {
+	r.index.config = config
+}
+
+// IndexConfig returns the index type and parameters in use
+func (r *EmbeddingRepositoryImpl) IndexConfig() models.VectorIndexConfig {
+	return r.index.config
+}
+
+type searchParamsKey struct{}
+
+// WithSearchParams overrides the query-time index settings for searches run with ctx
+func WithSearchParams(ctx context.Context, params models.VectorSearchParams) context.Context {
+	return context.WithValue(ctx, searchParamsKey{}, params)
+}
+
+// searchParams merges the settings from ctx with the configured defaults
+func (r *EmbeddingRepositoryImpl) searchParams(ctx context.Context) models.VectorSearchParams {
+	params := r.index.config.Search
+	if override, ok := ctx.Value(searchParamsKey{}).(models.VectorSearchParams); ok {
+		if override.EFSearch > 0 {
+			params.EFSearch = override.EFSearch
+		}
+		if override.Probes > 0 {
+			params.Probes = override.Probes
+		}
+	}
+	return params
+}
+
+// withSearchParams runs fn in a transaction with the query-time index settings applied
+// Learning: HNSW returns at most ef_search rows, so it is raised to the limit
+func (r *EmbeddingRepositoryImpl) withSearchParams(ctx context.Context, limit int, fn func(tx *gorm.DB) error) error {
+	params := r.searchParams(ctx)
+	efSearch := min(max(params.EFSearch, limit), maxEFSearch)
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL hnsw.ef_search = %d", efSearch)).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL ivfflat.probes = %d", max(params.Probes, 1))).Error; err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// EnsureVectorIndex creates the partial vector index for this repository's model
+// IVFFlat indexes wait until there are enough rows to cluster
+func (r *EmbeddingRepositoryImpl) EnsureVectorIndex(ctx context.Context, dims int) error {
+	if _, ok := r.index.indexed.Load(r.model); ok || dims <= 0 || dims > maxIndexedDimensions {
+		return nil
+	}
+
+	lists := 0
+	if r.index.config.Type == models.VectorIndexIVFFlat {
+		_, rows, err := r.modelSize(ctx)
+		if err != nil {
+			return err
+		}
+		if rows < ivfflatMinRows {
+			return nil
+		}
+		lists = ivfflatLists(r.index.config.Lists, rows)
+	}
+
+	if err := r.db.WithContext(ctx).Exec(r.indexDDL(vectorIndexName(r.model), dims, lists, false)).Error; err != nil {
+		return fmt.Errorf("failed to create vector index for %s: %w", r.model, err)
+	}
+
+	r.index.indexed.Store(r.model, true)
+	return nil
+}
+
+// EnsureVectorIndexes creates the vector index of every model with stored chunks
+func (r *EmbeddingRepositoryImpl) EnsureVectorIndexes(ctx context.Context) error {
+	var rows []struct {
+		Model      string
+		Dimensions int
+	}
+	if err := r.db.WithContext(ctx).Model(&models.Embedding{}).
+		Distinct("model", "dimensions").
+		Scan(&rows).Error; err != nil {
+		return fmt.Errorf("failed to list embedding models: %w", err)
+	}
+
+	for _, row := range rows {
+		if err := r.ForModel(row.Model).EnsureVectorIndex(ctx, row.Dimensions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildVectorIndex replaces this model's index with one built from the
+// current config and data
+// Learning: The new index is built CONCURRENTLY under a temporary name and
+// swapped in, so searches and writes continue during the build
+func (r *EmbeddingRepositoryImpl) RebuildVectorIndex(ctx context.Context) error {
+	if _, busy := r.index.building.LoadOrStore(r.model, true); busy {
+		return ErrIndexBuildInProgress
+	}
+	defer r.index.building.Delete(r.model)
+
+	dims, rows, err := r.modelSize(ctx)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("no embeddings stored for model %s", r.model)
+	}
+	if dims > maxIndexedDimensions {
+		return fmt.Errorf("model %s has %d dimensions, pgvector indexes at most %d", r.model, dims, maxIndexedDimensions)
+	}
+
+	name, tmp := vectorIndexName(r.model), rebuildIndexName(r.model)
+	// CONCURRENTLY can't run inside a transaction block - one statement per Exec
+	steps := []string{
+		"DROP INDEX CONCURRENTLY IF EXISTS " + tmp, // Left invalid by an interrupted build
+		r.indexDDL(tmp, dims, ivfflatLists(r.index.config.Lists, rows), true),
+		"DROP INDEX CONCURRENTLY IF EXISTS " + name,
+		"ALTER INDEX " + tmp + " RENAME TO " + name,
+	}
+	for _, step := range steps {
+		if err := r.db.WithContext(ctx).Exec(step).Error; err != nil {
+			return fmt.Errorf("failed to rebuild vector index for %s: %w", r.model, err)
+		}
+	}
+
+	r.index.indexed.Store(r.model, true)
+	return nil
+}
+
+// IndexBuildInProgress reports whether this model's index is being rebuilt
+func (r *EmbeddingRepositoryImpl) IndexBuildInProgress() bool {
+	_, busy := r.index.building.Load(r.model)
+	return busy
+}
+
+// VectorIndexStats describes the vector index of every model with stored chunks
+func (r *EmbeddingRepositoryImpl) VectorIndexStats(ctx context.Context) ([]*models.VectorIndexStats, error) {
+	modelStats, err := r.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]*models.VectorIndexStats, 0, len(modelStats))
+	for _, m := range modelStats {
+		st := &models.VectorIndexStats{Model: m.Model, Dimensions: m.Dimensions, Chunks: m.Chunks}
+
+		var index struct {
+			Name      string
+			Type      string
+			Options   string
+			SizeBytes int64
+			Valid     bool
+		}
+		err := r.db.WithContext(ctx).Raw(`
+			SELECT
+				c.relname AS name,
+				am.amname AS type,
+				coalesce(array_to_string(c.reloptions, ', '), '') AS options,
+				pg_relation_size(c.oid) AS size_bytes,
+				i.indisvalid AS valid
+			FROM pg_class c
+			JOIN pg_index i ON i.indexrelid = c.oid
+			JOIN pg_am am ON am.oid = c.relam
+			WHERE c.relname = ?
+		`, vectorIndexName(m.Model)).Scan(&index).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index stats: %w", err)
+		}
+		st.Name, st.Type, st.Options, st.SizeBytes, st.Valid = index.Name, index.Type, index.Options, index.SizeBytes, index.Valid
+
+		var build models.VectorIndexBuild
+		err = r.db.WithContext(ctx).Raw(`
+			SELECT p.phase, p.tuples_done, p.tuples_total
+			FROM pg_stat_progress_create_index p
+			JOIN pg_class c ON c.oid = p.index_relid
+			WHERE c.relname IN (?, ?)
+		`, vectorIndexName(m.Model), rebuildIndexName(m.Model)).Scan(&build).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index build progress: %w", err)
+		}
+		if build.Phase != "" {
+			st.Building = &build
+		}
+
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// MeasureRecall runs sample stored chunks as queries through the index and
+// as exact scans, and reports how much of the exact top k the index finds
+func (r *EmbeddingRepositoryImpl) MeasureRecall(ctx context.Context, sample, k int) (*models.VectorIndexRecall, error) {
+	dims, rows, err := r.modelSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, fmt.Errorf("no embeddings stored for model %s", r.model)
+	}
+
+	var queries []pgvector.Vector
+	if err := r.db.WithContext(ctx).Model(&models.Embedding{}).
+		Where("model = ?", r.model).
+		Order("random()").
+		Limit(sample).
+		Pluck("embedding", &queries).Error; err != nil {
+		return nil, fmt.Errorf("failed to sample embeddings: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT e.id FROM embeddings e
+		WHERE e.model = ? AND e.deleted_at IS NULL
+		ORDER BY (e.embedding::vector(%d)) <=> ?
+		LIMIT ?
+	`, dims)
+
+	var found, total int
+	var indexTime, exactTime time.Duration
+	for _, q := range queries {
+		start := time.Now()
+		var approx []string
+		err := r.withSearchParams(ctx, k, func(tx *gorm.DB) error {
+			return tx.Raw(query, r.model, q, k).Scan(&approx).Error
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to run index search: %w", err)
+		}
+		indexTime += time.Since(start)
+
+		start = time.Now()
+		var exact []string
+		err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SET LOCAL enable_indexscan = off").Error; err != nil {
+				return err
+			}
+			return tx.Raw(query, r.model, q, k).Scan(&exact).Error
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to run exact search: %w", err)
+		}
+		exactTime += time.Since(start)
+
+		hits := make(map[string]bool, len(approx))
+		for _, id := range approx {
+			hits[id] = true
+		}
+		for _, id := range exact {
+			if hits[id] {
+				found++
+			}
+		}
+		total += len(exact)
+	}
+
+	params := r.searchParams(ctx)
+	params.EFSearch = min(max(params.EFSearch, k), maxEFSearch)
+	result := &models.VectorIndexRecall{Model: r.model, Sample: len(queries), K: k, Params: params}
+	if total > 0 {
+		result.Recall = float64(found) / float64(total)
+	}
+	if n := len(queries); n > 0 {
+		result.IndexLatencyMs = float64(indexTime.Microseconds()) / 1000 / float64(n)
+		result.ExactLatencyMs = float64(exactTime.Microseconds()) / 1000 / float64(n)
+	}
+	return result, nil
+}
+
+// modelSize returns the dimension and row count (soft-deleted rows included,
+// since they are in the index too) of this repository's model
+func (r *EmbeddingRepositoryImpl) modelSize(ctx context.Context) (int, int64, error) {
+	var size struct {
+		Dimensions int
+		Rows       int64
+	}
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.Embedding{}).
+		Select("coalesce(max(dimensions), 0) AS dimensions, count(*) AS rows").
+		Where("model = ?", r.model).
+		Scan(&size).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count embeddings: %w", err)
+	}
+	return size.Dimensions, size.Rows, nil
+}
+
+// indexDDL is the CREATE INDEX statement for this model with the configured type
+// Learning: Model names end up in DDL, where bind parameters aren't allowed,
+// so the name is quoted as a literal and only used in the WHERE clause
+func (r *EmbeddingRepositoryImpl) indexDDL(name string, dims, lists int, concurrently bool) string {
+	cfg := r.index.config
+	method, options := "hnsw", fmt.Sprintf("m = %d, ef_construction = %d", cfg.M, cfg.EFConstruction)
+	if cfg.Type == models.VectorIndexIVFFlat {
+		method, options = "ivfflat", fmt.Sprintf("lists = %d", lists)
+	}
+
+	create := "CREATE INDEX IF NOT EXISTS"
+	if concurrently {
+		create = "CREATE INDEX CONCURRENTLY IF NOT EXISTS"
+	}
+
+	return fmt.Sprintf(`%s %s
+		ON embeddings USING %s ((embedding::vector(%d)) vector_cosine_ops)
+		WITH (%s)
+		WHERE model = '%s'`,
+		create, name, method, dims, options, strings.ReplaceAll(r.model, "'", "''"))
+}
+
+// ivfflatLists picks the number of IVFFlat clusters
+// Learning: pgvector suggests rows/1000 up to 1M rows and sqrt(rows) beyond
+func ivfflatLists(configured int, rows int64) int {
+	switch {
+	case configured > 0:
+		return configured
+	case rows <= 1_000_000:
+		return max(int(rows/1000), 1)
+	default:
+		return int(math.Sqrt(float64(rows)))
+	}
+}
+
+// vectorIndexName derives a valid, unique index name from a model name
+func vectorIndexName(model string) string {
+	return fmt.Sprintf("idx_embeddings_vector_%s_%08x", modelSlug(model), modelHash(model))
+}
+
+// rebuildIndexName is the temporary name of an index being rebuilt
+func rebuildIndexName(model string) string {
+	return fmt.Sprintf("idx_embeddings_vector_rebuild_%08x", modelHash(model))
+}
+
+// modelSlug keeps the readable part of a model name for index names
+func modelSlug(model string) string {
+	slug := strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			return c
+		}
+		return '_'
+	}, strings.ToLower(model))
+	if len(slug) > 32 {
+		slug = slug[:32]
+	}
+	return slug
+}
+
+// modelHash keeps model names with the same slug apart
+// ("text-embedding-3" and "text_embedding_3")
+func modelHash(model string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(model))
+	return h.Sum32()
+}
+
+// vectorExpr is the embedding column cast to the query's dimension, matching
+// the expression of the per-model vector index
+func vectorExpr(queryEmbedding []float32) string {
+	return fmt.Sprintf("(e.embedding::vector(%d))", len(queryEmbedding))
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"ai-kms/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// VersionRepositoryImpl handles document version history
+type VersionRepositoryImpl struct {
+	db *gorm.DB
+}
+
+// NewVersionRepository creates a new version repository
+func NewVersionRepository(db *gorm.DB) *VersionRepositoryImpl {
+	return &VersionRepositoryImpl{db: db}
+}
+
+// Create stores a version
+func (r *VersionRepositoryImpl) Create(ctx context.Context, version *models.DocumentVersion) error {
+	if err := r.db.WithContext(ctx).Create(version).Error; err != nil {
+		return fmt.Errorf("failed to store version: %w", err)
+	}
+	return nil
+}
+
+// List returns a document's versions, newest first, without their content
+func (r *VersionRepositoryImpl) List(ctx context.Context, documentID string, limit, offset int) ([]*models.DocumentVersion, error) {
+	var versions []*models.DocumentVersion
+
+	err := r.db.WithContext(ctx).
+		Omit("content").
+		Where("document_id = ?", documentID).
+		// Learning: KSUIDs only have 1-second resolution (the rest is random),
+		// so versions from the same second would come back in random order
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// GetByID returns one version of a document
+// Returns nil (and no error) when there is no such version
+func (r *VersionRepositoryImpl) GetByID(ctx context.Context, documentID, id string) (*models.DocumentVersion, error) {
+	var version models.DocumentVersion
+
+	err := r.db.WithContext(ctx).First(&version, "id = ? AND document_id = ?", id, documentID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version: %w", err)
+	}
+
+	return &version, nil
+}
+
+// Latest returns the newest version of a document, without its content
+// Returns nil (and no error) for a document without versions
+func (r *VersionRepositoryImpl) Latest(ctx context.Context, documentID string) (*models.DocumentVersion, error) {
+	versions, err := r.List(ctx, documentID, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	return versions[0], nil
+}
+
+// DeleteByDocumentID removes the whole history of a document
+func (r *VersionRepositoryImpl) DeleteByDocumentID(ctx context.Context, documentID string) error {
+	if err := r.db.WithContext(ctx).Where("document_id = ?", documentID).Delete(&models.DocumentVersion{}).Error; err != nil {
+		return fmt.Errorf("failed to delete versions: %w", err)
+	}
+	return nil
+}
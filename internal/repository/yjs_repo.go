@@ -21,6 +21,12 @@ Query patterns:
 - GetAllUpdates: Initial sync (get everything)
 - GetUpdatesSince: Incremental sync (get new updates)
 - StoreUpdate: Persist changes
+- ReplaceWithSnapshot: Compact the log (see collaboration/compaction.go)
+
+Never delete updates on their own: a document's state is the sum of its
+whole log, and an item whose insertion is gone breaks every later update
+that refers to it. The log only shrinks by replacing updates with a
+snapshot that contains them.
 */
 
 // YjsRepositoryImpl handles Yjs update storage
@@ -108,41 +114,27 @@ func (r *YjsRepositoryImpl) GetLatestUpdate(ctx context.Context, documentID stri
 	return &update, nil
 }
 
-// DeleteOldUpdates removes updates older than a certain threshold
-// Call periodically to prevent unbounded growth
-func (r *YjsRepositoryImpl) DeleteOldUpdates(ctx context.Context, documentID string, keepCount int) error {
-	// Get total count
-	var count int64
-	if err := r.db.WithContext(ctx).
-		Model(&models.YjsUpdate{}).
-		Where("document_id = ?", documentID).
-		Count(&count).Error; err != nil {
-		return err
-	}
-
-	if count <= int64(keepCount) {
-		return nil // Nothing to delete
-	}
-
-	// Get the cutoff update
-	var cutoffUpdate models.YjsUpdate
-	offset := count - int64(keepCount)
-	if err := r.db.WithContext(ctx).
-		Where("document_id = ?", documentID).
-		Order("created_at ASC").
-		Offset(int(offset)).
-		First(&cutoffUpdate).Error; err != nil {
-		return err
-	}
-
-	// Delete updates before cutoff
-	result := r.db.WithContext(ctx).
-		Where("document_id = ? AND created_at < ?", documentID, cutoffUpdate.CreatedAt).
-		Delete(&models.YjsUpdate{})
-
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete old updates: %w", result.Error)
-	}
+// ReplaceWithSnapshot swaps the superseded updates for a snapshot containing them
+// Learning: One transaction, and every superseded row must still exist - if a
+// concurrent compaction got there first, the whole swap is rolled back
+func (r *YjsRepositoryImpl) ReplaceWithSnapshot(ctx context.Context, documentID string, supersededIDs []string, snapshot *models.YjsUpdate) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Batches keep the IN list within Postgres' parameter limit
+		for start := 0; start < len(supersededIDs); start += 1000 {
+			ids := supersededIDs[start:min(start+1000, len(supersededIDs))]
+			result := tx.Where("document_id = ? AND id IN ?", documentID, ids).Delete(&models.YjsUpdate{})
+			if result.Error != nil {
+				return fmt.Errorf("failed to delete superseded updates: %w", result.Error)
+			}
+			if result.RowsAffected != int64(len(ids)) {
+				return fmt.Errorf("update log changed during compaction (%d of %d updates left)", result.RowsAffected, len(ids))
+			}
+		}
 
-	return nil
+		snapshot.DocumentID = documentID
+		if err := tx.Create(snapshot).Error; err != nil {
+			return fmt.Errorf("failed to store snapshot: %w", err)
+		}
+		return nil
+	})
 }
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"ai-kms/internal/models"
+)
+
+/*
+LEARNING: VERIFIABLE CITATIONS
+
+Every chunk in the prompt is numbered ("[Document 2] Runbook > Restarts:"),
+and the model is told to put the number after each claim it supports:
+
+  "Restart the deployment [2]. Wait for the health check [2][3]."
+
+After generation we parse the markers back out:
+
+  segments:  "Restart the deployment."        → [2]
+             "Wait for the health check."     → [2, 3]
+  citations: [2] → document 2Bk..., chunk 4
+             [3] → document 2Bm..., chunk 0
+
+Models sometimes cite numbers that weren't in the context (hallucinated
+sources). Those markers are dropped from the segments and reported as
+invalid, and an answer without a single valid citation is flagged as not
+grounded - a cheap signal that it may not come from the knowledge base.
+*/
+
+// citationPattern matches [1], [1, 2] and [Document 1]
+// Use findCitations - not every match is a citation
+var citationPattern = regexp.MustCompile(`\[(?:Document\s+)?(\d+(?:\s*,\s*(?:Document\s+)?\d+)*)\]`)
+
+// findCitations returns the submatch indexes of the citation markers in text
+// Learning: Answers about code contain brackets that aren't citations -
+// arr[0] follows an identifier, and anything inside a ``` fence is code
+func findCitations(text string) [][]int {
+	fences := codeFences(text)
+
+	var markers [][]int
+	for _, m := range citationPattern.FindAllStringSubmatchIndex(text, -1) {
+		if m[0] > 0 && isIdentifierByte(text[m[0]-1]) {
+			continue
+		}
+		inCode := false
+		for _, f := range fences {
+			if m[0] >= f[0] && m[0] < f[1] {
+				inCode = true
+				break
+			}
+		}
+		if !inCode {
+			markers = append(markers, m)
+		}
+	}
+	return markers
+}
+
+// stripCitations removes the citation markers from text, leaving code alone
+func stripCitations(text string) string {
+	var b strings.Builder
+	pos := 0
+	for _, m := range findCitations(text) {
+		b.WriteString(text[pos:m[0]])
+		pos = m[1]
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
+
+// codeFences returns the byte ranges of fenced code blocks; an unclosed
+// fence runs to the end of the text
+func codeFences(text string) [][2]int {
+	var fences [][2]int
+	start := -1
+	for pos := 0; pos < len(text); {
+		end := strings.IndexByte(text[pos:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += pos + 1
+		}
+		if strings.HasPrefix(strings.TrimLeft(text[pos:end], " \t"), "```") {
+			if start < 0 {
+				start = pos
+			} else {
+				fences = append(fences, [2]int{start, end})
+				start = -1
+			}
+		}
+		pos = end
+	}
+	if start >= 0 {
+		fences = append(fences, [2]int{start, len(text)})
+	}
+	return fences
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// citedAnswer is an answer split into segments with verified citations
+type citedAnswer struct {
+	Segments  []models.AnswerSegment
+	Citations []models.Citation // Valid citations, in order of first use
+	Invalid   []int             // Markers that don't refer to a context chunk
+	Grounded  bool              // At least one valid citation
+}
+
+// citeAnswer splits an answer on its citation markers and checks every
+// marker against the chunks that were actually in the prompt
+func citeAnswer(answer string, sources []*models.SearchResult) citedAnswer {
+	var cited citedAnswer
+	used := make(map[int]bool)
+
+	pos := 0
+	for _, m := range findCitations(answer) {
+		text := strings.TrimSpace(answer[pos:m[0]])
+		pos = m[1]
+
+		var markers []int
+		for _, field := range strings.Split(answer[m[2]:m[3]], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(field), "Document")))
+			if err != nil {
+				continue
+			}
+			if n < 1 || n > len(sources) {
+				if !slices.Contains(cited.Invalid, n) {
+					cited.Invalid = append(cited.Invalid, n)
+				}
+				continue
+			}
+			markers = append(markers, n)
+			if !used[n] {
+				used[n] = true
+				src := sources[n-1]
+				cited.Citations = append(cited.Citations, models.Citation{
+					Marker:     n,
+					DocumentID: src.DocumentID,
+					ChunkIndex: src.ChunkIndex,
+					Title:      src.Title,
+					Section:    src.Section,
+				})
+			}
+		}
+
+		// "[1][2]" and "[1] [2]" cite the same text
+		if text == "" && len(cited.Segments) > 0 {
+			last := &cited.Segments[len(cited.Segments)-1]
+			last.Citations = appendUnique(last.Citations, markers...)
+		} else if text != "" {
+			cited.Segments = append(cited.Segments, models.AnswerSegment{
+				Text:      text,
+				Citations: appendUnique(nil, markers...),
+			})
+		}
+
+		// Punctuation after a marker ends the cited sentence: "... deployment [2]."
+		punct := 0
+		for punct < len(answer)-pos && strings.ContainsRune(".,;:!?", rune(answer[pos+punct])) {
+			punct++
+		}
+		if punct > 0 && len(cited.Segments) > 0 {
+			cited.Segments[len(cited.Segments)-1].Text += answer[pos : pos+punct]
+			pos += punct
+		}
+	}
+
+	if rest := strings.TrimSpace(answer[pos:]); rest != "" {
+		cited.Segments = append(cited.Segments, models.AnswerSegment{Text: rest})
+	}
+
+	cited.Grounded = len(cited.Citations) > 0
+	return cited
+}
+
+// appendUnique appends the values not already in list
+func appendUnique(list []int, values ...int) []int {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,325 @@
+package collaboration
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"ai-kms/internal/models"
+	"ai-kms/internal/yjs"
+)
+
+/*
+LEARNING: AWARENESS (PRESENCE)
+
+Awareness is how editors show who else is here: names, colors, cursors.
+An awareness update carries whole states, one per Yjs client:
+
+  [count] ([client ID] [clock] [JSON state])...
+
+  {"user": {"name": "Alice", "color": "#ff8935"},
+   "cursor": {"anchor": <relative position>, "head": <relative position>}}
+
+Nothing here is stored. Instead of acknowledging each other, clients follow
+three rules (y-protocols):
+
+  1. Every change bumps the clock; a state only replaces an older clock
+  2. Clients re-send their state every 15s, even unchanged
+  3. A state not renewed for 30s is gone - the client crashed or lost its
+     network without saying goodbye
+
+A client that leaves properly sends its state as null. One that just
+disconnects can't, so the server sends that null on its behalf.
+*/
+
+// awarenessTimeout is how long a state lives without being renewed
+// Learning: y-protocols' outdatedTimeout - clients renew at half of it
+const awarenessTimeout = 30 * time.Second
+
+// awarenessEntry is one client's state in an awareness update
+type awarenessEntry struct {
+	ClientID int
+	Clock    uint64
+	State    json.RawMessage // "null" when the client went offline
+}
+
+// decodeAwareness parses the payload of an awareness message
+func decodeAwareness(payload []byte) ([]awarenessEntry, error) {
+	d := yjs.NewDecoder(payload)
+	n, err := d.ReadVarUint()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]awarenessEntry, 0, min(n, 64)) // n comes from the client
+	for i := uint64(0); i < n; i++ {
+		clientID, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		state, err := d.ReadVarString()
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid([]byte(state)) {
+			return nil, fmt.Errorf("client %d sent invalid awareness JSON", clientID)
+		}
+		entries = append(entries, awarenessEntry{ClientID: int(clientID), Clock: clock, State: json.RawMessage(state)})
+	}
+	return entries, nil
+}
+
+// encodeAwareness frames states as an awareness message
+func encodeAwareness(entries []awarenessEntry) []byte {
+	update := &yjs.Encoder{}
+	update.WriteVarUint(uint64(len(entries)))
+	for _, e := range entries {
+		update.WriteVarUint(uint64(e.ClientID))
+		update.WriteVarUint(e.Clock)
+		update.WriteVarString(string(e.State))
+	}
+
+	e := &yjs.Encoder{}
+	e.WriteVarUint(uint64(models.MessageTypeAwareness))
+	e.WriteVarBytes(update.Bytes())
+	return e.Bytes()
+}
+
+// applyAwareness records the states in a session's awareness message
+// Returns an error for malformed messages, which shouldn't be relayed either
+func (sm *SessionManager) applyAwareness(session *Session, payload []byte) error {
+	entries, err := decodeAwareness(payload)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		sm.UpdateAwareness(session.DocumentID, newAwarenessState(session, e))
+	}
+	return nil
+}
+
+// newAwarenessState decodes the user and cursor of an awareness entry
+// Learning: Whatever the client doesn't say about its user comes from the
+// connection - every present user has a name
+func newAwarenessState(session *Session, e awarenessEntry) *models.AwarenessState {
+	state := &models.AwarenessState{
+		ClientID:    e.ClientID,
+		SessionID:   session.ID,
+		Clock:       e.Clock,
+		LastUpdated: time.Now(),
+	}
+
+	var decoded interface{}
+	_ = json.Unmarshal(e.State, &decoded) // Validated while decoding
+	if decoded == nil {
+		return state // Went offline
+	}
+	fields, ok := decoded.(map[string]interface{})
+	if !ok {
+		fields = map[string]interface{}{} // Not an object - nothing we can read
+	}
+	state.State = fields
+
+	user := &models.UserInfo{ID: session.UserID, Name: session.UserName}
+	if u, ok := fields["user"].(map[string]interface{}); ok {
+		if id, ok := u["id"].(string); ok && id != "" {
+			user.ID = id
+		}
+		if name, ok := u["name"].(string); ok && name != "" {
+			user.Name = name
+		}
+		user.Color, _ = u["color"].(string)
+	}
+	state.User = user
+
+	// Editors that share plain line/column cursors; relative positions are
+	// resolved against the document when presence is read
+	if c, ok := fields["cursor"].(map[string]interface{}); ok {
+		line, hasLine := c["line"].(float64)
+		column, hasColumn := c["column"].(float64)
+		if hasLine && hasColumn {
+			state.Cursor = &models.CursorPosition{Line: int(line), Column: int(column)}
+		}
+	}
+	return state
+}
+
+// UpdateAwareness updates user presence state, following the awareness clock rules
+// A nil State means the client went offline
+// Returns false for states older than the one already known
+func (sm *SessionManager) UpdateAwareness(documentID string, state *models.AwarenessState) bool {
+	sm.awareMu.Lock()
+	defer sm.awareMu.Unlock()
+
+	current := sm.awareness[documentID][state.ClientID]
+	switch {
+	case current == nil && state.State == nil:
+		return false // Never knew it
+	case current != nil && state.Clock < current.Clock:
+		return false
+	case current != nil && state.Clock == current.Clock && state.State != nil:
+		return false // Same clock: only going offline wins
+	}
+
+	if state.State == nil {
+		sm.removeAwarenessLocked(documentID, state.ClientID)
+		return true
+	}
+	if sm.awareness[documentID] == nil {
+		sm.awareness[documentID] = make(map[int]*models.AwarenessState)
+	}
+	sm.awareness[documentID][state.ClientID] = state
+	return true
+}
+
+// removeAwarenessLocked forgets a client's state; awareMu must be held
+func (sm *SessionManager) removeAwarenessLocked(documentID string, clientID int) {
+	delete(sm.awareness[documentID], clientID)
+	if len(sm.awareness[documentID]) == 0 {
+		delete(sm.awareness, documentID)
+	}
+}
+
+// GetAwareness returns all awareness states for a document, by client ID
+func (sm *SessionManager) GetAwareness(documentID string) []*models.AwarenessState {
+	sm.awareMu.RLock()
+	defer sm.awareMu.RUnlock()
+
+	states := make([]*models.AwarenessState, 0, len(sm.awareness[documentID]))
+	for _, state := range sm.awareness[documentID] {
+		copied := *state // Callers may fill in the cursor
+		states = append(states, &copied)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ClientID < states[j].ClientID })
+	return states
+}
+
+// Presence returns who is in a document, with cursors as lines and columns
+// Learning: Relative positions are resolved now rather than when they
+// arrive - other users' edits move a cursor without it sending anything
+func (sm *SessionManager) Presence(documentID string) []*models.AwarenessState {
+	states := sm.GetAwareness(documentID)
+
+	sm.roomsMu.Lock()
+	r := sm.rooms[documentID]
+	sm.roomsMu.Unlock()
+	if r == nil {
+		return states
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded {
+		return states
+	}
+	for _, state := range states {
+		if state.Cursor != nil {
+			continue
+		}
+		pos, ok := relativeCursor(state.State)
+		if !ok {
+			continue
+		}
+		if line, column, ok := r.doc.Locate(ContentField, pos); ok {
+			state.Cursor = &models.CursorPosition{Line: line, Column: column}
+		}
+	}
+	return states
+}
+
+// relativeCursor reads the caret of a y-prosemirror / y-codemirror cursor
+// Learning: Both share {anchor, head} as relative positions; the head is
+// where the caret is, the anchor where the selection started
+func relativeCursor(fields map[string]interface{}) (yjs.RelativePosition, bool) {
+	var pos yjs.RelativePosition
+	cursor, ok := fields["cursor"].(map[string]interface{})
+	if !ok || cursor["head"] == nil {
+		return pos, false
+	}
+	raw, err := json.Marshal(cursor["head"])
+	if err != nil || json.Unmarshal(raw, &pos) != nil {
+		return pos, false
+	}
+	return pos, true
+}
+
+// sendAwareness sends a session the awareness of everyone else in its document
+func (sm *SessionManager) sendAwareness(session *Session) {
+	var entries []awarenessEntry
+	for _, state := range sm.GetAwareness(session.DocumentID) {
+		if state.SessionID == session.ID {
+			continue
+		}
+		fields, err := json.Marshal(state.State)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, awarenessEntry{ClientID: state.ClientID, Clock: state.Clock, State: fields})
+	}
+	if len(entries) == 0 {
+		return
+	}
+	sm.Send(session, encodeAwareness(entries))
+}
+
+// removeAwareness takes a disconnected session's users offline for everyone else
+// Learning: The same message a client sends when it leaves properly - its
+// last clock with a null state
+func (sm *SessionManager) removeAwareness(session *Session) {
+	var removed []awarenessEntry
+	sm.awareMu.Lock()
+	for clientID, state := range sm.awareness[session.DocumentID] {
+		if state.SessionID == session.ID {
+			removed = append(removed, awarenessEntry{ClientID: clientID, Clock: state.Clock, State: json.RawMessage("null")})
+			sm.removeAwarenessLocked(session.DocumentID, clientID)
+		}
+	}
+	sm.awareMu.Unlock()
+
+	if len(removed) > 0 {
+		sm.Broadcast(session.DocumentID, encodeAwareness(removed), session)
+	}
+}
+
+// awarenessLoop expires states that stopped being renewed
+func (sm *SessionManager) awarenessLoop() {
+	ticker := time.NewTicker(awarenessTimeout / 10) // As often as y-protocols checks
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.done:
+			return
+		case <-ticker.C:
+			sm.expireAwareness()
+		}
+	}
+}
+
+// expireAwareness removes states older than the awareness timeout and tells
+// the document's users
+func (sm *SessionManager) expireAwareness() {
+	expired := make(map[string][]awarenessEntry) // documentID -> removals
+	sm.awareMu.Lock()
+	for documentID, states := range sm.awareness {
+		for clientID, state := range states {
+			if time.Since(state.LastUpdated) >= awarenessTimeout {
+				expired[documentID] = append(expired[documentID], awarenessEntry{ClientID: clientID, Clock: state.Clock, State: json.RawMessage("null")})
+				sm.removeAwarenessLocked(documentID, clientID)
+			}
+		}
+	}
+	sm.awareMu.Unlock()
+
+	// Outside the lock: queueing may wait for the event loop
+	for documentID, removed := range expired {
+		log.Printf("  Presence of %d client(s) in document %s timed out", len(removed), documentID)
+		sm.Broadcast(documentID, encodeAwareness(removed), nil)
+	}
+}
@@ -0,0 +1,111 @@
+package collaboration
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"ai-kms/internal/middleware"
+	"ai-kms/internal/models"
+	"ai-kms/internal/yjs"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+LEARNING: UPDATE LOG COMPACTION
+
+Every keystroke is stored as its own update, so a document edited for an
+afternoon has thousands of rows of a few dozen bytes - all replayed when
+its room loads. Yjs updates merge: applying them all to an empty document
+and encoding the result gives one update with the same effect, and deleted
+text is garbage collected along the way.
+
+  [u1][u2][u3] ... [u2000]   →   [snapshot + state vector]   (one transaction)
+
+Updates stored while a compaction runs aren't part of it and stay as they
+are - the order of updates never matters to a CRDT.
+*/
+
+// SetCompaction sets when a document's update log is merged into a snapshot:
+// once it holds maxUpdates rows or maxBytes bytes. A zero turns that
+// threshold off; compaction is off until one is set
+// Call before Start
+func (sm *SessionManager) SetCompaction(maxUpdates, maxBytes int) {
+	sm.compactUpdates = maxUpdates
+	sm.compactBytes = maxBytes
+}
+
+// needsCompaction reports whether a room's update log passed a threshold
+func (sm *SessionManager) needsCompaction(r *room) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sm.yjsRepo == nil || !r.loaded || r.compactError || r.logRows < 2 {
+		return false
+	}
+	return (sm.compactUpdates > 0 && r.logRows >= sm.compactUpdates) ||
+		(sm.compactBytes > 0 && r.logBytes >= sm.compactBytes)
+}
+
+// compact replaces a document's stored updates with one snapshot
+func (sm *SessionManager) compact(ctx context.Context, documentID string, r *room) error {
+	ctx, span := middleware.StartSpan(ctx, "Collaboration.Compact",
+		attribute.String("document.id", documentID),
+	)
+	defer span.End()
+
+	rows, err := sm.yjsRepo.GetAllUpdates(ctx, documentID)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return err
+	}
+	if len(rows) < 2 {
+		return nil
+	}
+
+	doc := yjs.NewDoc()
+	ids := make([]string, 0, len(rows))
+	before := 0
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+		before += len(row.Update)
+
+		msg, err := decodeMessage(row.Update)
+		if err != nil || !msg.isUpdate() {
+			continue // Awareness and sync requests stored by older versions carry no changes
+		}
+		// Learning: Better to keep a log we can't read than to drop part of it
+		if err := doc.ApplyUpdate(msg.Payload); err != nil {
+			err = fmt.Errorf("update %s: %w", row.ID, err)
+			middleware.AddSpanError(ctx, err)
+			return err
+		}
+	}
+
+	snapshot := &models.YjsUpdate{
+		Update:    encodeSyncMessage(models.SyncUpdate, doc.EncodeStateAsUpdate(nil)),
+		Vector:    doc.EncodeStateVector(),
+		CreatedAt: rows[len(rows)-1].CreatedAt, // Keeps its place among later updates
+	}
+	if err := sm.yjsRepo.ReplaceWithSnapshot(ctx, documentID, ids, snapshot); err != nil {
+		middleware.AddSpanError(ctx, err)
+		return err
+	}
+
+	span.SetAttributes(
+		attribute.Int("compaction.updates", len(rows)),
+		attribute.Int("compaction.bytes_before", before),
+		attribute.Int("compaction.bytes_after", len(snapshot.Update)),
+	)
+
+	// Rows stored meanwhile were counted by storeUpdate and are still there
+	r.mu.Lock()
+	r.logRows -= len(rows) - 1
+	r.logBytes -= before - len(snapshot.Update)
+	r.mu.Unlock()
+
+	log.Printf("✓ Compacted %d Yjs updates of document %s (%d → %d bytes)",
+		len(rows), documentID, before, len(snapshot.Update))
+	return nil
+}
@@ -0,0 +1,82 @@
+package collaboration
+
+import (
+	"fmt"
+
+	"ai-kms/internal/models"
+	"ai-kms/internal/yjs"
+)
+
+/*
+LEARNING: THE Y-WEBSOCKET PROTOCOL
+
+Every binary frame starts with a varint message type; sync messages add a
+second varint for the step:
+
+  [0 sync]      [0 step 1] [state vector]   "here's what I have"
+                [1 step 2] [update]         "here's what you were missing"
+                [2 update] [update]         "I just edited"
+  [1 awareness] [awareness update]          cursors, names - never stored
+  [3 query awareness]                       "who's here?"
+
+Connecting is a handshake in both directions: the server sends its state
+vector (step 1), the client answers with what the server lacks (step 2) and
+sends its own state vector, which the server answers the same way. After
+that only updates flow, so a reconnecting client downloads a diff instead
+of the document's whole history.
+*/
+
+// message is a decoded y-websocket frame
+type message struct {
+	Type    models.MessageType
+	Step    models.SyncStep // Sync messages only
+	Payload []byte          // State vector, update or awareness update
+}
+
+// decodeMessage parses a y-websocket frame
+func decodeMessage(frame []byte) (*message, error) {
+	d := yjs.NewDecoder(frame)
+	t, err := d.ReadVarUint()
+	if err != nil {
+		return nil, err
+	}
+	msg := &message{Type: models.MessageType(t)}
+
+	switch msg.Type {
+	case models.MessageTypeSync:
+		step, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		msg.Step = models.SyncStep(step)
+		if msg.Step > models.SyncUpdate {
+			return nil, fmt.Errorf("unknown sync step %d", step)
+		}
+		if msg.Payload, err = d.ReadVarBytes(); err != nil {
+			return nil, err
+		}
+	case models.MessageTypeAwareness:
+		if msg.Payload, err = d.ReadVarBytes(); err != nil {
+			return nil, err
+		}
+	case models.MessageTypeAuth, models.MessageTypeQueryAwareness:
+		// Nothing the server needs
+	default:
+		return nil, fmt.Errorf("unknown message type %d", t)
+	}
+	return msg, nil
+}
+
+// encodeSyncMessage frames a state vector or update as a sync message
+func encodeSyncMessage(step models.SyncStep, payload []byte) []byte {
+	e := &yjs.Encoder{}
+	e.WriteVarUint(uint64(models.MessageTypeSync))
+	e.WriteVarUint(uint64(step))
+	e.WriteVarBytes(payload)
+	return e.Bytes()
+}
+
+// isUpdate reports whether a message carries document changes
+func (m *message) isUpdate() bool {
+	return m.Type == models.MessageTypeSync && (m.Step == models.SyncStep2 || m.Step == models.SyncUpdate)
+}
@@ -0,0 +1,78 @@
+package collaboration
+
+import (
+	"bytes"
+	"testing"
+
+	"ai-kms/internal/models"
+	"ai-kms/internal/yjs"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   []byte
+		want    message
+		wantErr bool
+	}{
+		// What y-websocket sends first: sync step 1 with an empty document's state vector
+		{"sync step 1", []byte{0, 0, 1, 0}, message{Type: models.MessageTypeSync, Step: models.SyncStep1, Payload: []byte{0}}, false},
+		{"sync step 2", []byte{0, 1, 2, 0, 0}, message{Type: models.MessageTypeSync, Step: models.SyncStep2, Payload: []byte{0, 0}}, false},
+		{"update", []byte{0, 2, 2, 0, 0}, message{Type: models.MessageTypeSync, Step: models.SyncUpdate, Payload: []byte{0, 0}}, false},
+		{"awareness", []byte{1, 1, 0}, message{Type: models.MessageTypeAwareness, Payload: []byte{0}}, false},
+		{"query awareness", []byte{3}, message{Type: models.MessageTypeQueryAwareness}, false},
+		{"unknown step", []byte{0, 3, 0}, message{}, true},
+		{"unknown type", []byte{9}, message{}, true},
+		{"truncated payload", []byte{0, 2, 5, 0}, message{}, true},
+		{"empty", nil, message{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeMessage(tt.frame)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodeMessage = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Type != tt.want.Type || got.Step != tt.want.Step || !bytes.Equal(got.Payload, tt.want.Payload) {
+				t.Errorf("decodeMessage = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSyncHandshake answers a client's step 1 the way a room does, and
+// checks the client ends up with the server's document
+func TestSyncHandshake(t *testing.T) {
+	server := yjs.NewDoc()
+	if _, err := server.ReplaceText(ContentField, 1, "Intro\n\nSteps"); err != nil {
+		t.Fatal(err)
+	}
+	client := yjs.NewDoc()
+
+	step1, err := decodeMessage(encodeSyncMessage(models.SyncStep1, client.EncodeStateVector()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sv, err := yjs.DecodeStateVector(step1.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step2, err := decodeMessage(encodeSyncMessage(models.SyncStep2, server.EncodeStateAsUpdate(sv)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !step2.isUpdate() {
+		t.Fatal("step 2 isn't an update")
+	}
+	if err := client.ApplyUpdate(step2.Payload); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := client.Text(ContentField), server.Text(ContentField); got != want {
+		t.Errorf("client Text = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,466 @@
+package collaboration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ai-kms/internal/middleware"
+	"ai-kms/internal/models"
+	"ai-kms/internal/services"
+	"ai-kms/internal/yjs"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+LEARNING: SERVER-SIDE DOCUMENT STATE
+
+Relaying updates between browsers is enough for the editors to converge,
+but search and RAG read documents.content - which collaborative edits never
+touched. So the server keeps its own replica of each open document:
+
+  client update → apply to the room's yjs.Doc → store → broadcast
+                        │
+      every COLLAB_SYNC_INTERVAL, if it changed:
+                        ▼
+        render as text → documents.content → re-embed
+
+Writing back on a timer rather than per keystroke turns a burst of typing
+into one update and one embedding job. Rooms are loaded from yjs_updates on
+first use and dropped once the last user has left and the text is written.
+The same loop compacts update logs that grew past their limits.
+
+documents.content and the update log must not drift apart, or one silently
+overwrites the other:
+
+  - a document without Yjs history is seeded from its content, so editors
+    start from the text instead of replacing it with their first keystroke
+  - content saved outside the editor (SetContent) goes into the log as a
+    server edit, so open editors see it and the next write-back keeps it
+  - a write-back only replaces the content the room was last in step with;
+    if it changed anyway, the room takes the new content instead
+*/
+
+// ContentField is the root type holding the editor's content
+// Learning: Tiptap's Collaboration extension uses the XML fragment "default"
+const ContentField = "default"
+
+// DocumentRepository defines what content write-back needs from document storage
+type DocumentRepository interface {
+	GetByID(ctx context.Context, id string) (*models.Document, error)
+	ReplaceContent(ctx context.Context, id, previousHash, content string) (bool, error)
+}
+
+// EmbeddingSubmitter queues re-embedding of documents whose text changed
+type EmbeddingSubmitter interface {
+	SubmitJob(job services.EmbeddingJob) (*models.EmbeddingJob, error)
+}
+
+// room is the server's replica of one document's Yjs state
+type room struct {
+	mu          sync.Mutex
+	doc         *yjs.Doc
+	loaded      bool
+	dropped     bool   // Removed from sm.rooms; whoever still holds it must look up the room again
+	written     uint64 // doc.Changes() at the last write-back
+	contentHash string // documents.content_hash the replica was last in step with
+
+	// Size of the document's stored update log, for compaction
+	logRows      int
+	logBytes     int
+	compactError bool // Set when compaction failed; retried once the room is reloaded
+
+	// Version history
+	baselined   bool            // Pre-session state is safe in version history
+	unversioned bool            // Content written back since the last version
+	versionedAt time.Time       // Last version (or load)
+	editors     map[string]bool // Users whose updates went into unversioned content
+}
+
+// SetContentSync enables writing collaborative edits back to documents.content
+// Call before Start
+func (sm *SessionManager) SetContentSync(docRepo DocumentRepository, embedder EmbeddingSubmitter, interval time.Duration) {
+	sm.docRepo = docRepo
+	sm.embedder = embedder
+	sm.syncInterval = interval
+}
+
+// withRoom runs fn with the document's room locked, loading its history first if needed
+func (sm *SessionManager) withRoom(ctx context.Context, documentID string, fn func(r *room) error) error {
+	var r *room
+	for {
+		sm.roomsMu.Lock()
+		var ok bool
+		r, ok = sm.rooms[documentID]
+		if !ok {
+			r = &room{doc: yjs.NewDoc()}
+			sm.rooms[documentID] = r
+		}
+		sm.roomsMu.Unlock()
+
+		r.mu.Lock()
+		if !r.dropped {
+			break
+		}
+		r.mu.Unlock() // Dropped after we found it - changes must go to its successor
+	}
+	defer r.mu.Unlock()
+
+	if !r.loaded {
+		if err := sm.loadRoom(ctx, documentID, r); err != nil {
+			return err
+		}
+		r.loaded = true
+	}
+	return fn(r)
+}
+
+// loadRoom replays the stored updates of a document into a fresh replica,
+// seeding it from the document's content if there are none
+func (sm *SessionManager) loadRoom(ctx context.Context, documentID string, r *room) error {
+	r.doc = yjs.NewDoc()
+	r.logRows, r.logBytes = 0, 0
+	r.versionedAt = time.Now()
+
+	if sm.yjsRepo != nil {
+		updates, err := sm.yjsRepo.GetAllUpdates(ctx, documentID)
+		if err != nil {
+			return err
+		}
+		r.logRows = len(updates)
+		for _, u := range updates {
+			r.logBytes += len(u.Update)
+
+			msg, err := decodeMessage(u.Update)
+			if err != nil || !msg.isUpdate() {
+				continue // Rows stored before only updates were persisted
+			}
+			if err := r.doc.ApplyUpdate(msg.Payload); err != nil {
+				if errors.Is(err, yjs.ErrBroken) {
+					return fmt.Errorf("failed to replay Yjs update %s: %w", u.ID, err)
+				}
+				log.Printf("⚠️  Skipping unreadable Yjs update %s of document %s: %v", u.ID, documentID, err)
+			}
+		}
+	}
+
+	// What was stored is already reflected in documents.content, or will be
+	// by the first write-back with new changes
+	r.written = r.doc.Changes()
+
+	if sm.docRepo == nil {
+		return nil // Content sync not configured
+	}
+	doc, err := sm.docRepo.GetByID(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	r.contentHash = doc.ContentHash
+	if r.logRows == 0 {
+		// Nobody has to see the seed: editors get it with the rest of the state
+		if _, err := sm.replaceContent(ctx, documentID, r, doc.Content); err != nil {
+			return fmt.Errorf("failed to seed Yjs state from content: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetContent brings a document's collaborative state in line with content
+// saved outside the editor: open editors receive it as an edit, and the
+// next write-back keeps it instead of restoring the editors' version
+// Documents without Yjs history are left alone - they're seeded when opened
+func (sm *SessionManager) SetContent(ctx context.Context, documentID, content string) error {
+	sm.roomsMu.Lock()
+	_, open := sm.rooms[documentID]
+	sm.roomsMu.Unlock()
+	if !open {
+		if sm.yjsRepo == nil {
+			return nil
+		}
+		latest, err := sm.yjsRepo.GetLatestUpdate(ctx, documentID)
+		if err != nil || latest == nil {
+			return err
+		}
+	}
+
+	var frame []byte
+	err := sm.withRoom(ctx, documentID, func(r *room) error {
+		var err error
+		frame, err = sm.replaceContent(ctx, documentID, r, content)
+		if err != nil {
+			return err
+		}
+		r.contentHash = models.HashContent(content)
+		return nil
+	})
+	if err != nil || frame == nil {
+		return err
+	}
+	sm.Broadcast(documentID, frame, nil)
+	return nil
+}
+
+// replaceContent edits a room's replica so it renders as content, and
+// appends the edit to the update log; r.mu must be held
+// Returns the update frame for open editors, or nil if nothing changed
+func (sm *SessionManager) replaceContent(ctx context.Context, documentID string, r *room, content string) ([]byte, error) {
+	update, err := r.doc.ReplaceText(ContentField, sm.clientID, content)
+	if err != nil || update == nil {
+		return nil, err
+	}
+
+	frame := encodeSyncMessage(models.SyncUpdate, update)
+	if sm.yjsRepo != nil {
+		if err := sm.yjsRepo.StoreUpdate(ctx, documentID, frame, int(sm.clientID)); err != nil {
+			r.loaded = false // The replica is ahead of the log - rebuild it
+			return nil, err
+		}
+		r.logRows++
+		r.logBytes += len(frame)
+	}
+
+	// The replica now says what documents.content says
+	r.written = r.doc.Changes()
+	return frame, nil
+}
+
+// applyUpdate applies an editor's update to the room's replica
+// Returns false for updates that add nothing the server didn't already have
+func (sm *SessionManager) applyUpdate(ctx context.Context, documentID, editor string, update []byte) (bool, error) {
+	changed := false
+	err := sm.withRoom(ctx, documentID, func(r *room) error {
+		before := r.doc.Changes()
+		if err := r.doc.ApplyUpdate(update); err != nil {
+			if errors.Is(err, yjs.ErrBroken) {
+				// The update isn't stored, so replaying the log rebuilds the
+				// replica as it was before it
+				r.loaded = false
+			}
+			return err
+		}
+		// Learning: Pending changes count - they'll apply once their
+		// dependencies arrive, and must survive a restart until then
+		changed = r.doc.Changes() != before || r.doc.HasPending()
+		if changed {
+			if r.editors == nil {
+				r.editors = make(map[string]bool)
+			}
+			r.editors[editor] = true
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// storeUpdate appends an update frame to the document's log
+func (sm *SessionManager) storeUpdate(ctx context.Context, documentID string, clientID int, frame []byte) error {
+	if sm.yjsRepo == nil {
+		return nil
+	}
+	if err := sm.yjsRepo.StoreUpdate(ctx, documentID, frame, clientID); err != nil {
+		return err
+	}
+	return sm.withRoom(ctx, documentID, func(r *room) error {
+		r.logRows++
+		r.logBytes += len(frame)
+		return nil
+	})
+}
+
+// stateVector returns the encoded state vector of a document, for sync step 1
+func (sm *SessionManager) stateVector(ctx context.Context, documentID string) ([]byte, error) {
+	var sv []byte
+	err := sm.withRoom(ctx, documentID, func(r *room) error {
+		sv = r.doc.EncodeStateVector()
+		return nil
+	})
+	return sv, err
+}
+
+// diffUpdate encodes what the holder of an encoded state vector is missing,
+// for sync step 2
+func (sm *SessionManager) diffUpdate(ctx context.Context, documentID string, stateVector []byte) ([]byte, error) {
+	sv, err := yjs.DecodeStateVector(stateVector)
+	if err != nil {
+		return nil, err
+	}
+
+	var update []byte
+	err = sm.withRoom(ctx, documentID, func(r *room) error {
+		update = r.doc.EncodeStateAsUpdate(sv)
+		return nil
+	})
+	return update, err
+}
+
+// syncLoop periodically writes changed rooms back to their documents
+func (sm *SessionManager) syncLoop() {
+	ticker := time.NewTicker(sm.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.done:
+			return
+		case <-ticker.C:
+			sm.flushRooms(context.Background(), false)
+		}
+	}
+}
+
+// flushRooms writes back every changed room and drops rooms nobody is editing
+// final treats every room as left by its last user (shutdown)
+func (sm *SessionManager) flushRooms(ctx context.Context, final bool) {
+	sm.roomsMu.Lock()
+	rooms := make(map[string]*room, len(sm.rooms))
+	for id, r := range sm.rooms {
+		rooms[id] = r
+	}
+	sm.roomsMu.Unlock()
+
+	for documentID, r := range rooms {
+		sm.mu.RLock()
+		idle := final || len(sm.documents[documentID]) == 0
+		sm.mu.RUnlock()
+
+		if err := sm.writeBack(ctx, documentID, r); err != nil {
+			log.Printf("⚠️  Failed to write collaborative edits to document %s: %v", documentID, err)
+			continue
+		}
+		if err := sm.recordVersion(ctx, documentID, r, idle); err != nil {
+			log.Printf("⚠️  Failed to record a version of document %s: %v", documentID, err)
+			continue // Keep the room, so the next round retries
+		}
+		if sm.needsCompaction(r) {
+			if err := sm.compact(ctx, documentID, r); err != nil {
+				log.Printf("⚠️  Failed to compact Yjs updates of document %s: %v", documentID, err)
+				r.mu.Lock()
+				r.compactError = true
+				r.mu.Unlock()
+			}
+		}
+
+		if idle {
+			sm.dropRoom(documentID, r, final)
+		}
+	}
+}
+
+// dropRoom removes a room found idle, unless a client joined or changed it
+// while it was being written back
+// Learning: The idle check and the write-back happen without roomsMu, so
+// they're repeated here under it. A room that is dropped is marked, so a
+// withRoom that found it just before applies its change to a fresh one
+func (sm *SessionManager) dropRoom(documentID string, r *room, final bool) {
+	sm.roomsMu.Lock()
+	defer sm.roomsMu.Unlock()
+
+	sm.mu.RLock()
+	joined := len(sm.documents[documentID]) > 0
+	sm.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !final && (joined || r.doc.Changes() != r.written) {
+		return // Still in use - the next round looks again
+	}
+	if sm.rooms[documentID] == r {
+		delete(sm.rooms, documentID)
+	}
+	r.dropped = true
+}
+
+// writeBack renders a changed room as text and stores it as the document's content
+func (sm *SessionManager) writeBack(ctx context.Context, documentID string, r *room) error {
+	if sm.docRepo == nil {
+		return nil // Content sync not configured
+	}
+
+	r.mu.Lock()
+	changes := r.doc.Changes()
+	if !r.loaded || changes == r.written {
+		r.mu.Unlock()
+		return nil
+	}
+	text := r.doc.Text(ContentField)
+	base := r.contentHash
+	r.mu.Unlock()
+
+	ctx, span := middleware.StartSpan(ctx, "Collaboration.WriteBack",
+		attribute.String("document.id", documentID),
+		attribute.Int("content.length", len(text)),
+	)
+	defer span.End()
+
+	doc, err := sm.docRepo.GetByID(ctx, documentID)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return err
+	}
+
+	switch {
+	case doc.ContentHash != base:
+		// Learning: Saved outside the editor without going through
+		// SetContent (another server, a failed sync). Writing now would undo
+		// that save - the room takes it instead, like any other edit
+		middleware.AddSpanEvent(ctx, "content_changed_outside_room")
+		log.Printf("⚠️  Document %s changed outside the editor; updating its room instead of writing back", documentID)
+		return sm.catchUp(ctx, documentID, r, doc)
+	case models.HashContent(text) == doc.ContentHash:
+		// Edits that cancel out (or only touch formatting we don't render)
+	default:
+		if err := sm.ensureBaseline(ctx, doc, r); err != nil {
+			middleware.AddSpanError(ctx, err)
+			return err
+		}
+		replaced, err := sm.docRepo.ReplaceContent(ctx, documentID, base, text)
+		if err != nil {
+			middleware.AddSpanError(ctx, err)
+			return err
+		}
+		if !replaced {
+			// Saved since we looked - the next round catches up with it
+			middleware.AddSpanEvent(ctx, "content_changed_outside_room")
+			return nil
+		}
+		r.mu.Lock()
+		r.contentHash = models.HashContent(text)
+		r.unversioned = true
+		r.mu.Unlock()
+		if _, err := sm.embedder.SubmitJob(services.EmbeddingJob{DocumentID: documentID}); err != nil {
+			middleware.AddSpanError(ctx, err) // The text is saved; the next edit retries embedding
+		}
+		log.Printf("  Wrote collaborative edits back to document %s (%d chars)", documentID, len(text))
+	}
+
+	r.mu.Lock()
+	r.written = changes
+	r.mu.Unlock()
+	return nil
+}
+
+// catchUp brings content saved behind a room's back into the room
+func (sm *SessionManager) catchUp(ctx context.Context, documentID string, r *room, doc *models.Document) error {
+	r.mu.Lock()
+	if !r.loaded {
+		r.mu.Unlock()
+		return nil // Reloading reads the new content anyway
+	}
+	frame, err := sm.replaceContent(ctx, documentID, r, doc.Content)
+	if err == nil {
+		r.contentHash = doc.ContentHash
+	}
+	r.mu.Unlock()
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return err
+	}
+	if frame != nil {
+		sm.Broadcast(documentID, frame, nil)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package collaboration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ai-kms/internal/models"
+)
+
+// TestDropRoomRechecks keeps a room that a client joined or edited after
+// flushRooms found it idle, and sends changes made to a dropped room's
+// holders to a fresh room
+func TestDropRoomRechecks(t *testing.T) {
+	ctx := context.Background()
+	sm := NewSessionManager()
+
+	var held *room
+	if err := sm.withRoom(ctx, "doc", func(r *room) error { held = r; return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client joined after the idle check
+	session := &Session{Session: &models.Session{ID: "s", DocumentID: "doc", LastActiveAt: time.Now()}}
+	sm.handleRegister(session)
+	sm.dropRoom("doc", held, false)
+	if sm.rooms["doc"] != held || held.dropped {
+		t.Fatal("room dropped with a client in it")
+	}
+	sm.documents = make(map[string]map[*Session]bool)
+
+	// Edited after the write-back
+	if _, err := held.doc.ReplaceText(ContentField, 1, "typed"); err != nil {
+		t.Fatal(err)
+	}
+	sm.dropRoom("doc", held, false)
+	if sm.rooms["doc"] != held {
+		t.Fatal("room dropped with changes that weren't written back")
+	}
+
+	// Written back and idle: dropped, and whoever still holds it moves on
+	held.written = held.doc.Changes()
+	sm.dropRoom("doc", held, false)
+	if _, ok := sm.rooms["doc"]; ok || !held.dropped {
+		t.Fatal("idle room wasn't dropped")
+	}
+	var next *room
+	if err := sm.withRoom(ctx, "doc", func(r *room) error { next = r; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if next == held {
+		t.Error("withRoom returned the dropped room")
+	}
+}
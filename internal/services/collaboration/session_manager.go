@@ -2,8 +2,8 @@ package collaboration
 
 import (
 	"context"
-	"encoding/json"
 	"log"
+	"math/rand/v2"
 	"sync"
 	"time"
 
@@ -46,6 +46,22 @@ type SessionManager struct {
 	// Yjs repository for persistence
 	yjsRepo *repository.YjsRepositoryImpl
 
+	// Server-side document state, written back to documents.content
+	rooms        map[string]*room // documentID -> merged Yjs document
+	roomsMu      sync.Mutex
+	clientID     uint64 // Yjs client ID of the server's own edits
+	docRepo      DocumentRepository
+	embedder     EmbeddingSubmitter
+	syncInterval time.Duration
+
+	// Update log compaction thresholds
+	compactUpdates int
+	compactBytes   int
+
+	// Version history of collaborative edits
+	versions        VersionRecorder
+	versionInterval time.Duration
+
 	// Control
 	done chan struct{}
 }
@@ -65,17 +81,21 @@ type BroadcastMessage struct {
 	DocumentID string
 	Message    []byte
 	Sender     *Session // Skip this session when broadcasting
+	Recipient  *Session // Only send to this session (replies to one client)
 }
 
 // NewSessionManager creates a new session manager
 func NewSessionManager() *SessionManager {
 	return &SessionManager{
-		documents:  make(map[string]map[*Session]bool),
-		register:   make(chan *Session),
-		unregister: make(chan *Session),
-		broadcast:  make(chan *BroadcastMessage, 256),
-		awareness:  make(map[string]map[int]*models.AwarenessState),
-		done:       make(chan struct{}),
+		documents:    make(map[string]map[*Session]bool),
+		register:     make(chan *Session),
+		unregister:   make(chan *Session),
+		broadcast:    make(chan *BroadcastMessage, 256),
+		awareness:    make(map[string]map[int]*models.AwarenessState),
+		rooms:        make(map[string]*room),
+		clientID:     uint64(rand.Uint32()), // Random, like a Yjs client's
+		syncInterval: 10 * time.Second,
+		done:         make(chan struct{}),
 	}
 }
 
@@ -111,6 +131,12 @@ func (sm *SessionManager) Start() {
 	// Start cleanup goroutine
 	go sm.cleanupLoop()
 
+	// Write collaborative edits back to documents and drop idle rooms
+	go sm.syncLoop()
+
+	// Expire presence of clients that stopped renewing it
+	go sm.awarenessLoop()
+
 	log.Println("✓ WebSocket session manager started")
 }
 
@@ -126,23 +152,10 @@ func (sm *SessionManager) handleRegister(session *Session) {
 
 	sm.documents[session.DocumentID][session] = true
 
+	// Learning: No JSON join message - y-websocket clients only understand
+	// binary protocol frames; other users see newcomers through awareness
 	log.Printf("  Session %s joined document %s (total: %d users)",
 		session.ID, session.DocumentID, len(sm.documents[session.DocumentID]))
-
-	// Send join notification to other users
-	joinMsg, _ := json.Marshal(map[string]interface{}{
-		"type": models.MessageTypeJoin,
-		"user": map[string]string{
-			"id":   session.UserID,
-			"name": session.UserName,
-		},
-	})
-
-	sm.broadcast <- &BroadcastMessage{
-		DocumentID: session.DocumentID,
-		Message:    joinMsg,
-		Sender:     session, // Don't send to self
-	}
 }
 
 // handleUnregister removes a session from a document room
@@ -150,6 +163,12 @@ func (sm *SessionManager) handleUnregister(session *Session) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
+	sm.removeSession(session)
+}
+
+// removeSession closes a session's Send channel and removes it from its room
+// Call with sm.mu held, from the event loop - the only place Send is closed
+func (sm *SessionManager) removeSession(session *Session) {
 	if sessions, ok := sm.documents[session.DocumentID]; ok {
 		if _, ok := sessions[session]; ok {
 			delete(sessions, session)
@@ -162,43 +181,25 @@ func (sm *SessionManager) handleUnregister(session *Session) {
 
 			log.Printf("  Session %s left document %s (remaining: %d users)",
 				session.ID, session.DocumentID, len(sessions))
-
-			// Remove from awareness
-			sm.awareMu.Lock()
-			if aware, exists := sm.awareness[session.DocumentID]; exists {
-				delete(aware, session.ClientID)
-			}
-			sm.awareMu.Unlock()
-
-			// Send leave notification
-			leaveMsg, _ := json.Marshal(map[string]interface{}{
-				"type": models.MessageTypeLeave,
-				"user": map[string]string{
-					"id":   session.UserID,
-					"name": session.UserName,
-				},
-			})
-
-			sm.broadcast <- &BroadcastMessage{
-				DocumentID: session.DocumentID,
-				Message:    leaveMsg,
-				Sender:     nil, // Send to everyone
-			}
 		}
 	}
 }
 
 // handleBroadcast sends a message to all sessions in a document
+// Learning: Sessions too slow to keep up are removed right here. Sending
+// them to unregister instead would block: this loop is its only reader
 func (sm *SessionManager) handleBroadcast(msg *BroadcastMessage) {
-	sm.mu.RLock()
-	sessions := sm.documents[msg.DocumentID]
-	sm.mu.RUnlock()
+	var slow []*Session
 
-	for session := range sessions {
+	sm.mu.RLock()
+	for session := range sm.documents[msg.DocumentID] {
 		// Skip sender if specified
 		if msg.Sender != nil && session == msg.Sender {
 			continue
 		}
+		if msg.Recipient != nil && session != msg.Recipient {
+			continue
+		}
 
 		select {
 		case session.Send <- msg.Message:
@@ -206,9 +207,19 @@ func (sm *SessionManager) handleBroadcast(msg *BroadcastMessage) {
 		default:
 			// Buffer full - connection is slow/dead
 			log.Printf("⚠️  Session %s buffer full, closing connection", session.ID)
-			sm.unregister <- session
+			slow = append(slow, session)
 		}
 	}
+	sm.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, session := range slow {
+		sm.removeSession(session) // Closing Send makes WritePump close the connection
+	}
 }
 
 // Broadcast sends a message to all users in a document
@@ -220,6 +231,17 @@ func (sm *SessionManager) Broadcast(documentID string, message []byte, sender *S
 	}
 }
 
+// Send queues a message for one session
+// Learning: Goes through the event loop like broadcasts - the loop also
+// closes Send when a session leaves, so nothing is queued after closing
+func (sm *SessionManager) Send(session *Session, message []byte) {
+	sm.broadcast <- &BroadcastMessage{
+		DocumentID: session.DocumentID,
+		Message:    message,
+		Recipient:  session,
+	}
+}
+
 // GetSessions returns all active sessions for a document
 func (sm *SessionManager) GetSessions(documentID string) []*Session {
 	sm.mu.RLock()
@@ -235,26 +257,6 @@ func (sm *SessionManager) GetSessions(documentID string) []*Session {
 	return result
 }
 
-// UpdateAwareness updates user presence state
-func (sm *SessionManager) UpdateAwareness(documentID string, clientID int, state *models.AwarenessState) {
-	sm.awareMu.Lock()
-	defer sm.awareMu.Unlock()
-
-	if sm.awareness[documentID] == nil {
-		sm.awareness[documentID] = make(map[int]*models.AwarenessState)
-	}
-
-	sm.awareness[documentID][clientID] = state
-}
-
-// GetAwareness returns all awareness states for a document
-func (sm *SessionManager) GetAwareness(documentID string) map[int]*models.AwarenessState {
-	sm.awareMu.RLock()
-	defer sm.awareMu.RUnlock()
-
-	return sm.awareness[documentID]
-}
-
 // cleanupLoop periodically removes inactive sessions
 func (sm *SessionManager) cleanupLoop() {
 	ticker := time.NewTicker(30 * time.Second)
@@ -271,18 +273,18 @@ func (sm *SessionManager) cleanupLoop() {
 }
 
 // cleanup removes stale sessions
+// Learning: They are collected under the lock but handed to the event loop
+// after releasing it - the loop needs the lock to remove them
 func (sm *SessionManager) cleanup() {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
-
 	now := time.Now()
 	timeout := 5 * time.Minute
 
+	var stale []*Session
+	sm.mu.Lock()
 	for docID, sessions := range sm.documents {
 		for session := range sessions {
 			if now.Sub(session.LastActiveAt) > timeout {
-				log.Printf("  Cleaning up inactive session %s", session.ID)
-				sm.unregister <- session
+				stale = append(stale, session)
 			}
 		}
 
@@ -291,6 +293,16 @@ func (sm *SessionManager) cleanup() {
 			delete(sm.documents, docID)
 		}
 	}
+	sm.mu.Unlock()
+
+	for _, session := range stale {
+		log.Printf("  Cleaning up inactive session %s", session.ID)
+		select {
+		case sm.unregister <- session:
+		case <-sm.done:
+			return
+		}
+	}
 }
 
 // Shutdown gracefully closes all connections
@@ -299,6 +311,11 @@ func (sm *SessionManager) Shutdown() {
 
 	close(sm.done)
 
+	// Don't lose edits made since the last write-back
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	sm.flushRooms(ctx, true)
+	cancel()
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -320,6 +337,9 @@ func (sm *SessionManager) Shutdown() {
 // Learning: Each session has its own goroutine reading from the WebSocket
 func (s *Session) ReadPump(ctx context.Context) {
 	defer func() {
+		// Learning: Whichever way the connection ended, nobody else should
+		// keep seeing this user's cursor until the awareness timeout
+		s.Manager.removeAwareness(s)
 		s.Manager.unregister <- s
 		s.Conn.Close()
 	}()
@@ -350,20 +370,68 @@ func (s *Session) ReadPump(ctx context.Context) {
 			attribute.Int("message.size", len(message)),
 		)
 
-		// Store Yjs update if we have repository
-		// Learning: Persist CRDT updates for sync and recovery
-		if s.Manager.yjsRepo != nil {
-			if err := s.Manager.yjsRepo.StoreUpdate(msgCtx, s.DocumentID, message, s.ClientID); err != nil {
-				log.Printf("Failed to store Yjs update: %v", err)
-				middleware.AddSpanError(msgCtx, err)
-			}
+		msg, err := decodeMessage(message)
+		if err != nil {
+			log.Printf("⚠️  Ignoring malformed message from session %s: %v", s.ID, err)
+			middleware.AddSpanError(msgCtx, err)
+			span.End()
+			continue
 		}
+		span.SetAttributes(attribute.Int("message.type", int(msg.Type)))
 
-		// Broadcast to other clients
-		s.Manager.Broadcast(s.DocumentID, message, s)
+		s.handleMessage(msgCtx, msg, message)
 
 		span.End()
-		_ = msgCtx // Context used for span
+	}
+}
+
+// handleMessage answers sync requests, stores and relays updates, and relays awareness
+func (s *Session) handleMessage(ctx context.Context, msg *message, frame []byte) {
+	sm := s.Manager
+
+	switch {
+	case msg.Type == models.MessageTypeSync && msg.Step == models.SyncStep1:
+		// The client's state vector: answer with only what it's missing
+		update, err := sm.diffUpdate(ctx, s.DocumentID, msg.Payload)
+		if err != nil {
+			log.Printf("⚠️  Failed to answer sync request of session %s: %v", s.ID, err)
+			middleware.AddSpanError(ctx, err)
+			return
+		}
+		sm.Send(s, encodeSyncMessage(models.SyncStep2, update))
+
+	case msg.isUpdate():
+		changed, err := sm.applyUpdate(ctx, s.DocumentID, s.UserName, msg.Payload)
+		if err != nil {
+			log.Printf("⚠️  Rejected Yjs update from session %s: %v", s.ID, err)
+			middleware.AddSpanError(ctx, err)
+			return
+		}
+		if !changed {
+			middleware.AddSpanEvent(ctx, "update_already_known")
+			return
+		}
+
+		// Learning: Stored and relayed as a plain update whichever step it
+		// arrived as - a step 2 only answers the sender's own request
+		update := encodeSyncMessage(models.SyncUpdate, msg.Payload)
+		if err := sm.storeUpdate(ctx, s.DocumentID, s.ClientID, update); err != nil {
+			log.Printf("Failed to store Yjs update: %v", err)
+			middleware.AddSpanError(ctx, err)
+		}
+		sm.Broadcast(s.DocumentID, update, s)
+
+	case msg.Type == models.MessageTypeAwareness:
+		// Ephemeral: relayed, remembered for newcomers and presence, never stored
+		if err := sm.applyAwareness(s, msg.Payload); err != nil {
+			log.Printf("⚠️  Ignoring malformed awareness from session %s: %v", s.ID, err)
+			middleware.AddSpanError(ctx, err)
+			return
+		}
+		sm.Broadcast(s.DocumentID, frame, s)
+
+	case msg.Type == models.MessageTypeQueryAwareness:
+		sm.sendAwareness(s)
 	}
 }
 
@@ -386,19 +454,9 @@ func (s *Session) WritePump(ctx context.Context) {
 				return
 			}
 
-			w, err := s.Conn.NextWriter(websocket.BinaryMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Batch additional queued messages
-			n := len(s.Send)
-			for i := 0; i < n; i++ {
-				w.Write(<-s.Send)
-			}
-
-			if err := w.Close(); err != nil {
+			// Learning: One protocol message per WebSocket message - frames
+			// aren't self-delimiting, so batching them would corrupt all but the first
+			if err := s.Conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
 				return
 			}
 
@@ -0,0 +1,89 @@
+package collaboration
+
+import (
+	"testing"
+	"time"
+
+	"ai-kms/internal/models"
+)
+
+// TestSlowSessionDoesntBlockTheLoop fills a session's buffer and checks the
+// event loop drops it and keeps serving everyone else
+func TestSlowSessionDoesntBlockTheLoop(t *testing.T) {
+	sm := NewSessionManager()
+	sm.Start()
+	defer close(sm.done)
+
+	newSession := func(id string, buffer int) *Session {
+		s := &Session{
+			Session: &models.Session{ID: id, DocumentID: "doc", LastActiveAt: time.Now()},
+			Send:    make(chan []byte, buffer),
+			Manager: sm,
+		}
+		sm.register <- s
+		return s
+	}
+	slow, fast := newSession("slow", 0), newSession("fast", 8)
+
+	done := make(chan struct{})
+	go func() {
+		sm.Broadcast("doc", []byte{1}, nil)
+		sm.Broadcast("doc", []byte{2}, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Broadcast blocked")
+	}
+
+	for _, want := range []byte{1, 2} {
+		select {
+		case got := <-fast.Send:
+			if got[0] != want {
+				t.Errorf("fast session got %v, want %v", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("fast session got nothing: the loop is stuck")
+		}
+	}
+	if _, open := <-slow.Send; open {
+		t.Error("slow session's Send is still open")
+	}
+	if sessions := sm.GetSessions("doc"); len(sessions) != 1 || sessions[0] != fast {
+		t.Errorf("sessions = %v, want only the fast one", sessions)
+	}
+}
+
+// TestCleanupDoesntHoldTheLock removes an inactive session while the event
+// loop is busy with a broadcast that needs the lock
+func TestCleanupDoesntHoldTheLock(t *testing.T) {
+	sm := NewSessionManager()
+	sm.Start()
+	defer close(sm.done)
+
+	stale := &Session{
+		Session: &models.Session{ID: "stale", DocumentID: "doc", LastActiveAt: time.Now().Add(-time.Hour)},
+		Send:    make(chan []byte, 8),
+		Manager: sm,
+	}
+	sm.register <- stale
+
+	done := make(chan struct{})
+	go func() {
+		sm.cleanup()
+		sm.Broadcast("doc", []byte{1}, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("cleanup blocked")
+	}
+	for deadline := time.Now().Add(2 * time.Second); len(sm.GetSessions("doc")) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("stale session wasn't removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
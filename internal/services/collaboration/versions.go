@@ -0,0 +1,86 @@
+package collaboration
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"ai-kms/internal/models"
+)
+
+// VersionRecorder defines what collaboration needs from version history
+type VersionRecorder interface {
+	EnsureBaseline(ctx context.Context, doc *models.Document) error
+	Record(ctx context.Context, doc *models.Document, author string, source models.VersionSource) (*models.DocumentVersion, error)
+}
+
+// SetVersioning records collaborative edits in version history: at most
+// once per interval while a document is being edited, and when its last
+// editor leaves
+// Call before Start
+func (sm *SessionManager) SetVersioning(versions VersionRecorder, interval time.Duration) {
+	sm.versions = versions
+	sm.versionInterval = interval
+}
+
+// ensureBaseline keeps the state a room's first write-back overwrites
+func (sm *SessionManager) ensureBaseline(ctx context.Context, doc *models.Document, r *room) error {
+	if sm.versions == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	done := r.baselined
+	r.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	if err := sm.versions.EnsureBaseline(ctx, doc); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.baselined = true
+	r.mu.Unlock()
+	return nil
+}
+
+// recordVersion snapshots a room's written-back content once the interval
+// has passed, or right away when nobody is editing anymore
+// Learning: Write-backs happen every few seconds; versions that often would
+// bury the history, so a version covers a few minutes of typing
+func (sm *SessionManager) recordVersion(ctx context.Context, documentID string, r *room, idle bool) error {
+	if sm.versions == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	due := r.unversioned && (idle || time.Since(r.versionedAt) >= sm.versionInterval)
+	editors := make([]string, 0, len(r.editors))
+	for name := range r.editors {
+		editors = append(editors, name)
+	}
+	r.mu.Unlock()
+	if !due {
+		return nil
+	}
+
+	doc, err := sm.docRepo.GetByID(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	sort.Strings(editors)
+	if _, err := sm.versions.Record(ctx, doc, strings.Join(editors, ", "), models.VersionSourceCollaboration); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.unversioned = false
+	r.versionedAt = time.Now()
+	for _, name := range editors {
+		delete(r.editors, name)
+	}
+	r.mu.Unlock()
+	return nil
+}
@@ -2,7 +2,6 @@ package collaboration
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"net/http"
 	"strconv"
@@ -99,8 +98,12 @@ func (h *WebSocketHandler) HandleDocumentConnection(w http.ResponseWriter, r *ht
 	// Register session
 	h.sessionManager.register <- session
 
-	// Send initial state to client
-	h.sendInitialState(session)
+	// Learning: The request context is canceled as soon as this handler
+	// returns, but the connection lives on - keep the trace, drop the cancel
+	ctx = context.WithoutCancel(ctx)
+
+	// Start the sync handshake
+	h.sendInitialState(ctx, session)
 
 	// Start read and write pumps in separate goroutines
 	// Learning: Separate goroutines prevent deadlock between reading and writing
@@ -111,41 +114,31 @@ func (h *WebSocketHandler) HandleDocumentConnection(w http.ResponseWriter, r *ht
 		documentID, userName, clientID)
 }
 
-// sendInitialState sends the current document state to a new client
-// Learning: For Yjs, we send all historical updates for CRDT reconstruction
-func (h *WebSocketHandler) sendInitialState(session *Session) {
-	// Get all Yjs updates for this document
-	if h.sessionManager.yjsRepo != nil {
-		updates, err := h.sessionManager.yjsRepo.GetAllUpdates(context.Background(), session.DocumentID)
-		if err != nil {
-			log.Printf("Failed to get Yjs updates: %v", err)
-			return
-		}
-
-		// Send each update to the client
-		// Learning: Client's Yjs doc will apply all updates to reconstruct state
-		for _, update := range updates {
-			select {
-			case session.Send <- update.Update:
-				// Sent successfully
-			default:
-				log.Printf("Failed to send initial update to session %s", session.ID)
-			}
-		}
-
-		log.Printf("Sent %d Yjs updates to session %s for initial sync", len(updates), session.ID)
+// sendInitialState starts the sync handshake with a new client
+// Learning: Instead of replaying the whole update history, the server sends
+// its state vector (sync step 1). The client answers with what the server
+// lacks and asks for what it lacks itself - each side downloads only a diff
+func (h *WebSocketHandler) sendInitialState(ctx context.Context, session *Session) {
+	sv, err := h.sessionManager.stateVector(ctx, session.DocumentID)
+	if err != nil {
+		log.Printf("Failed to load Yjs state of document %s: %v", session.DocumentID, err)
+		middleware.AddSpanError(ctx, err)
+		return
 	}
+	h.sessionManager.Send(session, encodeSyncMessage(models.SyncStep1, sv))
 
-	// Send awareness state for other users
-	if aware := session.Manager.GetAwareness(session.DocumentID); aware != nil {
-		awareData, err := json.Marshal(map[string]interface{}{
-			"type":      "awareness",
-			"awareness": aware,
-		})
-		if err == nil {
-			session.Send <- awareData
-		}
-	}
+	// Who else is here
+	h.sessionManager.sendAwareness(session)
+}
+
+// Presence returns the users in a document with their colors and cursors
+func (h *WebSocketHandler) Presence(documentID string) []*models.AwarenessState {
+	return h.sessionManager.Presence(documentID)
+}
+
+// SetContent passes content saved outside the editor on to the document's editors
+func (h *WebSocketHandler) SetContent(ctx context.Context, documentID, content string) error {
+	return h.sessionManager.SetContent(ctx, documentID, content)
 }
 
 // HandleUpdatesConnection handles global updates WebSocket
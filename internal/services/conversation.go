@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ai-kms/internal/middleware"
+	"ai-kms/internal/models"
+	"ai-kms/internal/openai"
+	"ai-kms/internal/prompts"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+LEARNING: CONVERSATIONAL RAG
+
+Follow-up questions rarely make sense on their own:
+
+  user: How do I restart the pgbouncer pod?
+  bot:  Run kubectl rollout restart deployment/pgbouncer ...
+  user: And what if it's stuck?          ← search for "it"? "stuck"?
+
+Embedding "And what if it's stuck?" finds nothing useful. So before
+retrieval, the model CONDENSES the history and the follow-up into one
+standalone question:
+
+  "What to do if the pgbouncer pod is stuck while restarting?"
+
+That query is used for search; the answer is then generated from the
+retrieved context plus the recent history, so it can still say "as above".
+
+History is trimmed from the oldest turn until it fits its share of the
+token budget - the newest turns matter most.
+*/
+
+// ErrConversationNotFound means no conversation has the given ID
+var ErrConversationNotFound = errors.New("conversation not found")
+
+const (
+	historyBudgetShare   = 3  // History may use up to 1/3 of the prompt budget
+	maxHistoryMessages   = 10 // Turns considered for history, before trimming to the budget
+	conversationTitleLen = 80 // Characters of the first question used as a title
+)
+
+// ConversationService answers questions in the context of a persisted conversation
+type ConversationService struct {
+	rag  *RAGService
+	repo ConversationRepository
+}
+
+// NewConversationService creates a conversation service on top of a RAG service
+func NewConversationService(rag *RAGService, repo ConversationRepository) *ConversationService {
+	return &ConversationService{rag: rag, repo: repo}
+}
+
+// ConversationReply is the outcome of one question in a conversation
+type ConversationReply struct {
+	Question *models.ConversationMessage
+	Answer   *models.ConversationMessage
+	RAG      *RAGAnswer // Context details (truncation, dropped chunks)
+}
+
+// Create starts a new conversation
+// An empty title is filled in from the first question
+func (s *ConversationService) Create(ctx context.Context, title string) (*models.Conversation, error) {
+	return s.repo.Create(ctx, strings.TrimSpace(title))
+}
+
+// Get returns a conversation with all its messages
+func (s *ConversationService) Get(ctx context.Context, id string) (*models.Conversation, error) {
+	conv, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if conv == nil {
+		return nil, ErrConversationNotFound
+	}
+	return conv, nil
+}
+
+// List returns conversations, most recently active first
+func (s *ConversationService) List(ctx context.Context, limit, offset int) ([]*models.Conversation, error) {
+	return s.repo.List(ctx, limit, offset)
+}
+
+// Delete removes a conversation and its messages
+func (s *ConversationService) Delete(ctx context.Context, id string) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, id)
+}
+
+// Reply answers a question using retrieved context and the conversation so far,
+// then stores both the question and the answer
+func (s *ConversationService) Reply(ctx context.Context, conversationID, question string, maxChunks int) (*ConversationReply, error) {
+	ctx, span := middleware.StartSpan(ctx, "Conversation.Reply",
+		attribute.String("conversation_id", conversationID),
+		attribute.Int("max_chunks", maxChunks),
+	)
+	defer span.End()
+
+	conv, err := s.Get(ctx, conversationID)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+
+	history := s.recentHistory(conv.Messages)
+	span.SetAttributes(attribute.Int("history_messages", len(history)))
+
+	// Step 1: Turn the follow-up into a standalone search query
+	retrievalQuery := question
+	if len(history) > 0 {
+		retrievalQuery = s.condenseQuestion(ctx, history, question)
+	}
+
+	// Step 2: Retrieve context, leaving room for the history
+	reserved := []string{question}
+	for _, msg := range history {
+		reserved = append(reserved, msg.Content)
+	}
+	packed, err := s.rag.retrieveContext(ctx, retrievalQuery, SearchFilters{}, maxChunks, reserved...)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+
+	// Step 3: Answer with system prompt, history, then the question with its context
+	answer := noContextAnswer
+	rag := &RAGAnswer{}
+	promptTokens, completionTokens := 0, 0
+	if packed != nil {
+		prompt, err := s.rag.ragMessages(ctx, question, packed.Text)
+		if err != nil {
+			middleware.AddSpanError(ctx, err)
+			return nil, err
+		}
+		messages := []openai.ChatMessage{prompt[0]}
+		messages = append(messages, history...)
+		messages = append(messages, prompt[1:]...)
+
+		answer, err = s.rag.chat.ChatCompletion(ctx, answerTask(question, packed), messages)
+		if err != nil {
+			middleware.AddSpanError(ctx, err)
+			return nil, fmt.Errorf("failed to get completion: %w", err)
+		}
+
+		for _, msg := range messages {
+			promptTokens += s.rag.prompts.Count(msg.Content)
+		}
+		completionTokens = s.rag.prompts.Count(answer)
+		rag = s.rag.completeAnswer(ctx, packed, answer)
+	}
+
+	// Learning: Both rows are inserted in one batch, and GORM stamps a batch
+	// with one time - the IDs can't break the tie either, since KSUIDs made
+	// in the same second are random. The answer is stamped a microsecond
+	// (Postgres' resolution) after its question
+	asked := time.Now()
+	userMsg := &models.ConversationMessage{Role: "user", Content: question, CreatedAt: asked}
+	assistantMsg := &models.ConversationMessage{
+		Role:             "assistant",
+		Content:          answer,
+		RetrievalQuery:   retrievalQuery,
+		Sources:          rag.Sources,
+		Citations:        rag.Citations,
+		Grounded:         rag.Grounded,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		CreatedAt:        asked.Add(time.Microsecond),
+	}
+	if err := s.repo.AddMessages(ctx, conv.ID, userMsg, assistantMsg); err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+
+	if conv.Title == "" {
+		// Naming is cosmetic - a failure here shouldn't fail the reply
+		if err := s.repo.SetTitle(ctx, conv.ID, conversationTitle(question)); err != nil {
+			middleware.AddSpanError(ctx, err)
+		}
+	}
+
+	rag.Answer = answer
+	return &ConversationReply{Question: userMsg, Answer: assistantMsg, RAG: rag}, nil
+}
+
+// recentHistory returns the latest messages that fit the history budget, oldest first
+func (s *ConversationService) recentHistory(messages []models.ConversationMessage) []openai.ChatMessage {
+	budget := s.rag.prompts.Budget() / historyBudgetShare
+
+	var history []openai.ChatMessage
+	used := 0
+	for i := len(messages) - 1; i >= 0 && len(history) < maxHistoryMessages; i-- {
+		content := messages[i].Content
+		if messages[i].Role == "assistant" {
+			// [n] markers pointed into that turn's context, not this one's
+			content = stripCitations(content)
+		}
+		tokens := s.rag.prompts.Count(content)
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		history = append(history, openai.ChatMessage{Role: messages[i].Role, Content: content})
+	}
+
+	// Collected newest first - restore chronological order
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
+
+// condenseQuestion rewrites a follow-up question as a standalone search query
+// Falls back to the question itself if the model can't help
+func (s *ConversationService) condenseQuestion(ctx context.Context, history []openai.ChatMessage, question string) string {
+	var transcript, said strings.Builder
+	for _, msg := range history {
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+		said.WriteString(msg.Content + "\n")
+	}
+
+	data := prompts.Condense{History: strings.TrimSpace(transcript.String()), Question: question}
+	system, err := s.rag.prompts.Render(ctx, prompts.CondenseSystem, data)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return question
+	}
+	prompt, err := s.rag.prompts.Render(ctx, prompts.CondenseUser, data)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return question
+	}
+
+	task := openai.ChatTask{Kind: openai.TaskCondense, Query: question, Text: said.String()}
+	standalone, err := s.rag.chat.ChatCompletion(ctx, task, []openai.ChatMessage{
+		{Role: "system", Content: system},
+		{Role: "user", Content: prompt},
+	})
+	standalone = strings.TrimSpace(standalone)
+	if err != nil || standalone == "" {
+		middleware.AddSpanEvent(ctx, "condense_failed")
+		return question
+	}
+
+	middleware.AddSpanEvent(ctx, "question_condensed",
+		attribute.String("standalone_query", standalone),
+	)
+	return standalone
+}
+
+// conversationTitle shortens a question to a title on a word boundary
+func conversationTitle(question string) string {
+	title := strings.Join(strings.Fields(question), " ")
+	if runes := []rune(title); len(runes) > conversationTitleLen {
+		title = string(runes[:conversationTitleLen])
+		if i := strings.LastIndex(title, " "); i > 0 {
+			title = title[:i]
+		}
+		title += "…"
+	}
+	return title
+}
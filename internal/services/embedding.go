@@ -2,11 +2,14 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
+	"ai-kms/internal/chunking"
 	"ai-kms/internal/models"
 	"ai-kms/internal/openai"
 
@@ -20,57 +23,92 @@ This service demonstrates a core Go concurrency pattern: the Worker Pool.
 
 Key Concepts:
 1. **Goroutines**: Lightweight threads managed by Go runtime
-2. **Channels**: Communication pipes between goroutines (thread-safe)
+2. **Durable Queue**: Jobs are rows in Postgres, claimed with FOR UPDATE SKIP LOCKED
 3. **Worker Pool**: Fixed number of workers processing jobs from a queue
 4. **Graceful Shutdown**: Using context and WaitGroup for cleanup
 
 Benefits:
 - Limits concurrent API calls to OpenAI (avoid rate limits)
 - Efficient resource usage (reuse workers vs spawning unlimited goroutines)
-- Backpressure handling (bounded queue prevents memory issues)
+- Jobs survive restarts, failures are retried with backoff and recorded
+
+Workers poll the table, but SubmitJob also "pokes" them through a channel
+so new jobs start immediately instead of waiting for the next poll.
 */
 
+const (
+	jobPollInterval = 5 * time.Second  // How often idle workers check for jobs
+	jobStaleAfter   = 10 * time.Minute // Running jobs older than this are assumed dead
+	jobRetryBase    = 10 * time.Second // First retry delay (doubles each attempt)
+	jobRetryMax     = 10 * time.Minute
+)
+
+// errStaleContent means a document was edited while its job was embedding it
+var errStaleContent = errors.New("document changed while it was being embedded")
+
 // EmbeddingJob represents a document that needs embeddings generated
+// Learning: Content isn't carried along - workers read the latest version
+// of the document when they run. Two jobs for the same document may still
+// overlap, so the content hash is checked again when the chunks are stored
 type EmbeddingJob struct {
 	DocumentID string
-	Content    string
 }
 
 // EmbeddingServiceImpl handles document embedding generation with a worker pool
 // This is the IMPLEMENTATION - the api package defines what interface it needs
 type EmbeddingServiceImpl struct {
-	openaiClient *openai.Client
-	embRepo      EmbeddingRepository  // Interface from this package (consumer-driven!)
-	docRepo      DocumentRepository   // Interface from this package
+	embedder Embedder            // OpenAI or local provider
+	embRepo  EmbeddingRepository // Interface from this package (consumer-driven!)
+	docRepo  DocumentRepository  // Interface from this package
+	jobRepo  JobRepository       // Persistent job queue
+
+	migration *migrationTarget // Model being filled in the background (nil when not migrating)
 
 	// Worker pool components
-	jobs    chan EmbeddingJob      // Buffered channel for job queue
-	workers int                    // Number of concurrent workers
-	wg      sync.WaitGroup         // Tracks active workers for graceful shutdown
-	ctx     context.Context        // Context for cancellation
-	cancel  context.CancelFunc     // Function to cancel all workers
+	wake        chan struct{}      // Nudges idle workers when a job is submitted
+	workers     int                // Number of concurrent workers
+	batchSize   int                // Chunks per embeddings request
+	maxAttempts int                // Attempts before a job is marked failed
+	chunkOpts   chunking.Options   // Token budget and overlap for chunkers
+	wg          sync.WaitGroup     // Tracks active workers for graceful shutdown
+	ctx         context.Context    // Context for cancellation
+	cancel      context.CancelFunc // Function to cancel all workers
 }
 
 // NewEmbeddingService creates a new embedding service with worker pool
 // Learning: This initializes the worker pool but doesn't start it yet
 // Returns concrete type - "Accept interfaces, return structs"
 func NewEmbeddingService(
-	openaiClient *openai.Client,
-	embRepo EmbeddingRepository,    // Accept interface (consumer defines this)
-	docRepo DocumentRepository,     // Accept interface
+	embedder Embedder, // Accept interface (OpenAI or local)
+	embRepo EmbeddingRepository, // Accept interface (consumer defines this)
+	docRepo DocumentRepository, // Accept interface
+	jobRepo JobRepository, // Accept interface
 	numWorkers int,
-	queueSize int,
+	batchSize int,
+	maxAttempts int,
+	chunkOpts chunking.Options,
 ) *EmbeddingServiceImpl {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
 	return &EmbeddingServiceImpl{
-		openaiClient: openaiClient,
-		embRepo:      embRepo,
-		docRepo:      docRepo,
-		jobs:         make(chan EmbeddingJob, queueSize), // Buffered channel
-		workers:      numWorkers,
-		ctx:          ctx,
-		cancel:       cancel,
+		embedder:    embedder,
+		embRepo:     embRepo,
+		docRepo:     docRepo,
+		jobRepo:     jobRepo,
+		wake:        make(chan struct{}, numWorkers),
+		workers:     numWorkers,
+		batchSize:   batchSize,
+		maxAttempts: maxAttempts,
+		chunkOpts:   chunkOpts,
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 }
 
@@ -79,6 +117,22 @@ func NewEmbeddingService(
 func (s *EmbeddingServiceImpl) Start() {
 	log.Printf("🔧 Starting embedding worker pool with %d workers", s.workers)
 
+	// Recover jobs left "running" by a previous process that crashed
+	if n, err := s.jobRepo.RequeueStale(s.ctx, jobStaleAfter); err != nil {
+		log.Printf("⚠️  Failed to requeue stale embedding jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("  Requeued %d stale embedding jobs", n)
+	}
+
+	// Resume (or start) filling the migration target
+	if s.migration != nil {
+		if n, err := s.StartMigration(s.ctx); err != nil {
+			log.Printf("⚠️  Failed to queue embedding migration: %v", err)
+		} else {
+			log.Printf("  Queued %d documents for migration to %s", n, s.migration.repo.Model())
+		}
+	}
+
 	for i := 0; i < s.workers; i++ {
 		s.wg.Add(1)
 		// Spawn a worker goroutine
@@ -90,120 +144,265 @@ func (s *EmbeddingServiceImpl) Start() {
 }
 
 // worker is the goroutine function that processes jobs
-// Learning: This runs in a loop, pulling jobs from the channel
+// Learning: This runs in a loop, claiming jobs from the database
 func (s *EmbeddingServiceImpl) worker(id int) {
 	defer s.wg.Done() // Decrement WaitGroup when worker exits
 
 	log.Printf("  Worker %d started", id)
 
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
 	for {
+		// Drain the queue before going idle
+		for s.ctx.Err() == nil && s.runNext(id) {
+		}
+
 		select {
 		case <-s.ctx.Done():
 			// Context cancelled - shutdown signal
 			log.Printf("  Worker %d shutting down", id)
 			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
 
-		case job, ok := <-s.jobs:
-			if !ok {
-				// Channel closed - no more jobs
-				log.Printf("  Worker %d: jobs channel closed", id)
-				return
-			}
+// runNext claims and processes a single job
+// Returns false when there was nothing to do (or claiming failed)
+func (s *EmbeddingServiceImpl) runNext(workerID int) bool {
+	job, err := s.jobRepo.ClaimNext(s.ctx)
+	if err != nil {
+		log.Printf("  Worker %d failed to claim job: %v", workerID, err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	// Use a fresh context so a shutdown doesn't abort a job halfway through
+	ctx := context.Background()
+
+	log.Printf("  Worker %d processing job %s (document %s, attempt %d/%d)",
+		workerID, job.ID, job.DocumentID, job.Attempts, job.MaxAttempts)
 
-			// Process the job
-			log.Printf("  Worker %d processing document %s", id, job.DocumentID)
-			if err := s.processEmbedding(job); err != nil {
-				log.Printf("  Worker %d error: %v", id, err)
-			} else {
-				log.Printf("  Worker %d completed document %s", id, job.DocumentID)
+	if err := s.runJob(ctx, job); err != nil {
+		if errors.Is(err, errStaleContent) {
+			// Not a failure: run again on the new text
+			log.Printf("  Worker %d: document %s changed mid-job, requeueing", workerID, job.DocumentID)
+			if err := s.jobRepo.Requeue(ctx, job.ID); err != nil {
+				log.Printf("  Worker %d: %v", workerID, err)
 			}
+			return true
 		}
+		s.recordFailure(ctx, job, err)
+		return true
 	}
+
+	if err := s.jobRepo.MarkSucceeded(ctx, job.ID); err != nil {
+		log.Printf("  Worker %d: %v", workerID, err)
+	}
+	log.Printf("  Worker %d completed document %s", workerID, job.DocumentID)
+	return true
 }
 
-// SubmitJob adds a job to the queue
-// Learning: This is non-blocking if queue has space, blocks if full (backpressure)
-func (s *EmbeddingServiceImpl) SubmitJob(job EmbeddingJob) error {
+// recordFailure reschedules a failed job with exponential backoff,
+// or marks it failed once it has used up its attempts
+func (s *EmbeddingServiceImpl) recordFailure(ctx context.Context, job *models.EmbeddingJob, jobErr error) {
+	// Bad credentials or oversized input fail the same way every time
+	permanent := errors.Is(jobErr, openai.ErrAuth) || errors.Is(jobErr, openai.ErrContextLength) ||
+		errors.Is(jobErr, ErrUnknownEmbeddingModel)
+
+	if permanent || job.Attempts >= job.MaxAttempts {
+		log.Printf("  Job %s failed permanently: %v", job.ID, jobErr)
+		if err := s.jobRepo.MarkFailed(ctx, job.ID, jobErr); err != nil {
+			log.Printf("  %v", err)
+		}
+		return
+	}
+
+	delay := jobRetryBase << (job.Attempts - 1)
+	if delay > jobRetryMax || delay <= 0 {
+		delay = jobRetryMax
+	}
+
+	log.Printf("  Job %s failed (attempt %d/%d), retrying in %s: %v",
+		job.ID, job.Attempts, job.MaxAttempts, delay, jobErr)
+	if err := s.jobRepo.MarkRetry(ctx, job.ID, jobErr, time.Now().Add(delay)); err != nil {
+		log.Printf("  %v", err)
+	}
+}
+
+// SubmitJob persists a job and wakes an idle worker
+// Learning: The insert is the durable part; the wake-up is just an optimization
+func (s *EmbeddingServiceImpl) SubmitJob(job EmbeddingJob) (*models.EmbeddingJob, error) {
+	if s.ctx.Err() != nil {
+		return nil, fmt.Errorf("service is shutting down")
+	}
+
+	persisted, err := s.jobRepo.Enqueue(context.Background(), job.DocumentID, s.maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+
 	select {
-	case s.jobs <- job:
-		return nil
-	case <-s.ctx.Done():
-		return fmt.Errorf("service is shutting down")
+	case s.wake <- struct{}{}:
+	default:
+		// All workers already have a pending wake-up
 	}
+
+	return persisted, nil
 }
 
-// processEmbedding handles the actual embedding generation
-// Learning: This is where the real work happens - called by workers
-func (s *EmbeddingServiceImpl) processEmbedding(job EmbeddingJob) error {
-	ctx := context.Background()
+// RemoveDocument drops a deleted document from the search index
+// Learning: Soft deletes keep the (soft-deleted) chunks so a restore is cheap;
+// hard deletes remove them for good
+func (s *EmbeddingServiceImpl) RemoveDocument(ctx context.Context, documentID string, hard bool) error {
+	if err := s.jobRepo.CancelPending(ctx, documentID, "document deleted"); err != nil {
+		return err
+	}
 
-	// Delete existing embeddings for this document
-	if err := s.embRepo.DeleteEmbeddingsByDocumentID(ctx, job.DocumentID); err != nil {
-		return fmt.Errorf("failed to delete old embeddings: %w", err)
+	if hard {
+		return s.embRepo.PurgeEmbeddingsByDocumentID(ctx, documentID)
 	}
+	return s.embRepo.DeleteEmbeddingsByDocumentID(ctx, documentID)
+}
 
-	// Chunk the document content
-	// Learning: Large documents need to be split into chunks for embedding
-	chunks := s.chunkText(job.Content, 500) // 500 words per chunk
+// GetJob returns a job by ID
+func (s *EmbeddingServiceImpl) GetJob(ctx context.Context, id string) (*models.EmbeddingJob, error) {
+	return s.jobRepo.GetByID(ctx, id)
+}
 
-	// Generate embeddings for each chunk
-	for i, chunk := range chunks {
-		// Call OpenAI API to generate embedding
-		embeddingVector, err := s.openaiClient.CreateEmbeddings([]string{chunk})
-		if err != nil {
-			return fmt.Errorf("failed to generate embedding for chunk %d: %w", i, err)
-		}
+// GetEmbeddingStatus reports whether a document is searchable and up to date
+func (s *EmbeddingServiceImpl) GetEmbeddingStatus(ctx context.Context, documentID string) (*models.EmbeddingStatus, error) {
+	count, err := s.embRepo.CountEmbeddingsByDocumentID(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
 
-		// Convert to pgvector format
-		vec := pgvector.NewVector(embeddingVector)
+	latest, err := s.jobRepo.GetLatestByDocumentID(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
 
-		// Store in database
-		embedding := &models.Embedding{
-			DocumentID: job.DocumentID,
-			ChunkIndex: i,
-			ChunkText:  chunk,
-			Embedding:  vec,
-		}
+	return &models.EmbeddingStatus{
+		DocumentID: documentID,
+		Searchable: count > 0,
+		ChunkCount: count,
+		UpToDate:   latest == nil || latest.Status == models.JobStatusSucceeded,
+		LatestJob:  latest,
+	}, nil
+}
 
-		if err := s.embRepo.StoreEmbedding(ctx, embedding); err != nil {
-			return fmt.Errorf("failed to store embedding for chunk %d: %w", i, err)
-		}
+// runJob embeds a document into the model(s) a job is for
+func (s *EmbeddingServiceImpl) runJob(ctx context.Context, job *models.EmbeddingJob) error {
+	doc, err := s.docRepo.GetByID(ctx, job.DocumentID)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("  Generated %d embeddings for document %s", len(chunks), job.DocumentID)
-	return nil
+	switch {
+	case job.Model == "":
+		// Edits go to the active model and, during a migration, to the new one
+		// too - otherwise the migration would cut over to stale chunks
+		if err := s.processEmbedding(ctx, doc, s.embedder, s.embRepo); err != nil {
+			return err
+		}
+		if s.migration != nil {
+			return s.processEmbedding(ctx, doc, s.migration.embedder, s.migration.repo)
+		}
+		return nil
+	case job.Model == s.embRepo.Model():
+		return s.processEmbedding(ctx, doc, s.embedder, s.embRepo)
+	case s.migration != nil && job.Model == s.migration.repo.Model():
+		return s.processEmbedding(ctx, doc, s.migration.embedder, s.migration.repo)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownEmbeddingModel, job.Model)
+	}
 }
 
-// chunkText splits text into chunks of approximately maxWords words
-// Learning: Embeddings work best on reasonably-sized chunks
-func (s *EmbeddingServiceImpl) chunkText(text string, maxWords int) []string {
-	words := strings.Fields(text)
-	if len(words) == 0 {
-		return []string{}
+// processEmbedding handles the actual embedding generation for one model
+// Learning: This is where the real work happens - called by workers
+func (s *EmbeddingServiceImpl) processEmbedding(ctx context.Context, doc *models.Document, embedder Embedder, embRepo EmbeddingRepository) error {
+	// Chunk the document content
+	// Learning: Each format has its own chunker, so markdown is split on
+	// headings, JSON on object paths and plain text on paragraphs
+	chunks := chunking.ForFormat(doc.Format, s.chunkOpts).Chunk(doc.Content)
+
+	// Generate embeddings in batches
+	// Learning: One API request per batch instead of one per chunk cuts
+	// round trips from len(chunks) to len(chunks)/batchSize
+	// Learning: Character offsets are counted now, against the content that
+	// was chunked. Counting them at search time would read the current
+	// content, which may have changed since - and a byte offset that lands
+	// inside a multi-byte character there fails the whole search
+	charStarts := make([]int, len(chunks))
+	bytePos, charPos := 0, 0
+	for i, chunk := range chunks {
+		if chunk.StartOffset < bytePos { // Overlapping chunks start before the previous one ended
+			bytePos, charPos = 0, 0
+		}
+		charPos += utf8.RuneCountInString(doc.Content[bytePos:chunk.StartOffset])
+		bytePos = chunk.StartOffset
+		charStarts[i] = charPos
 	}
 
-	var chunks []string
-	for i := 0; i < len(words); i += maxWords {
-		end := i + maxWords
-		if end > len(words) {
-			end = len(words)
+	embeddings := make([]*models.Embedding, 0, len(chunks))
+	for start := 0; start < len(chunks); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+
+		// The section path is embedded along with the text so it influences similarity
+		texts := make([]string, 0, end-start)
+		for _, chunk := range chunks[start:end] {
+			texts = append(texts, chunk.EmbeddingText())
+		}
+
+		vectors, err := embedder.CreateEmbeddings(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to generate embeddings for chunks %d-%d: %w", start, end-1, err)
+		}
+
+		for i, vector := range vectors {
+			chunk := chunks[start+i]
+			embeddings = append(embeddings, &models.Embedding{
+				DocumentID:  doc.ID,
+				ChunkIndex:  start + i,
+				ChunkText:   chunk.Text,
+				Section:     chunk.Context,
+				StartOffset: chunk.StartOffset,
+				EndOffset:   chunk.EndOffset,
+				CharStart:   charStarts[start+i],
+				CharEnd:     charStarts[start+i] + utf8.RuneCountInString(chunk.Text),
+				Embedding:   pgvector.NewVector(vector),
+			})
 		}
-		chunk := strings.Join(words[i:end], " ")
-		chunks = append(chunks, chunk)
 	}
 
-	return chunks
+	// Swap old embeddings for new ones in a single transaction, unless the
+	// document was edited since we read it
+	replaced, err := embRepo.ReplaceEmbeddings(ctx, doc.ID, doc.ContentHash, embeddings)
+	if err != nil {
+		return fmt.Errorf("failed to store embeddings: %w", err)
+	}
+	if !replaced {
+		return errStaleContent
+	}
+
+	log.Printf("  Generated %d embeddings for document %s (%s)", len(embeddings), doc.ID, embRepo.Model())
+	return nil
 }
 
 // Shutdown gracefully stops the worker pool
 // Learning: Important for clean shutdown - wait for all workers to finish
+// Unfinished jobs stay in the table and are picked up after restart
 func (s *EmbeddingServiceImpl) Shutdown() {
 	log.Println("🛑 Shutting down embedding service...")
 
-	// Close the jobs channel - no more jobs accepted
-	close(s.jobs)
-
-	// Cancel context to signal workers
+	// Cancel context to signal workers - no new jobs are claimed
 	s.cancel()
 
 	// Wait for all workers to finish current jobs
@@ -215,5 +414,10 @@ func (s *EmbeddingServiceImpl) Shutdown() {
 // GetQueueLength returns current number of pending jobs
 // Learning: Useful for monitoring/debugging
 func (s *EmbeddingServiceImpl) GetQueueLength() int {
-	return len(s.jobs)
+	count, err := s.jobRepo.CountByStatus(context.Background(), models.JobStatusPending)
+	if err != nil {
+		log.Printf("⚠️  Failed to count pending jobs: %v", err)
+		return 0
+	}
+	return int(count)
 }
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"ai-kms/internal/middleware"
+	"ai-kms/internal/models"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+LEARNING: CONTENT-ADDRESSED EMBEDDING CACHE
+
+An embedding depends only on the model and the exact text, so the SHA-256
+of the text is a perfect cache key:
+
+  "## Restart\nRun kubectl rollout..."  →  sha256 9f2c…  →  [0.012, -0.4, …]
+
+Two layers sit in front of the provider:
+
+  1. In-process LRU  - nanoseconds, lost on restart, bounded by entry count
+  2. embedding_cache - a Postgres table shared by every instance, survives
+                       restarts and redeploys
+
+  CreateEmbeddings(texts)
+    → hash each text
+    → LRU hits
+    → one SELECT for the rest
+    → ONE provider call for what's still missing
+    → write the new vectors back to both layers
+
+Re-embedding a document after a typo fix re-chunks it, but every chunk except
+the edited one hashes the same, so only that chunk is paid for. Repeated
+search queries ("how to restart pgbouncer") skip the provider entirely.
+
+The cache is per model: switching EMBEDDING_MODEL starts from a cold cache
+rather than mixing vectors of different models.
+*/
+
+// EmbeddingCache is an Embedder that remembers embeddings by content hash
+// Wraps the provider for one model; safe for concurrent use
+type EmbeddingCache struct {
+	next     Embedder
+	model    string
+	store    EmbeddingCacheRepository // Persistent layer (nil = memory only)
+	capacity int                      // In-memory entries (0 = no memory layer)
+
+	mu    sync.Mutex
+	lru   *list.List               // Most recently used at the front
+	items map[string]*list.Element // Content hash → element holding a *cacheItem
+
+	memoryHits atomic.Int64
+	storeHits  atomic.Int64
+	misses     atomic.Int64
+}
+
+type cacheItem struct {
+	hash   string
+	vector []float32
+}
+
+// NewEmbeddingCache wraps an embedder of the given model with a cache
+func NewEmbeddingCache(next Embedder, model string, store EmbeddingCacheRepository, capacity int) *EmbeddingCache {
+	return &EmbeddingCache{
+		next:     next,
+		model:    model,
+		store:    store,
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// CreateEmbeddings returns one embedding per text, calling the provider only
+// for texts that neither cache layer has seen
+// Learning: The returned vectors are shared with the cache - treat them as read-only
+func (c *EmbeddingCache) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	hashes := make([]string, len(texts))
+	for i, text := range texts {
+		hashes[i] = models.HashContent(text)
+	}
+
+	// Layer 1: memory
+	pending := make(map[string][]int) // Hash → positions still missing (duplicates share a lookup)
+	var order []string                // Pending hashes in first-seen order
+	memoryHits := 0
+	for i, hash := range hashes {
+		if vec, ok := c.get(hash); ok {
+			vectors[i] = vec
+			memoryHits++
+			continue
+		}
+		if _, seen := pending[hash]; !seen {
+			order = append(order, hash)
+		}
+		pending[hash] = append(pending[hash], i)
+	}
+	c.memoryHits.Add(int64(memoryHits))
+
+	// Layer 2: Postgres
+	storeHits := 0
+	if c.store != nil && len(order) > 0 {
+		found, err := c.store.GetMany(ctx, c.model, order)
+		if err != nil {
+			// A cache outage costs money, not correctness - fall through to the provider
+			middleware.AddSpanError(ctx, err)
+		}
+		remaining := order[:0]
+		for _, hash := range order {
+			vec, ok := found[hash]
+			if !ok {
+				remaining = append(remaining, hash)
+				continue
+			}
+			c.put(hash, vec)
+			for _, i := range pending[hash] {
+				vectors[i] = vec
+			}
+			storeHits += len(pending[hash])
+			delete(pending, hash)
+		}
+		order = remaining
+		c.storeHits.Add(int64(storeHits))
+	}
+
+	// Layer 3: the provider, once for all remaining texts
+	misses := 0
+	if len(order) > 0 {
+		missing := make([]string, len(order))
+		for j, hash := range order {
+			missing[j] = texts[pending[hash][0]]
+		}
+
+		fresh, err := c.next.CreateEmbeddings(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		if len(fresh) != len(missing) {
+			return nil, fmt.Errorf("embedding provider returned %d vectors for %d texts", len(fresh), len(missing))
+		}
+
+		created := make(map[string][]float32, len(order))
+		for j, hash := range order {
+			created[hash] = fresh[j]
+			c.put(hash, fresh[j])
+			for _, i := range pending[hash] {
+				vectors[i] = fresh[j]
+			}
+			misses += len(pending[hash])
+		}
+		c.misses.Add(int64(misses))
+
+		if c.store != nil {
+			if err := c.store.PutMany(ctx, c.model, created); err != nil {
+				middleware.AddSpanError(ctx, err) // The vectors are still good - only the next call pays again
+			}
+		}
+	}
+
+	middleware.AddSpanEvent(ctx, "embedding_cache",
+		attribute.String("model", c.model),
+		attribute.Int("memory_hits", memoryHits),
+		attribute.Int("store_hits", storeHits),
+		attribute.Int("misses", misses),
+	)
+	return vectors, nil
+}
+
+// Stats returns the hit and miss counters since the process started
+func (c *EmbeddingCache) Stats() *models.EmbeddingCacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	stats := &models.EmbeddingCacheStats{
+		Model:      c.model,
+		MemoryHits: c.memoryHits.Load(),
+		StoreHits:  c.storeHits.Load(),
+		Misses:     c.misses.Load(),
+		Entries:    entries,
+		Capacity:   c.capacity,
+	}
+	if lookups := stats.MemoryHits + stats.StoreHits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.MemoryHits+stats.StoreHits) / float64(lookups)
+	}
+	return stats
+}
+
+// get returns a vector from memory and marks it as recently used
+func (c *EmbeddingCache) get(hash string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[hash]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheItem).vector, true
+}
+
+// put adds a vector to memory, evicting the least recently used entry when full
+func (c *EmbeddingCache) put(hash string, vector []float32) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[hash]; ok {
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[hash] = c.lru.PushFront(&cacheItem{hash: hash, vector: vector})
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).hash)
+	}
+}
+
+// cacheStatsReporter is implemented by embedders wrapped in an EmbeddingCache
+type cacheStatsReporter interface {
+	Stats() *models.EmbeddingCacheStats
+}
+
+// EmbeddingCacheStats reports the cache counters of the active model and the
+// migration target
+// Learning: Search and RAG share the same cache instances, so query lookups
+// are included. Empty when EMBEDDING_CACHE=off
+func (s *EmbeddingServiceImpl) EmbeddingCacheStats() []*models.EmbeddingCacheStats {
+	embedders := []Embedder{s.embedder}
+	if s.migration != nil {
+		embedders = append(embedders, s.migration.embedder)
+	}
+
+	stats := make([]*models.EmbeddingCacheStats, 0, len(embedders))
+	for _, e := range embedders {
+		if cache, ok := e.(cacheStatsReporter); ok {
+			stats = append(stats, cache.Stats())
+		}
+	}
+	return stats
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"ai-kms/internal/models"
+)
+
+/*
+LEARNING: SWITCHING EMBEDDING MODELS WITHOUT DOWNTIME
+
+A query vector is only comparable to chunks from the same model, so a new
+model means re-embedding the whole corpus. Doing that in place would leave
+search half-broken for hours. Instead both models live side by side
+(see models.Embedding) and the switch happens in steps:
+
+ 1. EMBEDDING_MIGRATE_TO=new-model - every document without new-model chunks
+    gets a migration job. Workers fill the new model in the background
+    while searches keep using EMBEDDING_MODEL. Edits are embedded into both.
+ 2. GET /api/admin/embeddings/migration until it reports "complete".
+ 3. EMBEDDING_MODEL=new-model, without EMBEDDING_MIGRATE_TO, and restart.
+    Every query switches at once - the new chunks are already there.
+ 4. The old chunks stay for a rollback until they are deleted with
+    DELETE /api/admin/embeddings/models/{old-model}.
+
+Migration jobs are ordinary rows in the job queue, so they survive restarts,
+are retried with backoff and share the rate limit with everything else.
+*/
+
+var (
+	// ErrNoMigration means no migration target is configured
+	ErrNoMigration = errors.New("no embedding migration configured (set EMBEDDING_MIGRATE_TO)")
+	// ErrModelInUse means an embedding model can't be dropped because it serves searches or is being migrated to
+	ErrModelInUse = errors.New("embedding model is in use")
+	// ErrUnknownEmbeddingModel means a job asks for a model this server isn't configured with
+	ErrUnknownEmbeddingModel = errors.New("embedding model is not configured")
+)
+
+// migrationTarget is the model a migration is filling
+type migrationTarget struct {
+	embedder Embedder
+	repo     EmbeddingRepository // Scoped to the target model
+}
+
+// SetMigrationTarget makes the workers fill a second embedding model in the background
+// Call before Start; searches keep using the active model
+func (s *EmbeddingServiceImpl) SetMigrationTarget(embedder Embedder, repo EmbeddingRepository) {
+	s.migration = &migrationTarget{embedder: embedder, repo: repo}
+}
+
+// StartMigration queues every document that has no chunks for the target model yet
+// Safe to call repeatedly - e.g. to retry documents whose jobs failed
+// Returns the number of jobs queued
+func (s *EmbeddingServiceImpl) StartMigration(ctx context.Context) (int, error) {
+	if s.migration == nil {
+		return 0, ErrNoMigration
+	}
+
+	coverage, err := s.migration.repo.Coverage(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := s.jobRepo.EnqueueForModel(ctx, coverage.Missing, s.migration.repo.Model(), s.maxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < n && i < s.workers; i++ {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return n, nil
+}
+
+// MigrationStatus reports how far the target model is from covering the corpus
+func (s *EmbeddingServiceImpl) MigrationStatus(ctx context.Context) (*models.EmbeddingMigrationStatus, error) {
+	status := &models.EmbeddingMigrationStatus{ActiveModel: s.embRepo.Model()}
+	if s.migration == nil {
+		return status, nil
+	}
+	target := s.migration.repo.Model()
+	status.TargetModel = target
+
+	coverage, err := s.migration.repo.Coverage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.Documents = coverage.Documents
+	status.Remaining = len(coverage.Missing)
+
+	for _, st := range []models.JobStatus{models.JobStatusPending, models.JobStatusRunning} {
+		n, err := s.jobRepo.CountByModel(ctx, target, st)
+		if err != nil {
+			return nil, err
+		}
+		status.PendingJobs += n
+	}
+
+	status.Complete = status.Remaining == 0 && status.PendingJobs == 0
+	return status, nil
+}
+
+// ListEmbeddingModels returns every model with stored chunks, marking the
+// active model and the migration target
+func (s *EmbeddingServiceImpl) ListEmbeddingModels(ctx context.Context) ([]*models.EmbeddingModelStats, error) {
+	stats, err := s.embRepo.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, st := range stats {
+		st.Active = st.Model == s.embRepo.Model()
+		st.Target = s.migration != nil && st.Model == s.migration.repo.Model()
+	}
+	return stats, nil
+}
+
+// DropEmbeddingModel deletes all chunks of a model that is no longer used
+func (s *EmbeddingServiceImpl) DropEmbeddingModel(ctx context.Context, model string) error {
+	if model == s.embRepo.Model() || (s.migration != nil && model == s.migration.repo.Model()) {
+		return fmt.Errorf("%w: %s", ErrModelInUse, model)
+	}
+	return s.embRepo.PurgeModel(ctx, model)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	snippetRadius = 120 // Characters of context kept on each side of the best match
+	snippetMax    = 300 // Snippet length when nothing matches
+)
+
+// highlightStopWords are too common to be worth highlighting
+var highlightStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "do": true, "for": true, "from": true, "how": true,
+	"i": true, "in": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "the": true, "to": true, "what": true, "when": true,
+	"where": true, "why": true, "with": true,
+}
+
+// queryTerms returns the lowercased words of a query worth highlighting
+func queryTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(query), isWordSeparator) {
+		if highlightStopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// isWordSeparator splits on anything that can't be part of an identifier
+// Learning: Keeping '_' and '-' inside words means ERR_CONN_RESET and
+// pg-bouncer are highlighted as a whole
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-'
+}
+
+// wordSpan is a [start, end) rune range of one word in the text
+type wordSpan struct {
+	start, end int
+	match      bool
+}
+
+// highlight returns an HTML-escaped excerpt of text centered on the densest
+// cluster of query terms, with every matching word wrapped in <mark>
+// Learning: A word matches when it starts with a query term, so "restart"
+// also highlights "restarting" - a cheap stand-in for stemming
+func highlight(text, query string) string {
+	runes := []rune(text)
+	terms := queryTerms(query)
+
+	// Find every word and whether it matches a term
+	var words []wordSpan
+	for i := 0; i < len(runes); {
+		if isWordSeparator(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && !isWordSeparator(runes[j]) {
+			j++
+		}
+		word := strings.ToLower(string(runes[i:j]))
+		w := wordSpan{start: i, end: j}
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				w.match = true
+				break
+			}
+		}
+		words = append(words, w)
+		i = j
+	}
+
+	// Pick the window with the most matches, anchored on a matching word
+	start, end := 0, min(len(runes), snippetMax)
+	bestCount := 0
+	for i, w := range words {
+		if !w.match {
+			continue
+		}
+		count := 0
+		for _, other := range words[i:] {
+			if other.start >= w.start+2*snippetRadius {
+				break
+			}
+			if other.match {
+				count++
+			}
+		}
+		if count > bestCount {
+			bestCount = count
+			start = max(0, w.start-snippetRadius/2)
+			end = min(len(runes), w.start+2*snippetRadius)
+		}
+	}
+
+	// Don't cut words in half at the window edges
+	for start > 0 && !isWordSeparator(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && !isWordSeparator(runes[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, w := range words {
+		if !w.match || w.start < start || w.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:w.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[w.start:w.end])))
+		b.WriteString("</mark>")
+		pos = w.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return strings.TrimSpace(b.String())
+}
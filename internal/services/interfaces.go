@@ -2,8 +2,10 @@ package services
 
 import (
 	"context"
+	"time"
 
 	"ai-kms/internal/models"
+	"ai-kms/internal/openai"
 )
 
 /*
@@ -45,9 +47,84 @@ type DocumentRepository interface {
 // EmbeddingRepository defines what the service needs from embedding storage
 type EmbeddingRepository interface {
 	StoreEmbedding(ctx context.Context, embedding *models.Embedding) error
+	ReplaceEmbeddings(ctx context.Context, docID, contentHash string, embeddings []*models.Embedding) (bool, error)
 	GetEmbeddingsByDocumentID(ctx context.Context, docID string) ([]*models.Embedding, error)
-	SemanticSearch(ctx context.Context, queryEmbedding []float32, limit int) ([]*models.SearchResult, error)
+	CountEmbeddingsByDocumentID(ctx context.Context, docID string) (int64, error)
+	SemanticSearch(ctx context.Context, queryEmbedding []float32, filters models.SearchFilters, limit int) ([]*models.SearchResult, error)
+	KeywordSearch(ctx context.Context, query string, filters models.SearchFilters, limit int) ([]*models.SearchResult, error)
+	HybridSearch(ctx context.Context, queryEmbedding []float32, query string, filters models.SearchFilters, limit int, weights models.HybridWeights) ([]*models.SearchResult, error)
 	DeleteEmbeddingsByDocumentID(ctx context.Context, docID string) error
+	PurgeEmbeddingsByDocumentID(ctx context.Context, docID string) error
+
+	// Embedding models (each repository reads and writes one model)
+	Model() string
+	ListModels(ctx context.Context) ([]*models.EmbeddingModelStats, error)
+	Coverage(ctx context.Context) (*models.EmbeddingCoverage, error)
+	PurgeModel(ctx context.Context, model string) error
+}
+
+// JobRepository defines what the embedding workers need from the job queue
+type JobRepository interface {
+	Enqueue(ctx context.Context, documentID string, maxAttempts int) (*models.EmbeddingJob, error)
+	EnqueueForModel(ctx context.Context, documentIDs []string, model string, maxAttempts int) (int, error)
+	ClaimNext(ctx context.Context) (*models.EmbeddingJob, error)
+	MarkSucceeded(ctx context.Context, id string) error
+	MarkRetry(ctx context.Context, id string, jobErr error, runAfter time.Time) error
+	MarkFailed(ctx context.Context, id string, jobErr error) error
+	Requeue(ctx context.Context, id string) error
+	RequeueStale(ctx context.Context, olderThan time.Duration) (int64, error)
+	CancelPending(ctx context.Context, documentID string, reason string) error
+	GetByID(ctx context.Context, id string) (*models.EmbeddingJob, error)
+	GetLatestByDocumentID(ctx context.Context, documentID string) (*models.EmbeddingJob, error)
+	CountByStatus(ctx context.Context, status models.JobStatus) (int64, error)
+	CountByModel(ctx context.Context, model string, status models.JobStatus) (int64, error)
+}
+
+// SummaryRepository defines what summarization needs from the summary cache
+type SummaryRepository interface {
+	Get(ctx context.Context, documentID, contentHash string, mode models.SummaryMode, maxWords int, promptSet, chatModel string) (*models.DocumentSummary, error)
+	Save(ctx context.Context, summary *models.DocumentSummary) error
+	DeleteByDocumentID(ctx context.Context, documentID string) error
+}
+
+// EmbeddingCacheRepository defines what the embedding cache needs from persistent storage
+type EmbeddingCacheRepository interface {
+	GetMany(ctx context.Context, model string, hashes []string) (map[string][]float32, error)
+	PutMany(ctx context.Context, model string, vectors map[string][]float32) error
+}
+
+// ConversationRepository defines what conversational RAG needs from chat history storage
+type ConversationRepository interface {
+	Create(ctx context.Context, title string) (*models.Conversation, error)
+	GetByID(ctx context.Context, id string) (*models.Conversation, error)
+	List(ctx context.Context, limit, offset int) ([]*models.Conversation, error)
+	AddMessages(ctx context.Context, conversationID string, messages ...*models.ConversationMessage) error
+	SetTitle(ctx context.Context, id, title string) error
+	Delete(ctx context.Context, id string) error
+}
+
+// VersionRepository defines what version history needs from storage
+type VersionRepository interface {
+	Create(ctx context.Context, version *models.DocumentVersion) error
+	List(ctx context.Context, documentID string, limit, offset int) ([]*models.DocumentVersion, error)
+	GetByID(ctx context.Context, documentID, id string) (*models.DocumentVersion, error)
+	Latest(ctx context.Context, documentID string) (*models.DocumentVersion, error)
+	DeleteByDocumentID(ctx context.Context, documentID string) error
+}
+
+// Embedder defines what the services need from an embedding provider
+// Implemented by openai.Client (remote API) and local.Client (offline)
+type Embedder interface {
+	CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// ChatModel defines what the services need from a chat completion provider
+// Learning: Messages use the OpenAI chat format, which most providers accept.
+// The task carries the inputs the messages were rendered from
+type ChatModel interface {
+	ChatCompletion(ctx context.Context, task openai.ChatTask, messages []openai.ChatMessage) (string, error)
+	ChatCompletionStream(ctx context.Context, task openai.ChatTask, messages []openai.ChatMessage, onDelta func(string) error) (string, error)
+	ChatModelName() string // Part of the key of cached summaries
 }
 
 // ChatMessage represents a chat message
@@ -57,13 +134,8 @@ type ChatMessage struct {
 }
 
 // SearchFilters for advanced search
-type SearchFilters struct {
-	Format     *models.DocumentFormat
-	MinScore   float32
-	DateFrom   *string
-	DateTo     *string
-	ExcludeIDs []string
-}
+// Learning: An alias, because the repository applies the same filters in SQL
+type SearchFilters = models.SearchFilters
 
 // Future service interfaces (when we implement them)
 
@@ -74,8 +146,9 @@ type AIService interface {
 	ExtractKeywords(ctx context.Context, text string) ([]string, error)
 }
 
-// SearchService will handle semantic search operations
+// SearchService handles semantic, keyword and hybrid search
+// Implemented by SearchServiceImpl
 type SearchService interface {
 	Search(ctx context.Context, query string, limit int) ([]*models.SearchResult, error)
-	SearchWithFilters(ctx context.Context, query string, filters SearchFilters, limit int) ([]*models.SearchResult, error)
+	SearchWithFilters(ctx context.Context, query string, mode SearchMode, filters SearchFilters, limit int) ([]*models.SearchResult, error)
 }
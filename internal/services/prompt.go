@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ai-kms/internal/models"
+	"ai-kms/internal/prompts"
+	"ai-kms/internal/tokenizer"
+)
+
+/*
+LEARNING: PROMPT BUDGETING
+
+A chat model has a fixed context window shared by the prompt and the answer.
+Retrieval returns chunks in score order, so the best way to stay inside the
+window is to add chunks from best to worst and stop adding once the budget
+is spent:
+
+  budget = 3000 tokens
+  reserved (system + instructions + question) = 120
+  [0.91] 850 tokens ✓   [0.88] 1400 tokens ✓   [0.84] 900 tokens ✗ (dropped)
+  [0.80] 400 tokens ✓   → 2650 / 2880 used, 1 chunk dropped
+
+Dropped chunks are reported back to the caller so the API can tell users
+the answer was based on partial context.
+*/
+
+// PromptBuilder renders prompt templates and fits retrieved context and
+// documents into a token budget
+type PromptBuilder struct {
+	tok       tokenizer.Tokenizer
+	budget    int // Max tokens for everything sent to the model
+	templates *prompts.Registry
+}
+
+// NewPromptBuilder creates a prompt builder with the given total token budget
+// A nil registry uses the built-in templates
+func NewPromptBuilder(tok tokenizer.Tokenizer, budget int, templates *prompts.Registry) *PromptBuilder {
+	if budget <= 0 {
+		budget = 3000
+	}
+	if templates == nil {
+		templates = prompts.Defaults()
+	}
+	return &PromptBuilder{tok: tok, budget: budget, templates: templates}
+}
+
+// Render executes a prompt template of the prompt set selected in ctx
+func (b *PromptBuilder) Render(ctx context.Context, name string, data any) (string, error) {
+	return b.templates.Render(ctx, name, data)
+}
+
+// PackedContext is the retrieval context that fit in the budget
+type PackedContext struct {
+	Text    string                 // Formatted context for the prompt
+	Sources []*models.SearchResult // Chunks included, numbered in this order
+	Chunks  []string               // Text of each source as it appears in Text
+	Tokens  int                    // Tokens used by Text
+	Dropped int                    // Chunks left out to stay within budget
+	Partial bool                   // The only included chunk was cut short
+}
+
+// Truncated reports whether any retrieved context was left out
+func (p PackedContext) Truncated() bool {
+	return p.Dropped > 0 || p.Partial
+}
+
+// Count returns the number of tokens in the given texts
+func (b *PromptBuilder) Count(texts ...string) int {
+	total := 0
+	for _, t := range texts {
+		total += b.tok.Count(t)
+	}
+	return total
+}
+
+// Budget returns the total token budget of a prompt
+func (b *PromptBuilder) Budget() int {
+	return b.budget
+}
+
+// available returns the tokens left after the fixed parts of the prompt
+func (b *PromptBuilder) available(fixed ...string) int {
+	return b.budget - b.Count(fixed...)
+}
+
+// PackContext adds the highest-scoring results until the budget is reached
+// fixed is every other part of the prompt (system message, template, question)
+func (b *PromptBuilder) PackContext(results []*models.SearchResult, fixed ...string) PackedContext {
+	available := b.available(fixed...)
+
+	ranked := make([]*models.SearchResult, len(results))
+	copy(ranked, results)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+
+	var packed PackedContext
+	var parts []string
+	for _, result := range ranked {
+		part := formatContextChunk(len(parts)+1, result)
+		tokens := b.tok.Count(part) + 1 // +1 for the separating newline
+		if packed.Tokens+tokens > available {
+			// A smaller, lower-ranked chunk may still fit
+			packed.Dropped++
+			continue
+		}
+		parts = append(parts, part)
+		packed.Sources = append(packed.Sources, result)
+		packed.Chunks = append(packed.Chunks, result.ChunkText)
+		packed.Tokens += tokens
+	}
+
+	// Even the best chunk is too big - send as much of it as fits
+	if len(parts) == 0 && len(ranked) > 0 && available > 0 {
+		best := *ranked[0]
+		header := formatContextChunk(1, &models.SearchResult{Title: best.Title, Section: best.Section})
+		best.ChunkText = b.tok.Truncate(best.ChunkText, available-b.tok.Count(header)-1)
+		if best.ChunkText != "" {
+			part := formatContextChunk(1, &best)
+			parts = append(parts, part)
+			packed.Sources = append(packed.Sources, ranked[0])
+			packed.Chunks = append(packed.Chunks, best.ChunkText)
+			packed.Tokens = b.tok.Count(part) + 1
+			packed.Dropped--
+			packed.Partial = true
+		}
+	}
+
+	packed.Text = strings.Join(parts, "\n")
+	return packed
+}
+
+// FitDocument truncates content so it fits the budget alongside the fixed parts
+// Returns the (possibly shortened) content and whether it was cut
+func (b *PromptBuilder) FitDocument(content string, fixed ...string) (string, bool) {
+	available := b.available(fixed...)
+	if b.tok.Count(content) <= available {
+		return content, false
+	}
+	return b.tok.Truncate(content, available), true
+}
+
+// Batches groups consecutive texts into prompts that each fit the budget
+// alongside the fixed parts; a text too large on its own is truncated
+func (b *PromptBuilder) Batches(texts []string, fixed ...string) []string {
+	available := b.available(fixed...)
+
+	var batches []string
+	var current []string
+	used := 0
+	for _, text := range texts {
+		tokens := b.tok.Count(text) + 2 // +2 for the separating blank line
+		if len(current) > 0 && used+tokens > available {
+			batches = append(batches, strings.Join(current, "\n\n"))
+			current, used = nil, 0
+		}
+		if tokens > available {
+			text = b.tok.Truncate(text, available-2)
+			tokens = available
+		}
+		current = append(current, text)
+		used += tokens
+	}
+	if len(current) > 0 {
+		batches = append(batches, strings.Join(current, "\n\n"))
+	}
+	return batches
+}
+
+// formatContextChunk renders one retrieved chunk as "[Document N] Title > Section:"
+func formatContextChunk(n int, result *models.SearchResult) string {
+	// Include the section path so the model knows where the chunk came from
+	source := result.Title
+	if result.Section != "" {
+		source += " > " + result.Section
+	}
+	return fmt.Sprintf("[Document %d] %s:\n%s\n", n, source, result.ChunkText)
+}
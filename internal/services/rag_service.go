@@ -2,12 +2,16 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
+	"ai-kms/internal/chunking"
 	"ai-kms/internal/middleware"
 	"ai-kms/internal/models"
 	"ai-kms/internal/openai"
+	"ai-kms/internal/prompts"
 
 	"go.opentelemetry.io/otel/attribute"
 )
@@ -41,107 +45,286 @@ Flow:
 
 // RAGService handles Retrieval Augmented Generation
 type RAGService struct {
-	openaiClient *openai.Client
-	embRepo      EmbeddingRepository
+	embedder    Embedder
+	chat        ChatModel
+	embRepo     EmbeddingRepository
+	summaryRepo SummaryRepository
+	prompts     *PromptBuilder
+	chunkOpts   chunking.Options // Used to chunk documents that aren't embedded yet
+	rerank      *RerankStage     // Optional; nil keeps the vector search order
 }
 
 // NewRAGService creates a new RAG service
+// Learning: Embedder and ChatModel are usually the same provider, but don't have to be
 func NewRAGService(
-	openaiClient *openai.Client,
+	embedder Embedder,
+	chat ChatModel,
 	embRepo EmbeddingRepository,
+	summaryRepo SummaryRepository,
+	prompts *PromptBuilder,
+	chunkOpts chunking.Options,
 ) *RAGService {
 	return &RAGService{
-		openaiClient: openaiClient,
-		embRepo:      embRepo,
+		embedder:    embedder,
+		chat:        chat,
+		embRepo:     embRepo,
+		summaryRepo: summaryRepo,
+		prompts:     prompts,
+		chunkOpts:   chunkOpts,
 	}
 }
 
+// SetRerankStage enables re-ranking of retrieved chunks before prompting
+func (s *RAGService) SetRerankStage(stage *RerankStage) {
+	s.rerank = stage
+}
+
+// RAGAnswer is the answer to a RAG query and the context it was based on
+type RAGAnswer struct {
+	Answer        string
+	Sources       []*models.SearchResult // Chunks included in the prompt, as numbered there
+	ContextTokens int                    // Tokens used by the retrieved context
+	Truncated     bool                   // Some retrieved context didn't fit the token budget
+	DroppedChunks int
+
+	Segments         []models.AnswerSegment // Answer split on its [n] citation markers
+	Citations        []models.Citation      // Sources the answer actually cites
+	InvalidCitations []int                  // Markers that don't match any context chunk (dropped)
+	Grounded         bool                   // The answer cites at least one context chunk
+	WholeDocument    bool                   // Context was the entire document, not retrieved chunks
+}
+
+// ErrDocumentNotIndexed means a document has no embeddings yet and is too
+// long to fit the prompt as a whole
+var ErrDocumentNotIndexed = errors.New("document is not embedded yet and too long to use as context")
+
+const noContextAnswer = "I don't have enough context to answer this question."
+
 // QueryWithContext performs RAG query
 // Learning: This is the core RAG implementation
-func (s *RAGService) QueryWithContext(ctx context.Context, query string, maxChunks int) (string, []*models.SearchResult, error) {
+func (s *RAGService) QueryWithContext(ctx context.Context, query string, maxChunks int) (*RAGAnswer, error) {
 	ctx, span := middleware.StartSpan(ctx, "RAG.QueryWithContext",
 		attribute.String("query", query),
 		attribute.Int("max_chunks", maxChunks),
 	)
 	defer span.End()
 
-	// Step 1: Generate embedding for the query
-	// Learning: Convert question to same vector space as documents
-	queryEmbedding, err := s.openaiClient.CreateEmbeddings([]string{query})
+	// Steps 1-3: Retrieve and pack context
+	packed, err := s.retrieveContext(ctx, query, SearchFilters{}, maxChunks)
 	if err != nil {
 		middleware.AddSpanError(ctx, err)
-		return "", nil, fmt.Errorf("failed to create query embedding: %w", err)
+		return nil, err
+	}
+	if packed == nil {
+		return &RAGAnswer{Answer: noContextAnswer}, nil
 	}
 
-	// Step 2: Semantic search to find relevant context
-	// Learning: Find most similar chunks using cosine similarity
-	results, err := s.embRepo.SemanticSearch(ctx, queryEmbedding, maxChunks)
+	// Step 4: Build prompt with context
+	messages, err := s.ragMessages(ctx, query, packed.Text)
 	if err != nil {
 		middleware.AddSpanError(ctx, err)
-		return "", nil, fmt.Errorf("failed to search: %w", err)
+		return nil, err
 	}
 
-	if len(results) == 0 {
-		return "I don't have enough context to answer this question.", nil, nil
+	// Step 5: Get answer from LLM
+	answer, err := s.chat.ChatCompletion(ctx, answerTask(query, packed), messages)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, fmt.Errorf("failed to get completion: %w", err)
 	}
 
-	// Step 3: Build context from retrieved chunks
-	contextParts := make([]string, 0, len(results))
-	for i, result := range results {
-		contextParts = append(contextParts, fmt.Sprintf(
-			"[Document %d] %s:\n%s\n",
-			i+1,
-			result.Title,
-			result.ChunkText,
-		))
-	}
-	context := strings.Join(contextParts, "\n")
+	return s.completeAnswer(ctx, packed, answer), nil
+}
 
-	// Step 4: Build prompt with context
-	prompt := buildRAGPrompt(query, context)
+// StreamQueryWithContext performs a RAG query, streaming the answer as it is generated
+// onContext receives the answer's sources (with an empty Answer) before generation
+// starts; onDelta receives each piece of the answer. An error from either stops
+// the query, and cancelling ctx stops generation.
+// Returns the complete answer.
+func (s *RAGService) StreamQueryWithContext(
+	ctx context.Context,
+	query string,
+	maxChunks int,
+	onContext func(*RAGAnswer) error,
+	onDelta func(string) error,
+) (*RAGAnswer, error) {
+	ctx, span := middleware.StartSpan(ctx, "RAG.StreamQueryWithContext",
+		attribute.String("query", query),
+		attribute.Int("max_chunks", maxChunks),
+	)
+	defer span.End()
 
-	// Step 5: Get answer from LLM
-	answer, err := s.openaiClient.ChatCompletion(ctx, []openai.ChatMessage{
-		{Role: "system", Content: "You are a helpful assistant that answers questions based on the provided context. Always cite which document you're using."},
-		{Role: "user", Content: prompt},
-	})
+	packed, err := s.retrieveContext(ctx, query, SearchFilters{}, maxChunks)
 	if err != nil {
 		middleware.AddSpanError(ctx, err)
-		return "", results, fmt.Errorf("failed to get completion: %w", err)
+		return nil, err
+	}
+	if packed == nil {
+		result := &RAGAnswer{}
+		if err := onContext(result); err != nil {
+			return nil, err
+		}
+		result.Answer = noContextAnswer
+		return result, onDelta(noContextAnswer)
 	}
 
-	middleware.AddSpanEvent(ctx, "rag_completed",
-		attribute.Int("context_chunks", len(results)),
-		attribute.Int("answer_length", len(answer)),
-	)
+	sources := &RAGAnswer{
+		Sources:       packed.Sources,
+		ContextTokens: packed.Tokens,
+		Truncated:     packed.Truncated(),
+		DroppedChunks: packed.Dropped,
+	}
+	messages, err := s.ragMessages(ctx, query, packed.Text)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+	if err := onContext(sources); err != nil {
+		return nil, err
+	}
 
-	return answer, results, nil
+	answer, err := s.chat.ChatCompletionStream(ctx, answerTask(query, packed), messages, onDelta)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, fmt.Errorf("failed to stream completion: %w", err)
+	}
+
+	return s.completeAnswer(ctx, packed, answer), nil
 }
 
-// SummarizeDocument generates a summary of a document
-func (s *RAGService) SummarizeDocument(ctx context.Context, content string, maxLength int) (string, error) {
-	ctx, span := middleware.StartSpan(ctx, "RAG.SummarizeDocument",
-		attribute.Int("content_length", len(content)),
-		attribute.Int("max_length", maxLength),
+// QueryDocument answers a question about a single document
+// Learning: Retrieval is scoped to the document's own chunks, so a long
+// document costs a few relevant chunks instead of the whole text. Only when
+// the document isn't embedded yet is the whole text used - if it fits.
+func (s *RAGService) QueryDocument(ctx context.Context, doc *models.Document, query string, maxChunks int) (*RAGAnswer, error) {
+	ctx, span := middleware.StartSpan(ctx, "RAG.QueryDocument",
+		attribute.String("document_id", doc.ID),
+		attribute.String("query", query),
+		attribute.Int("max_chunks", maxChunks),
 	)
 	defer span.End()
 
-	prompt := fmt.Sprintf(
-		"Please provide a concise summary of the following document in no more than %d words:\n\n%s",
-		maxLength,
-		content,
-	)
+	packed, err := s.retrieveContext(ctx, query, SearchFilters{DocumentIDs: []string{doc.ID}}, maxChunks)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
 
-	summary, err := s.openaiClient.ChatCompletion(ctx, []openai.ChatMessage{
-		{Role: "system", Content: "You are a helpful assistant that creates concise, accurate summaries."},
-		{Role: "user", Content: prompt},
-	})
+	whole := false
+	if packed == nil {
+		// Nothing indexed - try the whole document, chunked so citations still
+		// point at chunk indexes
+		chunks := chunking.ForFormat(doc.Format, s.chunkOpts).Chunk(doc.Content)
+		results := make([]*models.SearchResult, len(chunks))
+		for i, c := range chunks {
+			start := utf8.RuneCountInString(doc.Content[:c.StartOffset])
+			results[i] = &models.SearchResult{
+				DocumentID:  doc.ID,
+				Title:       doc.Title,
+				ChunkText:   c.Text,
+				Section:     c.Context,
+				ChunkIndex:  i,
+				StartOffset: start,
+				EndOffset:   start + utf8.RuneCountInString(c.Text),
+				Score:       1, // Equal scores keep document order
+			}
+		}
+
+		empty, err := s.ragMessages(ctx, query, "")
+		if err != nil {
+			middleware.AddSpanError(ctx, err)
+			return nil, err
+		}
+		wholeDoc := s.prompts.PackContext(results, messageContents(empty)...)
+		if len(results) == 0 || wholeDoc.Truncated() {
+			middleware.AddSpanError(ctx, ErrDocumentNotIndexed)
+			return nil, ErrDocumentNotIndexed
+		}
+		packed, whole = &wholeDoc, true
+	}
+	span.SetAttributes(attribute.Bool("whole_document", whole))
+
+	messages, err := s.ragMessages(ctx, query, packed.Text)
 	if err != nil {
 		middleware.AddSpanError(ctx, err)
-		return "", fmt.Errorf("failed to generate summary: %w", err)
+		return nil, err
 	}
 
-	return summary, nil
+	answer, err := s.chat.ChatCompletion(ctx, answerTask(query, packed), messages)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, fmt.Errorf("failed to get completion: %w", err)
+	}
+
+	result := s.completeAnswer(ctx, packed, answer)
+	result.WholeDocument = whole
+	return result, nil
+}
+
+// retrieveContext embeds the query, retrieves the best chunks and packs them
+// into the token budget, after reserving room for the reserved prompt parts.
+// Returns nil if nothing relevant was found.
+func (s *RAGService) retrieveContext(ctx context.Context, query string, filters SearchFilters, maxChunks int, reserved ...string) (*PackedContext, error) {
+	// Step 1: Generate embedding for the query
+	// Learning: Convert question to same vector space as documents
+	queryEmbeddings, err := s.embedder.CreateEmbeddings(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query embedding: %w", err)
+	}
+
+	// Step 2: Semantic search to find relevant context
+	// Learning: Find most similar chunks using cosine similarity
+	// With a re-ranker, over-fetch so it has candidates to choose from
+	results, err := s.embRepo.SemanticSearch(ctx, queryEmbeddings[0], filters, s.rerank.Candidates(maxChunks))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	results = s.rerank.Apply(ctx, query, results, maxChunks)
+
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	// Step 3: Pack the best chunks into the token budget
+	// Learning: The budget covers the whole prompt, so the system message
+	// and the question are reserved before any context is added
+	empty, err := s.ragMessages(ctx, query, "")
+	if err != nil {
+		return nil, err
+	}
+	packed := s.prompts.PackContext(results, append(messageContents(empty), reserved...)...)
+	return &packed, nil
+}
+
+// completeAnswer records the finished query and assembles its RAGAnswer
+func (s *RAGService) completeAnswer(ctx context.Context, packed *PackedContext, answer string) *RAGAnswer {
+	middleware.AddSpanEvent(ctx, "rag_completed",
+		attribute.Int("context_chunks", len(packed.Sources)),
+		attribute.Int("context_tokens", packed.Tokens),
+		attribute.Int("dropped_chunks", packed.Dropped),
+		attribute.Int("answer_length", len(answer)),
+	)
+
+	// Verify citations against the chunks that were actually in the prompt
+	cited := citeAnswer(answer, packed.Sources)
+	if len(cited.Invalid) > 0 || !cited.Grounded {
+		middleware.AddSpanEvent(ctx, "citation_check_failed",
+			attribute.Int("invalid_citations", len(cited.Invalid)),
+			attribute.Bool("grounded", cited.Grounded),
+		)
+	}
+
+	return &RAGAnswer{
+		Answer:           answer,
+		Sources:          packed.Sources,
+		ContextTokens:    packed.Tokens,
+		Truncated:        packed.Truncated(),
+		DroppedChunks:    packed.Dropped,
+		Segments:         cited.Segments,
+		Citations:        cited.Citations,
+		InvalidCitations: cited.Invalid,
+		Grounded:         cited.Grounded,
+	}
 }
 
 // ExtractKeywords extracts key topics and keywords from text
@@ -152,14 +335,21 @@ func (s *RAGService) ExtractKeywords(ctx context.Context, content string, count
 	)
 	defer span.End()
 
-	prompt := fmt.Sprintf(
-		"Extract %d key topics or keywords from the following text. Return only the keywords separated by commas:\n\n%s",
-		count,
-		content,
-	)
+	data := prompts.Keywords{Count: count, Content: content}
+	system, err := s.prompts.Render(ctx, prompts.KeywordsSystem, data)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+	prompt, err := s.prompts.Render(ctx, prompts.KeywordsUser, data)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
 
-	response, err := s.openaiClient.ChatCompletion(ctx, []openai.ChatMessage{
-		{Role: "system", Content: "You are a helpful assistant that extracts key topics and keywords."},
+	task := openai.ChatTask{Kind: openai.TaskKeywords, Text: content, Count: count}
+	response, err := s.chat.ChatCompletion(ctx, task, []openai.ChatMessage{
+		{Role: "system", Content: system},
 		{Role: "user", Content: prompt},
 	})
 	if err != nil {
@@ -188,14 +378,14 @@ func (s *RAGService) RelatedDocuments(ctx context.Context, documentContent strin
 	defer span.End()
 
 	// Generate embedding for the document
-	embedding, err := s.openaiClient.CreateEmbeddings([]string{documentContent})
+	embeddings, err := s.embedder.CreateEmbeddings(ctx, []string{documentContent})
 	if err != nil {
 		middleware.AddSpanError(ctx, err)
 		return nil, fmt.Errorf("failed to create embedding: %w", err)
 	}
 
 	// Find similar documents
-	results, err := s.embRepo.SemanticSearch(ctx, embedding, limit)
+	results, err := s.embRepo.SemanticSearch(ctx, embeddings[0], SearchFilters{}, limit)
 	if err != nil {
 		middleware.AddSpanError(ctx, err)
 		return nil, fmt.Errorf("failed to search: %w", err)
@@ -204,14 +394,35 @@ func (s *RAGService) RelatedDocuments(ctx context.Context, documentContent strin
 	return results, nil
 }
 
-// buildRAGPrompt constructs the prompt for RAG
-func buildRAGPrompt(query, context string) string {
-	return fmt.Sprintf(`Based on the following context, please answer the question. If the context doesn't contain enough information to answer, say so.
+// ragMessages renders the system and user prompts for a RAG query
+func (s *RAGService) ragMessages(ctx context.Context, query, contextText string) ([]openai.ChatMessage, error) {
+	data := prompts.RAG{Query: query, Context: contextText}
+
+	system, err := s.prompts.Render(ctx, prompts.RAGSystem, data)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.prompts.Render(ctx, prompts.RAGUser, data)
+	if err != nil {
+		return nil, err
+	}
 
-Context:
-%s
+	return []openai.ChatMessage{
+		{Role: "system", Content: system},
+		{Role: "user", Content: user},
+	}, nil
+}
 
-Question: %s
+// answerTask describes a RAG answer for the chat model
+func answerTask(query string, packed *PackedContext) openai.ChatTask {
+	return openai.ChatTask{Kind: openai.TaskAnswer, Query: query, Passages: packed.Chunks}
+}
 
-Answer:`, context, query)
+// messageContents returns the text of each message, for token budgeting
+func messageContents(messages []openai.ChatMessage) []string {
+	contents := make([]string, len(messages))
+	for i, msg := range messages {
+		contents[i] = msg.Content
+	}
+	return contents
 }
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+
+	"ai-kms/internal/middleware"
+	"ai-kms/internal/models"
+	"ai-kms/internal/openai"
+	"ai-kms/internal/prompts"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+LEARNING: TWO-STAGE RETRIEVAL
+
+Vector search is fast but coarse: it compares one vector per chunk with one
+vector for the query. A re-ranker looks at the query and each chunk TOGETHER,
+which is far more accurate but too slow to run over the whole index.
+
+So we do both:
+
+  1. RETRIEVE  top 4×k candidates with vector search   (cheap, high recall)
+  2. RE-RANK   score each candidate against the query  (expensive, precise)
+  3. MMR       pick k that are relevant AND different   (no duplicate quotes)
+
+Maximal Marginal Relevance picks one chunk at a time, maximizing
+
+  λ · relevance(chunk) − (1 − λ) · max similarity(chunk, already picked)
+
+so the third copy of the same paragraph loses to a slightly less relevant
+chunk that adds something new.
+*/
+
+// Reranker scores candidates against a query
+// Returns one score in [0, 1] per candidate, in the same order
+type Reranker interface {
+	Rerank(ctx context.Context, query string, candidates []*models.SearchResult) ([]float32, error)
+}
+
+// RerankStage over-fetches candidates, re-scores them and diversifies the result
+type RerankStage struct {
+	reranker  Reranker
+	overfetch int     // Candidates fetched per wanted chunk
+	lambda    float64 // MMR trade-off: 1 = relevance only, 0 = diversity only
+}
+
+const duplicateSimilarity = 0.9 // Chunks this similar to a picked one are dropped outright
+
+// NewRerankStage creates a re-ranking stage
+func NewRerankStage(reranker Reranker, overfetch int, lambda float64) *RerankStage {
+	if overfetch < 1 {
+		overfetch = 4
+	}
+	if lambda <= 0 || lambda > 1 {
+		lambda = 0.7
+	}
+	return &RerankStage{reranker: reranker, overfetch: overfetch, lambda: lambda}
+}
+
+// Candidates returns how many results to retrieve to end up with k
+func (st *RerankStage) Candidates(k int) int {
+	if st == nil {
+		return k
+	}
+	return k * st.overfetch
+}
+
+// Apply re-scores candidates and returns up to k relevant, non-redundant results
+// Result scores are replaced by the re-ranker's scores
+func (st *RerankStage) Apply(ctx context.Context, query string, candidates []*models.SearchResult, k int) []*models.SearchResult {
+	if st == nil || len(candidates) == 0 {
+		return candidates[:min(k, len(candidates))]
+	}
+
+	ctx, span := middleware.StartSpan(ctx, "RAG.Rerank",
+		attribute.Int("candidates", len(candidates)),
+		attribute.Int("k", k),
+	)
+	defer span.End()
+
+	scores, err := st.reranker.Rerank(ctx, query, candidates)
+	if err != nil || len(scores) != len(candidates) {
+		// Re-ranking is an improvement, not a requirement - keep retrieval order
+		if err == nil {
+			err = fmt.Errorf("reranker returned %d scores for %d candidates", len(scores), len(candidates))
+		}
+		middleware.AddSpanError(ctx, err)
+		log.Printf("⚠️  Re-ranking failed, using retrieval order: %v", err)
+		scores = make([]float32, len(candidates))
+		for i, c := range candidates {
+			scores[i] = c.Score
+		}
+	}
+
+	reranked := make([]*models.SearchResult, len(candidates))
+	for i, c := range candidates {
+		copied := *c
+		copied.Score = scores[i]
+		reranked[i] = &copied
+	}
+
+	selected := mmr(reranked, k, st.lambda)
+	span.SetAttributes(attribute.Int("selected", len(selected)))
+	return selected
+}
+
+// mmr greedily selects up to k results by maximal marginal relevance
+func mmr(candidates []*models.SearchResult, k int, lambda float64) []*models.SearchResult {
+	shingles := make([]map[string]bool, len(candidates))
+	for i, c := range candidates {
+		shingles[i] = wordShingles(c.ChunkText)
+	}
+
+	used := make([]bool, len(candidates))
+	var selected []int
+
+	for len(selected) < k {
+		best, bestValue := -1, math.Inf(-1)
+		for i, c := range candidates {
+			if used[i] {
+				continue
+			}
+
+			maxSim := 0.0
+			for _, j := range selected {
+				maxSim = math.Max(maxSim, jaccard(shingles[i], shingles[j]))
+			}
+			if maxSim >= duplicateSimilarity {
+				used[i] = true // Near-duplicate of something already picked
+				continue
+			}
+
+			value := lambda*float64(c.Score) - (1-lambda)*maxSim
+			if value > bestValue {
+				best, bestValue = i, value
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[best] = true
+		selected = append(selected, best)
+	}
+
+	results := make([]*models.SearchResult, len(selected))
+	for i, idx := range selected {
+		results[i] = candidates[idx]
+	}
+	return results
+}
+
+// wordShingles returns the set of consecutive word pairs in text
+// Learning: Pairs capture word order, so two chunks sharing vocabulary but
+// saying different things score lower than true copies
+func wordShingles(text string) map[string]bool {
+	words := strings.FieldsFunc(strings.ToLower(text), isWordSeparator)
+	set := make(map[string]bool, len(words))
+	if len(words) == 1 {
+		set[words[0]] = true
+	}
+	for i := 0; i+1 < len(words); i++ {
+		set[words[i]+" "+words[i+1]] = true
+	}
+	return set
+}
+
+// jaccard is |a ∩ b| / |a ∪ b|
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	inter := 0
+	for s := range a {
+		if b[s] {
+			inter++
+		}
+	}
+	return float64(inter) / float64(len(a)+len(b)-inter)
+}
+
+// LexicalReranker scores candidates by query term coverage - no model needed
+// Learning: Good enough offline, and it rescues exact-identifier matches
+// that vector search ranked low
+type LexicalReranker struct{}
+
+// Rerank scores each candidate by the share of query terms it contains,
+// with a small bonus for repeated mentions
+func (LexicalReranker) Rerank(ctx context.Context, query string, candidates []*models.SearchResult) ([]float32, error) {
+	terms := queryTerms(query)
+	scores := make([]float32, len(candidates))
+	if len(terms) == 0 {
+		for i, c := range candidates {
+			scores[i] = c.Score
+		}
+		return scores, nil
+	}
+
+	for i, c := range candidates {
+		words := strings.FieldsFunc(strings.ToLower(c.Section+" "+c.ChunkText), isWordSeparator)
+
+		covered, hits := 0, 0
+		for _, term := range terms {
+			found := 0
+			for _, w := range words {
+				if strings.HasPrefix(w, term) {
+					found++
+				}
+			}
+			if found > 0 {
+				covered++
+				hits += found
+			}
+		}
+
+		coverage := float64(covered) / float64(len(terms))
+		density := 1 - 1/(1+float64(hits)/float64(len(terms))) // 0 → 0, grows towards 1
+		// Blend with the retrieval score so semantic matches without shared words survive
+		scores[i] = float32(0.6*coverage + 0.2*density + 0.2*float64(c.Score))
+	}
+	return scores, nil
+}
+
+// LLMReranker asks the chat model to grade each candidate's relevance
+type LLMReranker struct {
+	chat      ChatModel
+	templates *prompts.Registry
+}
+
+// NewLLMReranker creates a re-ranker backed by a chat model
+// A nil registry uses the built-in templates
+func NewLLMReranker(chat ChatModel, templates *prompts.Registry) *LLMReranker {
+	if templates == nil {
+		templates = prompts.Defaults()
+	}
+	return &LLMReranker{chat: chat, templates: templates}
+}
+
+const rerankPassageChars = 1200 // Passages are cut to keep the grading prompt small
+
+var jsonArrayPattern = regexp.MustCompile(`\[[^\[\]]*\]`)
+
+// Rerank grades all candidates in one completion, 0-10 each
+func (r *LLMReranker) Rerank(ctx context.Context, query string, candidates []*models.SearchResult) ([]float32, error) {
+	data := prompts.Rerank{Query: query, Passages: make([]prompts.RerankPassage, len(candidates))}
+	for i, c := range candidates {
+		text := c.ChunkText
+		if runes := []rune(text); len(runes) > rerankPassageChars {
+			text = string(runes[:rerankPassageChars]) + "…"
+		}
+		data.Passages[i] = prompts.RerankPassage{Number: i + 1, Text: text}
+	}
+
+	system, err := r.templates.Render(ctx, prompts.RerankSystem, data)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := r.templates.Render(ctx, prompts.RerankUser, data)
+	if err != nil {
+		return nil, err
+	}
+
+	task := openai.ChatTask{Kind: openai.TaskRerank, Query: query, Passages: make([]string, len(data.Passages))}
+	for i, p := range data.Passages {
+		task.Passages[i] = p.Text
+	}
+	response, err := r.chat.ChatCompletion(ctx, task, []openai.ChatMessage{
+		{Role: "system", Content: system},
+		{Role: "user", Content: prompt},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rerank: %w", err)
+	}
+
+	// Models sometimes wrap the array in prose or code fences - take the first array
+	var grades []float64
+	if err := json.Unmarshal([]byte(jsonArrayPattern.FindString(response)), &grades); err != nil {
+		return nil, fmt.Errorf("failed to parse rerank scores %q: %w", response, err)
+	}
+	if len(grades) != len(candidates) {
+		return nil, fmt.Errorf("reranker graded %d of %d passages", len(grades), len(candidates))
+	}
+
+	scores := make([]float32, len(grades))
+	for i, g := range grades {
+		scores[i] = float32(math.Min(math.Max(g/10, 0), 1))
+	}
+	return scores, nil
+}
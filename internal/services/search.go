@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"ai-kms/internal/middleware"
+	"ai-kms/internal/models"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+LEARNING: HYBRID SEARCH
+
+Vector search and keyword search fail in opposite ways:
+
+  query "ERR_PGBOUNCER_42"   semantic: finds chunks "about connection errors" ✗
+                             keyword:  finds the exact code                   ✓
+  query "db keeps dropping"  semantic: finds "connection resets" runbook      ✓
+                             keyword:  no chunk contains "dropping"           ✗
+
+Hybrid mode runs both and merges the rankings (see HybridSearch), so either
+kind of match can surface a chunk.
+*/
+
+// ErrInvalidCursor is returned for a malformed pagination cursor
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SearchMode selects how chunks are matched
+type SearchMode string
+
+const (
+	SearchModeSemantic SearchMode = "semantic" // pgvector cosine similarity
+	SearchModeKeyword  SearchMode = "keyword"  // Postgres full-text search
+	SearchModeHybrid   SearchMode = "hybrid"   // Both, fused with reciprocal rank fusion
+)
+
+// ParseSearchMode validates a mode string; empty means the default
+func ParseSearchMode(mode string) (SearchMode, error) {
+	switch m := SearchMode(mode); m {
+	case "", SearchModeSemantic, SearchModeKeyword, SearchModeHybrid:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown search mode %q (expected semantic, keyword or hybrid)", mode)
+	}
+}
+
+// SearchServiceImpl implements semantic, keyword and hybrid search
+type SearchServiceImpl struct {
+	embedder    Embedder
+	embRepo     EmbeddingRepository
+	defaultMode SearchMode
+	weights     models.HybridWeights
+}
+
+// NewSearchService creates a search service
+// Learning: Zero weights fall back to the usual RRF defaults (1, 1, k=60)
+func NewSearchService(embedder Embedder, embRepo EmbeddingRepository, defaultMode SearchMode, weights models.HybridWeights) *SearchServiceImpl {
+	if defaultMode == "" {
+		defaultMode = SearchModeSemantic
+	}
+	if weights.Semantic <= 0 && weights.Keyword <= 0 {
+		weights.Semantic, weights.Keyword = 1, 1
+	}
+	if weights.K <= 0 {
+		weights.K = 60
+	}
+
+	return &SearchServiceImpl{
+		embedder:    embedder,
+		embRepo:     embRepo,
+		defaultMode: defaultMode,
+		weights:     weights,
+	}
+}
+
+// Search runs an unfiltered search in the default mode
+func (s *SearchServiceImpl) Search(ctx context.Context, query string, limit int) ([]*models.SearchResult, error) {
+	return s.SearchWithFilters(ctx, query, s.defaultMode, SearchFilters{}, limit)
+}
+
+// SearchWithFilters runs a search in the given mode, restricted to documents matching filters
+func (s *SearchServiceImpl) SearchWithFilters(ctx context.Context, query string, mode SearchMode, filters SearchFilters, limit int) ([]*models.SearchResult, error) {
+	if mode == "" {
+		mode = s.defaultMode
+	}
+
+	ctx, span := middleware.StartSpan(ctx, "Search.SearchWithFilters",
+		attribute.String("query", query),
+		attribute.String("mode", string(mode)),
+		attribute.Int("limit", limit),
+	)
+	defer span.End()
+
+	var results []*models.SearchResult
+	var err error
+
+	switch mode {
+	case SearchModeKeyword:
+		// No embedding needed - keyword search works even if the AI provider is down
+		results, err = s.embRepo.KeywordSearch(ctx, query, filters, limit)
+	case SearchModeSemantic, SearchModeHybrid:
+		var embeddings [][]float32
+		embeddings, err = s.embedder.CreateEmbeddings(ctx, []string{query})
+		if err != nil {
+			middleware.AddSpanError(ctx, err)
+			return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+		}
+
+		if mode == SearchModeHybrid {
+			results, err = s.embRepo.HybridSearch(ctx, embeddings[0], query, filters, limit, s.weights)
+		} else {
+			results, err = s.embRepo.SemanticSearch(ctx, embeddings[0], filters, limit)
+		}
+	default:
+		err = fmt.Errorf("unknown search mode %q", mode)
+	}
+
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+
+	// Results are sorted by score, so the minimum score just trims the tail
+	if filters.MinScore > 0 {
+		for i, result := range results {
+			if result.Score < filters.MinScore {
+				results = results[:i]
+				break
+			}
+		}
+	}
+
+	span.SetAttributes(attribute.Int("results", len(results)))
+	return results, nil
+}
+
+const (
+	maxSearchCandidates = 1000 // Upper bound on chunks fetched to build one page
+	groupOverfetch      = 5    // Chunks fetched per wanted document when grouping
+)
+
+// SearchOptions controls how search results are shaped into pages
+type SearchOptions struct {
+	Mode            SearchMode
+	Filters         SearchFilters
+	Limit           int    // Results per page
+	Cursor          string // From a previous page's NextCursor; empty for the first page
+	GroupByDocument bool   // One result per document: its best chunk plus a count of the others
+}
+
+// SearchPage is one page of search results
+type SearchPage struct {
+	Results    []*models.SearchResult
+	NextCursor string // Empty on the last page
+}
+
+// SearchPage runs a search and returns one page of highlighted results
+// Learning: The cursor is an opaque position in the ranking. Ranking is
+// deterministic for the same query and data, so page 2 continues page 1.
+func (s *SearchServiceImpl) SearchPage(ctx context.Context, query string, opts SearchOptions) (*SearchPage, error) {
+	offset, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one extra result to know whether there is a next page
+	want := offset + opts.Limit + 1
+	fetch := want
+	if opts.GroupByDocument {
+		fetch = want * groupOverfetch
+	}
+	fetch = min(fetch, maxSearchCandidates)
+
+	results, err := s.SearchWithFilters(ctx, query, opts.Mode, opts.Filters, fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.GroupByDocument {
+		results = groupByDocument(results)
+	}
+
+	page := &SearchPage{}
+	if offset < len(results) {
+		end := min(offset+opts.Limit, len(results))
+		page.Results = results[offset:end]
+		if len(results) > end {
+			page.NextCursor = encodeCursor(end)
+		}
+	}
+
+	for _, result := range page.Results {
+		result.Snippet = highlight(result.ChunkText, query)
+	}
+
+	return page, nil
+}
+
+// groupByDocument keeps the best chunk of each document, in ranking order,
+// counting the other matching chunks of that document
+func groupByDocument(results []*models.SearchResult) []*models.SearchResult {
+	best := make(map[string]*models.SearchResult)
+	var grouped []*models.SearchResult
+
+	for _, result := range results {
+		if first, ok := best[result.DocumentID]; ok {
+			first.OtherMatches++
+			continue
+		}
+		best[result.DocumentID] = result
+		grouped = append(grouped, result)
+	}
+	return grouped
+}
+
+// encodeCursor turns a result offset into an opaque cursor
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// decodeCursor parses a cursor from encodeCursor; empty means the first page
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	value, ok := strings.CutPrefix(string(raw), "offset:")
+	offset, err := strconv.Atoi(value)
+	if !ok || err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
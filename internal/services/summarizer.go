@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"ai-kms/internal/chunking"
+	"ai-kms/internal/middleware"
+	"ai-kms/internal/models"
+	"ai-kms/internal/openai"
+	"ai-kms/internal/prompts"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+LEARNING: MAP-REDUCE SUMMARIZATION
+
+A document longer than the context window can't be summarized in one call.
+Instead we summarize in layers:
+
+  chunks:     [c1 c2] [c3 c4] [c5 c6] [c7]     ← MAP: pack chunks into prompts
+                 ↓       ↓       ↓      ↓
+  summaries:    s1      s2      s3     s4
+                 ↓───────↓       ↓──────↓      ← REDUCE: summarize the summaries,
+                    s12             s34           repeating until one is left
+                     ↓───────────────↓
+                          final
+
+Chunks come from the embeddings table when they still match the document,
+so the chunking work done for search is reused.
+
+Section mode runs the same process per heading path (or JSON path), then
+reduces the section summaries into an overall one.
+
+Results are cached by content hash, prompt set and chat model: summarizing
+an unchanged document again is a single SELECT instead of N completions.
+*/
+
+const summaryConcurrency = 4 // Completions in flight per summarization
+
+// SummaryOptions controls document summarization
+type SummaryOptions struct {
+	MaxWords int
+	Mode     models.SummaryMode
+	Refresh  bool // Ignore the cache and regenerate
+}
+
+// SummarizeDocument summarizes a document of any length using map-reduce
+func (s *RAGService) SummarizeDocument(ctx context.Context, doc *models.Document, opts SummaryOptions) (*models.DocumentSummary, error) {
+	if opts.Mode == "" {
+		opts.Mode = models.SummaryModeOverall
+	}
+
+	ctx, span := middleware.StartSpan(ctx, "RAG.SummarizeDocument",
+		attribute.String("document_id", doc.ID),
+		attribute.Int("content_length", len(doc.Content)),
+		attribute.Int("max_words", opts.MaxWords),
+		attribute.String("mode", string(opts.Mode)),
+	)
+	defer span.End()
+
+	contentHash := doc.ContentHash
+	if contentHash == "" {
+		contentHash = models.HashContent(doc.Content)
+	}
+
+	// Learning: Another prompt set or model writes another summary - each
+	// gets its own cache entry, so a refresh with a custom set doesn't
+	// replace what everyone else is served
+	promptSet, chatModel := prompts.SetFrom(ctx), s.chat.ChatModelName()
+
+	if !opts.Refresh {
+		cached, err := s.summaryRepo.Get(ctx, doc.ID, contentHash, opts.Mode, opts.MaxWords, promptSet, chatModel)
+		if err != nil {
+			middleware.AddSpanError(ctx, err)
+			return nil, err
+		}
+		if cached != nil {
+			span.SetAttributes(attribute.Bool("cached", true))
+			cached.Cached = true
+			return cached, nil
+		}
+	}
+
+	chunks, err := s.documentChunks(ctx, doc)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+
+	summary := &models.DocumentSummary{
+		DocumentID:  doc.ID,
+		ContentHash: contentHash,
+		Mode:        opts.Mode,
+		MaxWords:    opts.MaxWords,
+		PromptSet:   promptSet,
+		ChatModel:   chatModel,
+		ChunkCount:  len(chunks),
+	}
+
+	switch opts.Mode {
+	case models.SummaryModeSections:
+		sections, err := s.summarizeSections(ctx, chunks, opts.MaxWords)
+		if err != nil {
+			middleware.AddSpanError(ctx, err)
+			return nil, err
+		}
+		summary.Sections = sections
+		if len(sections) == 1 {
+			summary.Summary = sections[0].Summary
+			break
+		}
+
+		texts := make([]string, len(sections))
+		for i, sec := range sections {
+			texts[i] = sec.Section + ":\n" + sec.Summary
+		}
+		if summary.Summary, err = s.reduceSummaries(ctx, texts, opts.MaxWords); err != nil {
+			middleware.AddSpanError(ctx, err)
+			return nil, err
+		}
+	default:
+		texts := make([]string, len(chunks))
+		for i, c := range chunks {
+			texts[i] = c.Text
+		}
+		if summary.Summary, err = s.reduceSummaries(ctx, texts, opts.MaxWords); err != nil {
+			middleware.AddSpanError(ctx, err)
+			return nil, err
+		}
+	}
+
+	if err := s.summaryRepo.Save(ctx, summary); err != nil {
+		// The summary is still good - just not cached
+		middleware.AddSpanError(ctx, err)
+	}
+
+	return summary, nil
+}
+
+// DeleteSummaries drops every cached summary of a document
+func (s *RAGService) DeleteSummaries(ctx context.Context, documentID string) error {
+	return s.summaryRepo.DeleteByDocumentID(ctx, documentID)
+}
+
+// SummarizeText summarizes text in a single completion
+// Content beyond the token budget is cut off; the bool reports whether that happened
+func (s *RAGService) SummarizeText(ctx context.Context, content string, maxLength int) (string, bool, error) {
+	ctx, span := middleware.StartSpan(ctx, "RAG.SummarizeText",
+		attribute.Int("content_length", len(content)),
+		attribute.Int("max_length", maxLength),
+	)
+	defer span.End()
+
+	empty, err := s.summaryMessages(ctx, maxLength, "")
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return "", false, err
+	}
+	content, truncated := s.prompts.FitDocument(content, messageContents(empty)...)
+	span.SetAttributes(attribute.Bool("truncated", truncated))
+
+	messages, err := s.summaryMessages(ctx, maxLength, content)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return "", false, err
+	}
+
+	summary, err := s.chat.ChatCompletion(ctx, summaryTask(content), messages)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return "", false, fmt.Errorf("failed to generate summary: %w", err)
+	}
+
+	return summary, truncated, nil
+}
+
+// documentChunks returns the document's stored chunks if they match its
+// current content, and chunks it from scratch otherwise
+func (s *RAGService) documentChunks(ctx context.Context, doc *models.Document) ([]chunking.Chunk, error) {
+	stored, err := s.embRepo.GetEmbeddingsByDocumentID(ctx, doc.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Learning: Offsets point into the content the chunks were made from, so
+	// if every chunk still lines up the index is current for this version
+	chunks := make([]chunking.Chunk, 0, len(stored))
+	for _, e := range stored {
+		if e.EndOffset <= e.StartOffset || e.EndOffset > len(doc.Content) ||
+			doc.Content[e.StartOffset:e.EndOffset] != e.ChunkText {
+			chunks = nil
+			break
+		}
+		chunks = append(chunks, chunking.Chunk{
+			Text:        e.ChunkText,
+			Context:     e.Section,
+			StartOffset: e.StartOffset,
+			EndOffset:   e.EndOffset,
+		})
+	}
+	if len(chunks) > 0 {
+		return chunks, nil
+	}
+
+	return chunking.ForFormat(doc.Format, s.chunkOpts).Chunk(doc.Content), nil
+}
+
+// summarizeSections reduces each section's chunks to one summary, in document order
+func (s *RAGService) summarizeSections(ctx context.Context, chunks []chunking.Chunk, maxWords int) ([]models.SectionSummary, error) {
+	var order []string
+	texts := make(map[string][]string)
+	for _, c := range chunks {
+		if _, ok := texts[c.Context]; !ok {
+			order = append(order, c.Context)
+		}
+		texts[c.Context] = append(texts[c.Context], c.Text)
+	}
+
+	sections := make([]models.SectionSummary, len(order))
+	for i, name := range order {
+		summary, err := s.reduceSummaries(ctx, texts[name], maxWords)
+		if err != nil {
+			return nil, fmt.Errorf("failed to summarize section %q: %w", name, err)
+		}
+		sections[i] = models.SectionSummary{Section: name, Summary: summary}
+	}
+	return sections, nil
+}
+
+// reduceSummaries summarizes texts in batches that fit the prompt budget,
+// then summarizes those summaries, until a single summary remains
+func (s *RAGService) reduceSummaries(ctx context.Context, texts []string, maxWords int) (string, error) {
+	if len(texts) == 0 {
+		return "", nil
+	}
+
+	empty, err := s.summaryMessages(ctx, maxWords, "")
+	if err != nil {
+		return "", err
+	}
+
+	for level := 0; ; level++ {
+		batches := s.prompts.Batches(texts, messageContents(empty)...)
+		middleware.AddSpanEvent(ctx, "summary_level",
+			attribute.Int("level", level),
+			attribute.Int("inputs", len(texts)),
+			attribute.Int("batches", len(batches)),
+		)
+
+		summaries, err := s.summarizeBatches(ctx, batches, maxWords)
+		if err != nil {
+			return "", err
+		}
+		if len(summaries) == 1 {
+			return summaries[0], nil
+		}
+
+		// Summaries are much shorter than their inputs, so each level has
+		// fewer batches; a level that can't shrink is forced into one prompt
+		if len(summaries) >= len(texts) {
+			summary, _, err := s.SummarizeText(ctx, strings.Join(summaries, "\n\n"), maxWords)
+			return summary, err
+		}
+		texts = summaries
+	}
+}
+
+// summarizeBatches runs one completion per batch, a few at a time
+func (s *RAGService) summarizeBatches(ctx context.Context, batches []string, maxWords int) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	summaries := make([]string, len(batches))
+	sem := make(chan struct{}, summaryConcurrency)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for i, batch := range batches {
+		wg.Add(1)
+		go func(i int, batch string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			messages, err := s.summaryMessages(ctx, maxWords, batch)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+
+			summary, err := s.chat.ChatCompletion(ctx, summaryTask(batch), messages)
+			if err != nil {
+				// Keep the root cause, then stop the other batches
+				once.Do(func() {
+					firstErr = fmt.Errorf("failed to summarize part %d of %d: %w", i+1, len(batches), err)
+					cancel()
+				})
+				return
+			}
+			summaries[i] = summary
+		}(i, batch)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return summaries, nil
+}
+
+// summaryTask describes a summary for the chat model
+func summaryTask(content string) openai.ChatTask {
+	return openai.ChatTask{Kind: openai.TaskSummarize, Text: content}
+}
+
+// summaryMessages renders the system and user prompts for one summary call
+func (s *RAGService) summaryMessages(ctx context.Context, maxWords int, content string) ([]openai.ChatMessage, error) {
+	data := prompts.Summary{MaxWords: maxWords, Content: content}
+
+	system, err := s.prompts.Render(ctx, prompts.SummarySystem, data)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.prompts.Render(ctx, prompts.SummaryUser, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return []openai.ChatMessage{
+		{Role: "system", Content: system},
+		{Role: "user", Content: user},
+	}, nil
+}
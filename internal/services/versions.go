@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"ai-kms/internal/diff"
+	"ai-kms/internal/middleware"
+	"ai-kms/internal/models"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+LEARNING: VERSION HISTORY
+
+An update overwrites documents.content, and Yjs updates are merged and
+garbage collected - neither keeps earlier states. So every change also
+writes a full snapshot to document_versions:
+
+  create          → version (create)
+  PUT /documents  → version (edit)          author from the request
+  live editing    → version (collaboration) every COLLAB_VERSION_INTERVAL,
+                                            authors = everyone who typed
+  restore         → version (restore)       restored_from = the old version
+
+Full snapshots cost more space than deltas, but any version can be read or
+diffed without replaying anything. Saving a state equal to the newest
+version records nothing, so re-saves don't clutter the history.
+*/
+
+// ErrVersionNotFound means the document has no version with the given ID
+var ErrVersionNotFound = errors.New("version not found")
+
+// CurrentVersion names the document's current state when diffing
+const CurrentVersion = "current"
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// VersionService records and restores document versions
+type VersionService struct {
+	docs     DocumentRepository
+	versions VersionRepository
+}
+
+// NewVersionService creates a version service
+func NewVersionService(docs DocumentRepository, versions VersionRepository) *VersionService {
+	return &VersionService{docs: docs, versions: versions}
+}
+
+// Record stores the document's current state as a version
+// Returns the latest version instead when nothing changed since
+func (s *VersionService) Record(ctx context.Context, doc *models.Document, author string, source models.VersionSource) (*models.DocumentVersion, error) {
+	return s.record(ctx, doc, author, source, nil)
+}
+
+func (s *VersionService) record(ctx context.Context, doc *models.Document, author string, source models.VersionSource, restoredFrom *string) (*models.DocumentVersion, error) {
+	ctx, span := middleware.StartSpan(ctx, "Versions.Record",
+		attribute.String("document.id", doc.ID),
+		attribute.String("version.source", string(source)),
+	)
+	defer span.End()
+
+	latest, err := s.versions.Latest(ctx, doc.ID)
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+	if latest != nil && latest.ContentHash == doc.ContentHash && latest.Title == doc.Title && latest.Format == doc.Format {
+		middleware.AddSpanEvent(ctx, "unchanged")
+		return latest, nil
+	}
+
+	version := &models.DocumentVersion{
+		DocumentID:   doc.ID,
+		Title:        doc.Title,
+		Content:      doc.Content,
+		Format:       doc.Format,
+		ContentHash:  doc.ContentHash,
+		Author:       author,
+		Source:       source,
+		RestoredFrom: restoredFrom,
+	}
+	if err := s.versions.Create(ctx, version); err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, err
+	}
+	return version, nil
+}
+
+// EnsureBaseline records the document's state if it has no versions yet
+// Learning: Call before changing a document - for documents older than
+// version history, this keeps the state the first edit overwrites
+func (s *VersionService) EnsureBaseline(ctx context.Context, doc *models.Document) error {
+	latest, err := s.versions.Latest(ctx, doc.ID)
+	if err != nil || latest != nil {
+		return err
+	}
+	_, err = s.record(ctx, doc, "", models.VersionSourceBaseline, nil)
+	return err
+}
+
+// List returns a document's versions, newest first, without their content
+func (s *VersionService) List(ctx context.Context, documentID string, limit, offset int) ([]*models.DocumentVersion, error) {
+	if _, err := s.docs.GetByID(ctx, documentID); err != nil {
+		return nil, err
+	}
+	return s.versions.List(ctx, documentID, limit, offset)
+}
+
+// Get returns one version with its content
+func (s *VersionService) Get(ctx context.Context, documentID, id string) (*models.DocumentVersion, error) {
+	version, err := s.versions.GetByID(ctx, documentID, id)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, ErrVersionNotFound
+	}
+	return version, nil
+}
+
+// Diff compares the content of two versions line by line
+// to may be CurrentVersion (or empty) to compare against the document as it is now
+func (s *VersionService) Diff(ctx context.Context, documentID, from, to string) (*models.VersionDiff, error) {
+	ctx, span := middleware.StartSpan(ctx, "Versions.Diff",
+		attribute.String("document.id", documentID),
+	)
+	defer span.End()
+
+	if to == "" {
+		to = CurrentVersion
+	}
+
+	old, err := s.Get(ctx, documentID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	var newContent string
+	if to == CurrentVersion {
+		doc, err := s.docs.GetByID(ctx, documentID)
+		if err != nil {
+			return nil, err
+		}
+		newContent = doc.Content
+	} else {
+		version, err := s.Get(ctx, documentID, to)
+		if err != nil {
+			return nil, err
+		}
+		newContent = version.Content
+	}
+
+	edits := diff.Lines(diff.SplitLines(old.Content), diff.SplitLines(newContent))
+	added, removed := diff.Stats(edits)
+	span.SetAttributes(attribute.Int("diff.added", added), attribute.Int("diff.removed", removed))
+
+	return &models.VersionDiff{
+		DocumentID: documentID,
+		From:       from,
+		To:         to,
+		Added:      added,
+		Removed:    removed,
+		Diff:       diff.Unified(edits, from, to, diffContext),
+	}, nil
+}
+
+// Restore makes an earlier version the document's current state
+// Learning: Restoring is an edit like any other - it's recorded as a new
+// version, so the restore itself can be undone
+func (s *VersionService) Restore(ctx context.Context, documentID, id, author string) (*models.Document, *models.DocumentVersion, error) {
+	ctx, span := middleware.StartSpan(ctx, "Versions.Restore",
+		attribute.String("document.id", documentID),
+		attribute.String("version.id", id),
+	)
+	defer span.End()
+
+	version, err := s.Get(ctx, documentID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := s.docs.Update(ctx, documentID, &models.DocumentUpdate{
+		Title:   &version.Title,
+		Content: &version.Content,
+		Format:  &version.Format,
+	})
+	if err != nil {
+		middleware.AddSpanError(ctx, err)
+		return nil, nil, err
+	}
+
+	restored, err := s.record(ctx, doc, author, models.VersionSourceRestore, &version.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, restored, nil
+}
+
+// DeleteHistory removes every version of a document
+func (s *VersionService) DeleteHistory(ctx context.Context, documentID string) error {
+	return s.versions.DeleteByDocumentID(ctx, documentID)
+}
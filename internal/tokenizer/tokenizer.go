@@ -0,0 +1,242 @@
+package tokenizer
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+/*
+LEARNING: COUNTING TOKENS, NOT WORDS
+
+Models measure everything - context windows, chunk limits, pricing - in
+TOKENS. OpenAI's embedding and chat models (text-embedding-ada-002,
+gpt-3.5-turbo, gpt-4) all use the cl100k_base byte-pair encoding:
+
+  "Restart the pgbouncer pod" → ["Rest", "art", " the", " pg", "bou", "ncer", " pod"]
+
+A word count can be off by 2-3x for code, JSON or identifiers, which is
+exactly the content that overflows a context window.
+
+The BPE ranks (~1.7MB) are not bundled with the binary:
+  - CL100K(path) reads them from a local cl100k_base.tiktoken file (offline)
+  - CL100K("") downloads them once and caches them (TIKTOKEN_CACHE_DIR),
+    giving up after downloadTimeout - a firewalled host shouldn't hang startup
+
+When neither works, Estimator gives a conservative approximation so
+chunking and prompt budgeting keep working.
+*/
+
+// Tokenizer counts and truncates text in model tokens
+type Tokenizer interface {
+	Count(text string) int
+	Truncate(text string, maxTokens int) string
+}
+
+// BPE counts tokens with a real byte-pair encoding
+type BPE struct {
+	enc *tiktoken.Tiktoken
+}
+
+// downloadTimeout bounds fetching the BPE ranks when they aren't cached
+const downloadTimeout = 15 * time.Second
+
+// CL100K loads the cl100k_base encoding used by OpenAI's current models
+// If bpeFile is empty, the ranks are downloaded and cached on first use
+func CL100K(bpeFile string) (*BPE, error) {
+	if bpeFile != "" {
+		tiktoken.SetBpeLoader(fileLoader{path: bpeFile})
+	} else {
+		// Learning: tiktoken-go's own loader uses http.Get, which has no timeout
+		tiktoken.SetBpeLoader(downloadLoader{client: &http.Client{Timeout: downloadTimeout}})
+	}
+
+	enc, err := tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cl100k_base encoding: %w", err)
+	}
+	return &BPE{enc: enc}, nil
+}
+
+// Count returns the number of tokens in text
+func (b *BPE) Count(text string) int {
+	return len(b.enc.EncodeOrdinary(text))
+}
+
+// Truncate returns the longest prefix of text that fits maxTokens
+func (b *BPE) Truncate(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	tokens := b.enc.EncodeOrdinary(text)
+	if len(tokens) <= maxTokens {
+		return text
+	}
+
+	// A token can end in the middle of a multi-byte character - drop the partial rune
+	prefix := b.enc.Decode(tokens[:maxTokens])
+	for len(prefix) > 0 {
+		r, size := utf8.DecodeLastRuneInString(prefix)
+		if r != utf8.RuneError || size > 1 {
+			break
+		}
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
+
+// fileLoader reads BPE ranks from a local .tiktoken file instead of downloading them
+// Format: one "<base64 token> <rank>" pair per line
+type fileLoader struct {
+	path string
+}
+
+func (l fileLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	contents, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRanks(contents, l.path)
+}
+
+// downloadLoader fetches BPE ranks over HTTP with a bounded timeout, caching
+// them where tiktoken-go does so existing caches keep working
+type downloadLoader struct {
+	client *http.Client
+}
+
+func (l downloadLoader) LoadTiktokenBpe(url string) (map[string]int, error) {
+	cachePath := filepath.Join(cacheDir(), fmt.Sprintf("%x", sha1.Sum([]byte(url))))
+	if contents, err := os.ReadFile(cachePath); err == nil {
+		return parseRanks(contents, cachePath)
+	}
+
+	resp, err := l.client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download BPE ranks (set TOKENIZER_BPE_FILE to work offline): %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download BPE ranks: %s", resp.Status)
+	}
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download BPE ranks: %w", err)
+	}
+
+	ranks, err := parseRanks(contents, url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Best effort: write to a temp file first so a crash never leaves half a cache
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err == nil {
+		tmp := cachePath + ".tmp"
+		if err := os.WriteFile(tmp, contents, 0o644); err == nil {
+			os.Rename(tmp, cachePath)
+		}
+	}
+	return ranks, nil
+}
+
+// cacheDir is tiktoken-go's cache location
+func cacheDir() string {
+	if dir := os.Getenv("TIKTOKEN_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("DATA_GYM_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "data-gym-cache")
+}
+
+// parseRanks reads the .tiktoken format: one "<base64 token> <rank>" pair per line
+func parseRanks(contents []byte, source string) (map[string]int, error) {
+	ranks := make(map[string]int)
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed line in %s: %q", source, line)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("malformed token in %s: %w", source, err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(rank))
+		if err != nil {
+			return nil, fmt.Errorf("malformed rank in %s: %w", source, err)
+		}
+		ranks[string(decoded)] = n
+	}
+	return ranks, nil
+}
+
+// Estimator approximates token counts without a vocabulary
+// Learning: English prose averages ~4 tokens per 3 words, while code and
+// JSON average ~4 bytes per token; taking the larger errs on the safe side
+type Estimator struct{}
+
+// Count returns an upper-leaning estimate of the number of tokens in text
+func (Estimator) Count(text string) int {
+	byWords := (len(strings.Fields(text))*4 + 2) / 3
+	byBytes := (len(text) + 3) / 4
+	return max(byWords, byBytes)
+}
+
+// Truncate returns the longest prefix of text, ending on a word boundary, that fits maxTokens
+// Text whose first word doesn't fit (a base64 blob, minified JSON) is cut
+// inside the word, on a character boundary
+func (e Estimator) Truncate(text string, maxTokens int) string {
+	if e.Count(text) <= maxTokens {
+		return text
+	}
+
+	// Binary search over word end positions
+	var ends []int
+	inWord := false
+	for i, r := range text {
+		space := r == ' ' || r == '\t' || r == '\n' || r == '\r'
+		if space && inWord {
+			ends = append(ends, i)
+		}
+		inWord = !space
+	}
+
+	lo, hi := 0, len(ends) // ends[:lo] fit
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if e.Count(text[:ends[mid-1]]) <= maxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if lo > 0 {
+		return text[:ends[lo-1]]
+	}
+
+	// ~4 bytes per token is what Count charges for text without spaces
+	end := min(len(text), max(maxTokens, 0)*4)
+	for end > 0 && end < len(text) && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	prefix := text[:end]
+	for prefix != "" && e.Count(prefix) > maxTokens {
+		_, size := utf8.DecodeLastRuneInString(prefix)
+		prefix = prefix[:len(prefix)-size]
+	}
+	return prefix
+}
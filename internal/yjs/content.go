@@ -0,0 +1,288 @@
+package yjs
+
+import (
+	"fmt"
+	"unicode/utf16"
+)
+
+// Content type references (the low 5 bits of an item's info byte)
+const (
+	refGC      = 0
+	refDeleted = 1
+	refJSON    = 2
+	refBinary  = 3
+	refString  = 4
+	refEmbed   = 5
+	refFormat  = 6
+	refType    = 7
+	refAny     = 8
+	refDoc     = 9
+	refSkip    = 10
+)
+
+// Shared type references (ContentType)
+const (
+	TypeArray       = 0
+	TypeMap         = 1
+	TypeText        = 2
+	TypeXMLElement  = 3
+	TypeXMLFragment = 4
+	TypeXMLHook     = 5
+	TypeXMLText     = 6
+	typeUnknown     = -1 // Root types: the update doesn't say which kind they are
+)
+
+// content is the payload of an item
+// Learning: Length is counted in the units Yjs uses for positions - UTF-16
+// code units for strings, elements for arrays, 1 for everything else
+type content interface {
+	length() int
+	countable() bool // Counts towards the length of the parent type
+	splice(offset int) content
+	ref() byte
+	write(e *Encoder, offset int)
+}
+
+// contentDeleted replaces the payload of deleted items once garbage collected
+type contentDeleted struct{ n int }
+
+func (c *contentDeleted) length() int     { return c.n }
+func (c *contentDeleted) countable() bool { return false }
+func (c *contentDeleted) ref() byte       { return refDeleted }
+func (c *contentDeleted) splice(offset int) content {
+	right := &contentDeleted{n: c.n - offset}
+	c.n = offset
+	return right
+}
+func (c *contentDeleted) write(e *Encoder, offset int) { e.WriteVarUint(uint64(c.n - offset)) }
+
+// contentJSON holds values of the deprecated JSON content, kept as JSON text
+type contentJSON struct{ values []string }
+
+func (c *contentJSON) length() int     { return len(c.values) }
+func (c *contentJSON) countable() bool { return true }
+func (c *contentJSON) ref() byte       { return refJSON }
+func (c *contentJSON) splice(offset int) content {
+	right := &contentJSON{values: c.values[offset:]}
+	c.values = c.values[:offset:offset]
+	return right
+}
+func (c *contentJSON) write(e *Encoder, offset int) {
+	e.WriteVarUint(uint64(len(c.values) - offset))
+	for _, v := range c.values[offset:] {
+		e.WriteVarString(v)
+	}
+}
+
+// contentBinary holds one byte array
+type contentBinary struct{ data []byte }
+
+func (c *contentBinary) length() int               { return 1 }
+func (c *contentBinary) countable() bool           { return true }
+func (c *contentBinary) ref() byte                 { return refBinary }
+func (c *contentBinary) splice(offset int) content { panic("yjs: binary content can't be split") }
+func (c *contentBinary) write(e *Encoder, offset int) {
+	e.WriteVarBytes(c.data)
+}
+
+// contentString holds text as UTF-16 code units, like JavaScript strings
+type contentString struct{ units []uint16 }
+
+func newContentString(s string) *contentString {
+	return &contentString{units: utf16.Encode([]rune(s))}
+}
+
+func (c *contentString) length() int     { return len(c.units) }
+func (c *contentString) countable() bool { return true }
+func (c *contentString) ref() byte       { return refString }
+func (c *contentString) String() string  { return string(utf16.Decode(c.units)) }
+
+// splice splits the text, replacing a surrogate pair cut in half with
+// U+FFFD on both sides - the same thing Yjs does
+func (c *contentString) splice(offset int) content {
+	right := &contentString{units: append([]uint16(nil), c.units[offset:]...)}
+	c.units = c.units[:offset:offset]
+	if offset > 0 && utf16.IsSurrogate(rune(c.units[offset-1])) && c.units[offset-1] < 0xdc00 {
+		c.units[offset-1] = 0xfffd
+		right.units[0] = 0xfffd
+	}
+	return right
+}
+
+func (c *contentString) write(e *Encoder, offset int) {
+	e.WriteVarString(string(utf16.Decode(c.units[offset:])))
+}
+
+// contentEmbed holds an embedded object of a text (an image, a mention), as JSON
+type contentEmbed struct{ json string }
+
+func (c *contentEmbed) length() int               { return 1 }
+func (c *contentEmbed) countable() bool           { return true }
+func (c *contentEmbed) ref() byte                 { return refEmbed }
+func (c *contentEmbed) splice(offset int) content { panic("yjs: embed content can't be split") }
+func (c *contentEmbed) write(e *Encoder, offset int) {
+	e.WriteVarString(c.json)
+}
+
+// contentFormat starts (or, with a null value, ends) a formatting attribute of a text
+type contentFormat struct {
+	key   string
+	value string // JSON
+}
+
+func (c *contentFormat) length() int               { return 1 }
+func (c *contentFormat) countable() bool           { return false }
+func (c *contentFormat) ref() byte                 { return refFormat }
+func (c *contentFormat) splice(offset int) content { panic("yjs: format content can't be split") }
+func (c *contentFormat) write(e *Encoder, offset int) {
+	e.WriteVarString(c.key)
+	e.WriteVarString(c.value)
+}
+
+// contentType holds a nested shared type (an XML element inside a fragment, a map in an array)
+type contentType struct{ t *sharedType }
+
+func (c *contentType) length() int               { return 1 }
+func (c *contentType) countable() bool           { return true }
+func (c *contentType) ref() byte                 { return refType }
+func (c *contentType) splice(offset int) content { panic("yjs: type content can't be split") }
+func (c *contentType) write(e *Encoder, offset int) {
+	e.WriteVarUint(uint64(c.t.ref))
+	if c.t.ref == TypeXMLElement || c.t.ref == TypeXMLHook {
+		e.WriteVarString(c.t.name)
+	}
+}
+
+// contentAny holds JSON-like values inserted into arrays and maps
+type contentAny struct {
+	values []any
+	raw    [][]byte // Encoded values, written back unchanged
+}
+
+func (c *contentAny) length() int     { return len(c.values) }
+func (c *contentAny) countable() bool { return true }
+func (c *contentAny) ref() byte       { return refAny }
+func (c *contentAny) splice(offset int) content {
+	right := &contentAny{values: c.values[offset:], raw: c.raw[offset:]}
+	c.values = c.values[:offset:offset]
+	c.raw = c.raw[:offset:offset]
+	return right
+}
+func (c *contentAny) write(e *Encoder, offset int) {
+	e.WriteVarUint(uint64(len(c.values) - offset))
+	for _, raw := range c.raw[offset:] {
+		e.writeRaw(raw)
+	}
+}
+
+// contentDoc holds a reference to a subdocument
+type contentDoc struct {
+	guid string
+	opts []byte // Encoded options
+}
+
+func (c *contentDoc) length() int               { return 1 }
+func (c *contentDoc) countable() bool           { return true }
+func (c *contentDoc) ref() byte                 { return refDoc }
+func (c *contentDoc) splice(offset int) content { panic("yjs: doc content can't be split") }
+func (c *contentDoc) write(e *Encoder, offset int) {
+	e.WriteVarString(c.guid)
+	e.writeRaw(c.opts)
+}
+
+// readContent decodes the content of an item
+func readContent(d *Decoder, ref byte) (content, error) {
+	switch ref {
+	case refDeleted:
+		n, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		return &contentDeleted{n: int(n)}, nil
+	case refJSON:
+		n, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		c := &contentJSON{values: make([]string, 0, min(n, uint64(d.Remaining())))}
+		for i := uint64(0); i < n; i++ {
+			v, err := d.ReadVarString()
+			if err != nil {
+				return nil, err
+			}
+			c.values = append(c.values, v)
+		}
+		return c, nil
+	case refBinary:
+		b, err := d.ReadVarBytes()
+		if err != nil {
+			return nil, err
+		}
+		return &contentBinary{data: append([]byte(nil), b...)}, nil
+	case refString:
+		s, err := d.ReadVarString()
+		if err != nil {
+			return nil, err
+		}
+		return newContentString(s), nil
+	case refEmbed:
+		s, err := d.ReadVarString()
+		if err != nil {
+			return nil, err
+		}
+		return &contentEmbed{json: s}, nil
+	case refFormat:
+		key, err := d.ReadVarString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.ReadVarString()
+		if err != nil {
+			return nil, err
+		}
+		return &contentFormat{key: key, value: value}, nil
+	case refType:
+		typeRef, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		t := newSharedType(int(typeRef))
+		switch typeRef {
+		case TypeArray, TypeMap, TypeText, TypeXMLFragment, TypeXMLText:
+		case TypeXMLElement, TypeXMLHook:
+			if t.name, err = d.ReadVarString(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("yjs: unknown type ref %d", typeRef)
+		}
+		return &contentType{t: t}, nil
+	case refAny:
+		n, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		c := &contentAny{}
+		for i := uint64(0); i < n; i++ {
+			v, raw, err := d.readRawAny()
+			if err != nil {
+				return nil, err
+			}
+			c.values = append(c.values, v)
+			c.raw = append(c.raw, raw)
+		}
+		return c, nil
+	case refDoc:
+		guid, err := d.ReadVarString()
+		if err != nil {
+			return nil, err
+		}
+		_, opts, err := d.readRawAny()
+		if err != nil {
+			return nil, err
+		}
+		return &contentDoc{guid: guid, opts: opts}, nil
+	default:
+		return nil, fmt.Errorf("yjs: unknown content ref %d", ref)
+	}
+}
@@ -0,0 +1,680 @@
+package yjs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+/*
+LEARNING: HOW A YJS DOCUMENT IS STORED
+
+Every change a client makes is an ITEM with a unique ID: (client, clock).
+Each client counts its own clock up by the length of what it inserted, so
+"client 7 typed hello" is items 7:0..7:4 - usually one item of length 5.
+
+Items don't store positions. They store their neighbours at insert time:
+
+  origin      - the item that was on the left
+  rightOrigin - the item that was on the right
+
+and every replica re-runs the same conflict resolution (YATA) to place
+concurrent inserts between the same neighbours in the same order. That's
+what makes the merge deterministic without a server deciding anything.
+
+The STRUCT STORE keeps each client's items sorted by clock, which makes
+"everything client 7 did after clock 40" a binary search away. The STATE
+VECTOR - client → next expected clock - summarizes what a replica has seen.
+
+Deletions don't remove items (others may still reference them as origins);
+they are flagged, and sent around as a compact DELETE SET of clock ranges.
+Garbage collection then drops the deleted payload and keeps only the length.
+*/
+
+var (
+	// ErrMalformedUpdate means an update is internally inconsistent
+	ErrMalformedUpdate = errors.New("yjs: malformed update")
+	// ErrBroken means an update failed halfway through and the document must be rebuilt
+	ErrBroken = errors.New("yjs: document is inconsistent")
+)
+
+// maxClock is the largest clock an update may use
+// Learning: Yjs clocks are JavaScript numbers, exact up to 2^53
+const maxClock = 1<<53 - 1
+
+// ID identifies an item: the client that created it and its position in
+// that client's sequence of insertions
+type ID struct {
+	Client uint64
+	Clock  uint64
+}
+
+// structure is an entry of the struct store: an item, a garbage-collected
+// range, or (only in decoded updates) a skipped range
+type structure interface {
+	structID() ID
+	length() int
+	deleted() bool
+	write(e *Encoder, offset int)
+}
+
+// gc is a range of deleted items whose content was dropped
+type gc struct {
+	id ID
+	n  int
+}
+
+func (g *gc) structID() ID  { return g.id }
+func (g *gc) length() int   { return g.n }
+func (g *gc) deleted() bool { return true }
+func (g *gc) write(e *Encoder, offset int) {
+	e.WriteUint8(refGC)
+	e.WriteVarUint(uint64(g.n - offset))
+}
+
+// skip is a gap in an update - the range is missing, not deleted
+type skip struct {
+	id ID
+	n  int
+}
+
+func (s *skip) structID() ID  { return s.id }
+func (s *skip) length() int   { return s.n }
+func (s *skip) deleted() bool { return true }
+func (s *skip) write(e *Encoder, offset int) {
+	e.WriteUint8(refSkip)
+	e.WriteVarUint(uint64(s.n - offset))
+}
+
+// item is one insertion into a shared type
+type item struct {
+	id          ID
+	n           int
+	origin      *ID // Left neighbour at insert time
+	rightOrigin *ID // Right neighbour at insert time
+	left, right *item
+	parent      *sharedType
+	parentSub   *string // Key, for map entries
+	content     content
+	isDeleted   bool
+
+	// Parent as decoded, until the item is integrated
+	parentKey *string // Root type name
+	parentID  *ID     // Item holding the parent type
+}
+
+func (it *item) structID() ID  { return it.id }
+func (it *item) length() int   { return it.n }
+func (it *item) deleted() bool { return it.isDeleted }
+
+func (it *item) write(e *Encoder, offset int) {
+	origin := it.origin
+	if offset > 0 {
+		origin = &ID{Client: it.id.Client, Clock: it.id.Clock + uint64(offset) - 1}
+	}
+
+	info := it.content.ref()
+	if origin != nil {
+		info |= 0x80
+	}
+	if it.rightOrigin != nil {
+		info |= 0x40
+	}
+	if it.parentSub != nil {
+		info |= 0x20
+	}
+	e.WriteUint8(info)
+
+	if origin != nil {
+		writeID(e, *origin)
+	}
+	if it.rightOrigin != nil {
+		writeID(e, *it.rightOrigin)
+	}
+	if origin == nil && it.rightOrigin == nil {
+		// Without neighbours, the parent must be spelled out
+		switch {
+		case it.parent != nil && it.parent.item == nil:
+			e.WriteVarUint(1)
+			e.WriteVarString(it.parent.rootKey)
+		case it.parent != nil:
+			e.WriteVarUint(0)
+			writeID(e, it.parent.item.id)
+		case it.parentKey != nil:
+			e.WriteVarUint(1)
+			e.WriteVarString(*it.parentKey)
+		case it.parentID != nil:
+			e.WriteVarUint(0)
+			writeID(e, *it.parentID)
+		}
+		if it.parentSub != nil {
+			e.WriteVarString(*it.parentSub)
+		}
+	}
+
+	it.content.write(e, offset)
+}
+
+// lastID is the ID of the last unit of a struct
+func lastID(s structure) ID {
+	id := s.structID()
+	return ID{Client: id.Client, Clock: id.Clock + uint64(s.length()) - 1}
+}
+
+// sharedType is a Y.Text, Y.Array, Y.Map or Y.Xml* - a list of items
+// (start) and/or a map of keys to their latest item (entries)
+type sharedType struct {
+	ref     int
+	name    string // Node name of XML elements and hooks
+	rootKey string // Name of root types
+	item    *item  // Item holding this type (nil for root types)
+	start   *item
+	entries map[string]*item // Key → rightmost (current) item
+	length  int              // Countable, non-deleted units in the list
+}
+
+func newSharedType(ref int) *sharedType {
+	return &sharedType{ref: ref, entries: make(map[string]*item)}
+}
+
+// Doc is a server-side Yjs document: it integrates updates from any number
+// of clients and can render its content or encode itself as an update
+// Not safe for concurrent use
+type Doc struct {
+	clients map[uint64][]structure // Struct store: client → structs sorted by clock
+	roots   map[string]*sharedType
+
+	// Structs and deletions that depend on updates we haven't seen yet
+	pending        map[uint64][]structure
+	pendingDeletes deleteSet
+
+	deletedInTx []*item // Items deleted by the update being applied, collected afterwards
+	changes     uint64
+	broken      error // Why an update stopped halfway; the doc refuses further updates
+}
+
+// NewDoc creates an empty document
+func NewDoc() *Doc {
+	return &Doc{
+		clients:        make(map[uint64][]structure),
+		roots:          make(map[string]*sharedType),
+		pending:        make(map[uint64][]structure),
+		pendingDeletes: make(deleteSet),
+	}
+}
+
+// Changes counts insertions and deletions applied so far
+// Learning: Compare two readings to tell whether an update changed anything
+func (d *Doc) Changes() uint64 {
+	return d.changes
+}
+
+// HasPending reports whether some received changes are waiting for updates
+// they depend on
+func (d *Doc) HasPending() bool {
+	for _, structs := range d.pending {
+		if len(structs) > 0 {
+			return true
+		}
+	}
+	return len(d.pendingDeletes) > 0
+}
+
+// StateVector returns the next expected clock of every known client
+func (d *Doc) StateVector() map[uint64]uint64 {
+	sv := make(map[uint64]uint64, len(d.clients))
+	for client := range d.clients {
+		sv[client] = d.state(client)
+	}
+	return sv
+}
+
+// ApplyUpdate integrates a Yjs update (v1 encoding)
+// Changes whose dependencies are missing are kept and retried with later updates
+// Learning: An update is applied completely or not at all. Everything a
+// client controls - lengths, clocks, delete ranges, nesting - is checked
+// while decoding, before the document is touched; references to structs we
+// don't have yet wait in pending instead of failing. What is left can only
+// fail through a bug in this package: the doc then refuses further updates
+// (ErrBroken) rather than carrying on half-applied, and must be rebuilt
+func (d *Doc) ApplyUpdate(update []byte) (err error) {
+	if d.broken != nil {
+		return fmt.Errorf("%w: %v", ErrBroken, d.broken)
+	}
+	u, err := decodeUpdate(update)
+	if err != nil {
+		return err
+	}
+
+	d.deletedInTx = d.deletedInTx[:0]
+	defer func() {
+		if r := recover(); r != nil {
+			d.broken = fmt.Errorf("%v", r)
+			err = fmt.Errorf("%w: %v", ErrBroken, r)
+		}
+	}()
+
+	queues := d.pending
+	for client, structs := range u.structs {
+		queues[client] = append(queues[client], structs...)
+	}
+	for _, structs := range queues {
+		sort.SliceStable(structs, func(i, j int) bool {
+			return structs[i].structID().Clock < structs[j].structID().Clock
+		})
+	}
+	d.pending = d.integrate(queues)
+
+	deletes := d.pendingDeletes
+	deletes.merge(u.deletes)
+	d.pendingDeletes = d.applyDeleteSet(deletes)
+
+	d.collectGarbage()
+	return nil
+}
+
+// integrate adds every struct whose dependencies are known, and returns the rest
+// Learning: Yjs follows dependencies with an explicit stack; retrying each
+// client's queue until nothing moves reaches the same state, because the
+// result of integration doesn't depend on the order
+func (d *Doc) integrate(queues map[uint64][]structure) map[uint64][]structure {
+	clients := make([]uint64, 0, len(queues))
+	for client := range queues {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] < clients[j] })
+
+	for progress := true; progress; {
+		progress = false
+		for _, client := range clients {
+			q := queues[client]
+		queue:
+			for len(q) > 0 {
+				s := q[0]
+				state := d.state(client)
+				id := s.structID()
+				if id.Clock+uint64(s.length()) <= state {
+					q = q[1:] // Already integrated
+					continue
+				}
+				if id.Clock > state {
+					break // Gap - an earlier update from this client is missing
+				}
+				offset := int(state - id.Clock)
+
+				switch s := s.(type) {
+				case *skip:
+					// Nothing to integrate
+				case *gc:
+					d.addStruct(&gc{id: ID{Client: client, Clock: state}, n: s.n - offset})
+				case *item:
+					if d.missing(s) {
+						break queue // Wait for the update it depends on
+					}
+					d.resolve(s)
+					d.integrateItem(s, offset)
+				}
+				q = q[1:]
+				progress = true
+			}
+			queues[client] = q
+		}
+	}
+
+	rest := make(map[uint64][]structure)
+	for client, q := range queues {
+		if len(q) > 0 {
+			rest[client] = q
+		}
+	}
+	return rest
+}
+
+// missing reports whether an item references structs we don't have yet
+func (d *Doc) missing(it *item) bool {
+	if it.origin != nil && it.origin.Clock >= d.state(it.origin.Client) {
+		return true
+	}
+	if it.rightOrigin != nil && it.rightOrigin.Clock >= d.state(it.rightOrigin.Client) {
+		return true
+	}
+	return it.parentID != nil && it.parentID.Clock >= d.state(it.parentID.Client)
+}
+
+// resolve turns an item's decoded references into pointers
+func (d *Doc) resolve(it *item) {
+	var left, right structure
+	if it.origin != nil {
+		left = d.cleanEnd(*it.origin)
+		last := lastID(left)
+		it.origin = &last
+	}
+	if it.rightOrigin != nil {
+		right = d.cleanStart(*it.rightOrigin)
+		first := right.structID()
+		it.rightOrigin = &first
+	}
+	it.left, _ = left.(*item)
+	it.right, _ = right.(*item)
+
+	_, leftGC := left.(*gc)
+	_, rightGC := right.(*gc)
+	switch {
+	case leftGC || rightGC:
+		// A neighbour was garbage collected, so the parent is gone too
+		it.parent = nil
+	case it.parentKey != nil:
+		it.parent = d.root(*it.parentKey)
+	case it.parentID != nil:
+		it.parent = nil
+		if p, ok := d.find(*it.parentID).(*item); ok {
+			if c, ok := p.content.(*contentType); ok {
+				it.parent = c.t
+			}
+		}
+	case it.left != nil:
+		// Inserted next to an existing item: same parent. The left
+		// neighbour wins, as in Yjs - it's the list the item is linked into
+		it.parent, it.parentSub = it.left.parent, it.left.parentSub
+	case it.right != nil:
+		it.parent, it.parentSub = it.right.parent, it.right.parentSub
+	}
+}
+
+// integrateItem links an item into its parent
+// Learning: This is YATA. Scanning right from the left neighbour, every
+// item that was inserted concurrently at the same place is a conflict;
+// ties are broken by client ID, so all replicas end up with the same order
+func (d *Doc) integrateItem(it *item, offset int) {
+	if offset > 0 {
+		// Part of this item is already known - keep only the new tail
+		it.id.Clock += uint64(offset)
+		left := d.cleanEnd(ID{Client: it.id.Client, Clock: it.id.Clock - 1})
+		last := lastID(left)
+		it.origin = &last
+		it.left, _ = left.(*item)
+		if _, ok := left.(*gc); ok {
+			it.parent = nil
+		}
+		it.content = it.content.splice(offset)
+		it.n -= offset
+	}
+
+	if it.parent == nil {
+		d.addStruct(&gc{id: it.id, n: it.n})
+		return
+	}
+	parent := it.parent
+
+	if (it.left == nil && (it.right == nil || it.right.left != nil)) || (it.left != nil && it.left.right != it.right) {
+		left := it.left
+		var o *item
+		switch {
+		case left != nil:
+			o = left.right
+		case it.parentSub != nil:
+			o = parent.entries[*it.parentSub]
+			for o != nil && o.left != nil {
+				o = o.left
+			}
+		default:
+			o = parent.start
+		}
+
+		conflicting := make(map[*item]bool)
+		beforeOrigin := make(map[*item]bool)
+		for o != nil && o != it.right {
+			beforeOrigin[o] = true
+			conflicting[o] = true
+			if sameID(it.origin, o.origin) {
+				// Same left neighbour: order by client ID
+				if o.id.Client < it.id.Client {
+					left = o
+					clear(conflicting)
+				} else if sameID(it.rightOrigin, o.rightOrigin) {
+					break // Same neighbours on both sides and we sort first
+				}
+			} else if o.origin != nil {
+				originItem, _ := d.find(*o.origin).(*item)
+				if originItem == nil || !beforeOrigin[originItem] {
+					break
+				}
+				// o was inserted after an item we already passed
+				if !conflicting[originItem] {
+					left = o
+					clear(conflicting)
+				}
+			} else {
+				break
+			}
+			o = o.right
+		}
+		it.left = left
+	}
+
+	// Link into the list (or the map entry's history)
+	if it.left != nil {
+		it.right = it.left.right
+		it.left.right = it
+	} else {
+		var r *item
+		if it.parentSub != nil {
+			r = parent.entries[*it.parentSub]
+			for r != nil && r.left != nil {
+				r = r.left
+			}
+		} else {
+			r = parent.start
+			parent.start = it
+		}
+		it.right = r
+	}
+	if it.right != nil {
+		it.right.left = it
+	} else if it.parentSub != nil {
+		// The newest value of a key replaces the previous one
+		parent.entries[*it.parentSub] = it
+		if it.left != nil {
+			d.deleteItem(it.left)
+		}
+	}
+
+	if it.parentSub == nil && it.content.countable() && !it.isDeleted {
+		parent.length += it.n
+	}
+	d.addStruct(it)
+	d.changes++
+
+	switch c := it.content.(type) {
+	case *contentType:
+		c.t.item = it
+	case *contentDeleted:
+		it.isDeleted = true
+	}
+
+	if (parent.item != nil && parent.item.isDeleted) || (it.parentSub != nil && it.right != nil) {
+		d.deleteItem(it)
+	}
+}
+
+// deleteItem marks an item, and everything inside it, as deleted
+func (d *Doc) deleteItem(it *item) {
+	if it.isDeleted {
+		return
+	}
+	if it.parentSub == nil && it.content.countable() {
+		it.parent.length -= it.n
+	}
+	it.isDeleted = true
+	d.deletedInTx = append(d.deletedInTx, it)
+	d.changes++
+
+	if c, ok := it.content.(*contentType); ok {
+		for child := c.t.start; child != nil; child = child.right {
+			d.deleteItem(child)
+		}
+		for _, child := range c.t.entries {
+			d.deleteItem(child)
+		}
+	}
+}
+
+// collectGarbage drops the content of items deleted by the last update
+// Learning: Only the length of a deleted item is needed to keep clocks and
+// positions consistent. Children of a deleted type aren't even needed as
+// items - they become plain GC ranges
+func (d *Doc) collectGarbage() {
+	for _, it := range d.deletedInTx {
+		d.gcItem(it, false)
+	}
+	d.deletedInTx = d.deletedInTx[:0]
+}
+
+func (d *Doc) gcItem(it *item, parentGCd bool) {
+	if c, ok := it.content.(*contentType); ok {
+		for child := c.t.start; child != nil; child = child.right {
+			d.gcItem(child, true)
+		}
+		c.t.start = nil
+		for _, child := range c.t.entries {
+			for ; child != nil; child = child.left {
+				d.gcItem(child, true)
+			}
+		}
+		c.t.entries = make(map[string]*item)
+	}
+
+	if parentGCd {
+		d.replaceStruct(it, &gc{id: it.id, n: it.n})
+	} else {
+		it.content = &contentDeleted{n: it.n}
+	}
+}
+
+// root returns the root type with the given name, creating it if needed
+func (d *Doc) root(name string) *sharedType {
+	t, ok := d.roots[name]
+	if !ok {
+		t = newSharedType(typeUnknown)
+		t.rootKey = name
+		d.roots[name] = t
+	}
+	return t
+}
+
+// Struct store
+
+// state is the next clock expected from a client
+func (d *Doc) state(client uint64) uint64 {
+	structs := d.clients[client]
+	if len(structs) == 0 {
+		return 0
+	}
+	last := structs[len(structs)-1]
+	return last.structID().Clock + uint64(last.length())
+}
+
+func (d *Doc) addStruct(s structure) {
+	id := s.structID()
+	if id.Clock != d.state(id.Client) {
+		panic(fmt.Sprintf("struct %d:%d doesn't follow the client's last struct", id.Client, id.Clock))
+	}
+	d.clients[id.Client] = append(d.clients[id.Client], s)
+}
+
+// findIndex returns the index of the struct containing clock
+func findIndex(structs []structure, clock uint64) int {
+	lo, hi := 0, len(structs)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		s := structs[mid]
+		start := s.structID().Clock
+		switch {
+		case clock < start:
+			hi = mid - 1
+		case clock >= start+uint64(s.length()):
+			lo = mid + 1
+		default:
+			return mid
+		}
+	}
+	panic(fmt.Sprintf("clock %d not in the struct store", clock))
+}
+
+// find returns the struct containing id
+func (d *Doc) find(id ID) structure {
+	structs := d.clients[id.Client]
+	return structs[findIndex(structs, id.Clock)]
+}
+
+// cleanStart returns the struct starting exactly at id, splitting an item if needed
+func (d *Doc) cleanStart(id ID) structure {
+	structs := d.clients[id.Client]
+	i := findIndex(structs, id.Clock)
+	s := structs[i]
+	if it, ok := s.(*item); ok && it.id.Clock < id.Clock {
+		right := d.splitItem(it, int(id.Clock-it.id.Clock))
+		d.insertStruct(id.Client, i+1, right)
+		return right
+	}
+	return s
+}
+
+// cleanEnd returns the struct ending exactly at id, splitting an item if needed
+func (d *Doc) cleanEnd(id ID) structure {
+	structs := d.clients[id.Client]
+	i := findIndex(structs, id.Clock)
+	s := structs[i]
+	if it, ok := s.(*item); ok && id.Clock != it.id.Clock+uint64(it.n)-1 {
+		d.insertStruct(id.Client, i+1, d.splitItem(it, int(id.Clock-it.id.Clock)+1))
+	}
+	return s
+}
+
+func (d *Doc) insertStruct(client uint64, i int, s structure) {
+	structs := append(d.clients[client], nil)
+	copy(structs[i+1:], structs[i:])
+	structs[i] = s
+	d.clients[client] = structs
+}
+
+func (d *Doc) replaceStruct(old *item, s structure) {
+	structs := d.clients[old.id.Client]
+	i := findIndex(structs, old.id.Clock)
+	if structs[i] == structure(old) {
+		structs[i] = s
+	}
+}
+
+// splitItem cuts an item in two at diff; the left part keeps the item's identity
+func (d *Doc) splitItem(left *item, diff int) *item {
+	right := &item{
+		id:          ID{Client: left.id.Client, Clock: left.id.Clock + uint64(diff)},
+		origin:      &ID{Client: left.id.Client, Clock: left.id.Clock + uint64(diff) - 1},
+		rightOrigin: left.rightOrigin,
+		left:        left,
+		right:       left.right,
+		parent:      left.parent,
+		parentSub:   left.parentSub,
+		content:     left.content.splice(diff),
+		isDeleted:   left.isDeleted,
+	}
+	right.n = right.content.length()
+	left.n = diff
+
+	left.right = right
+	if right.right != nil {
+		right.right.left = right
+	}
+	if right.parentSub != nil && right.right == nil {
+		right.parent.entries[*right.parentSub] = right
+	}
+	return right
+}
+
+func sameID(a, b *ID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,293 @@
+package yjs
+
+import (
+	"bytes"
+	"maps"
+	"testing"
+)
+
+/*
+Fixtures are updates as Yjs encodes them (v1, what y-websocket sends), for
+documents whose clients have fixed IDs (new Y.Doc() with doc.clientID set):
+
+	base      client 1: ytext.insert(0, "abc")
+	insertX   client 2, synced with base: ytext.insert(1, "X")
+	insertY   client 3, synced with base: ytext.insert(1, "Y")
+	appendD   client 1, after base: ytext.insert(3, "d")
+	deleteB   client 1, after base: ytext.delete(1, 1)
+
+Struct layout: [info] [origin?] [right origin?] [parent if neither] [content]
+*/
+var (
+	base = []byte{
+		1,       // 1 client
+		1, 1, 0, // 1 struct, client 1, clock 0
+		0x04,      // Item, ContentString, no origins
+		1, 1, 't', // Parent: root type "t"
+		3, 'a', 'b', 'c',
+		0, // Delete set: no clients
+	}
+	insertX = []byte{
+		1,
+		1, 2, 0,
+		0xc4,       // Item, ContentString, origin and right origin
+		1, 0, 1, 1, // After (1,0) "a", before (1,1) "b"
+		1, 'X',
+		0,
+	}
+	insertY = []byte{
+		1,
+		1, 3, 0,
+		0xc4,
+		1, 0, 1, 1,
+		1, 'Y',
+		0,
+	}
+	appendD = []byte{
+		1,
+		1, 1, 3,
+		0x84, // Origin only
+		1, 2, // After (1,2) "c"
+		1, 'd',
+		0,
+	}
+	deleteB = []byte{
+		0,             // No structs
+		1, 1, 1, 1, 1, // Client 1: 1 range, clock 1, length 1
+	}
+)
+
+// load applies updates to a new document
+func load(t *testing.T, updates ...[]byte) *Doc {
+	t.Helper()
+	d := NewDoc()
+	for i, u := range updates {
+		if err := d.ApplyUpdate(u); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+	return d
+}
+
+func TestApplyUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates [][]byte
+		want    string
+	}{
+		{"insert", [][]byte{base}, "abc"},
+		{"insert between", [][]byte{base, insertX}, "aXbc"},
+		{"append", [][]byte{base, appendD}, "abcd"},
+		{"delete", [][]byte{base, deleteB}, "ac"},
+		{"delete then insert at the deleted position", [][]byte{base, deleteB, insertX}, "aXc"},
+
+		// Same origins: the lower client ID goes left, whichever arrives first
+		{"concurrent inserts", [][]byte{base, insertX, insertY}, "aXYbc"},
+		{"concurrent inserts, reversed", [][]byte{base, insertY, insertX}, "aXYbc"},
+
+		// Structs that depend on unseen ones wait for them
+		{"insert before its origin", [][]byte{insertX, base}, "aXbc"},
+		{"append before the text", [][]byte{appendD, insertY, base}, "aYbcd"},
+		{"delete before the text", [][]byte{deleteB, base}, "ac"},
+		{"everything reversed", [][]byte{deleteB, appendD, insertY, insertX, base}, "aXYcd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := load(t, tt.updates...)
+			if got := d.Text("t"); got != tt.want {
+				t.Errorf("Text = %q, want %q", got, tt.want)
+			}
+			if d.HasPending() {
+				t.Error("HasPending = true after all updates arrived")
+			}
+		})
+	}
+}
+
+func TestApplyUpdatePending(t *testing.T) {
+	d := load(t, insertX, deleteB)
+	if !d.HasPending() {
+		t.Fatal("HasPending = false with the base text missing")
+	}
+	if got := d.Text("t"); got != "" {
+		t.Errorf("Text = %q before the base text, want empty", got)
+	}
+	if sv := d.StateVector(); len(sv) != 0 {
+		t.Errorf("StateVector = %v, want empty: pending structs aren't part of the state", sv)
+	}
+
+	// Pending structs survive a round trip, so a compaction doesn't drop them
+	reloaded := load(t, d.EncodeStateAsUpdate(nil), base)
+	if got := reloaded.Text("t"); got != "aXc" {
+		t.Errorf("reloaded Text = %q, want %q", got, "aXc")
+	}
+}
+
+func TestApplyUpdateIdempotent(t *testing.T) {
+	d := load(t, base, insertX, deleteB)
+	changes := d.Changes()
+	for _, u := range [][]byte{base, insertX, deleteB} {
+		if err := d.ApplyUpdate(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := d.Text("t"); got != "aXc" {
+		t.Errorf("Text = %q, want %q", got, "aXc")
+	}
+	if d.Changes() != changes {
+		t.Errorf("Changes went from %d to %d on updates already applied", changes, d.Changes())
+	}
+}
+
+func TestApplyUpdateRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		update []byte
+	}{
+		{"empty", nil},
+		{"truncated struct", base[:8]},
+		{"truncated delete set", deleteB[:4]},
+		{"string longer than the data", []byte{1, 1, 1, 0, 0x04, 1, 1, 't', 9, 'a', 0}},
+		{"unknown content", []byte{1, 1, 1, 0, 0x0f, 1, 1, 't', 0}},
+		{"empty struct", []byte{1, 1, 1, 0, 0x04, 1, 1, 't', 0, 0}},
+		{"clock out of range", []byte{0, 1, 1, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := load(t, base)
+			if err := d.ApplyUpdate(tt.update); err == nil {
+				t.Error("ApplyUpdate accepted a malformed update")
+			}
+			// A rejected update leaves the document as it was
+			if got := d.Text("t"); got != "abc" {
+				t.Errorf("Text = %q after a rejected update, want %q", got, "abc")
+			}
+			if err := d.ApplyUpdate(insertX); err != nil {
+				t.Errorf("ApplyUpdate after a rejected update: %v", err)
+			}
+		})
+	}
+}
+
+func TestEncodeStateAsUpdate(t *testing.T) {
+	d := load(t, base, insertX)
+
+	tests := []struct {
+		name string
+		sv   map[uint64]uint64
+		want []byte
+	}{
+		{
+			// Client 1's text was split by the insert: "a", then "bc" after it
+			name: "whole document",
+			sv:   nil,
+			want: []byte{
+				2,
+				1, 2, 0, 0xc4, 1, 0, 1, 1, 1, 'X',
+				2, 1, 0, 0x04, 1, 1, 't', 1, 'a', 0x84, 1, 0, 2, 'b', 'c',
+				0,
+			},
+		},
+		{
+			name: "missing one client",
+			sv:   map[uint64]uint64{1: 3},
+			want: insertX,
+		},
+		{
+			// Starts inside a struct: the rest of it, with the unit before as origin
+			name: "partway through a struct",
+			sv:   map[uint64]uint64{1: 2, 2: 1},
+			want: []byte{1, 1, 1, 2, 0x84, 1, 1, 1, 'c', 0},
+		},
+		{
+			name: "up to date",
+			sv:   map[uint64]uint64{1: 3, 2: 1},
+			want: []byte{0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := d.EncodeStateAsUpdate(tt.sv)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("EncodeStateAsUpdate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSyncConverges plays the y-websocket handshake between two documents
+// that were edited apart: each sends its state vector, the other answers
+// with the diff
+func TestSyncConverges(t *testing.T) {
+	a := load(t, base, insertX, deleteB)
+	b := load(t, base, insertY, appendD)
+
+	svA, err := DecodeStateVector(a.EncodeStateVector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svB, err := DecodeStateVector(b.EncodeStateVector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	toA, toB := b.EncodeStateAsUpdate(svA), a.EncodeStateAsUpdate(svB)
+	if err := a.ApplyUpdate(toA); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ApplyUpdate(toB); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "aXYcd"
+	if got := a.Text("t"); got != want {
+		t.Errorf("a: Text = %q, want %q", got, want)
+	}
+	if got := b.Text("t"); got != want {
+		t.Errorf("b: Text = %q, want %q", got, want)
+	}
+	if !maps.Equal(a.StateVector(), b.StateVector()) {
+		t.Errorf("state vectors differ: %v and %v", a.StateVector(), b.StateVector())
+	}
+}
+
+// TestMergeUpdates checks what compaction relies on: a document reloaded
+// from the merged update is the document the separate updates make
+func TestMergeUpdates(t *testing.T) {
+	updates := [][]byte{base, insertX, insertY, appendD, deleteB}
+	merged, err := MergeUpdates(updates...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := load(t, updates...)
+	got := load(t, merged)
+
+	if got.Text("t") != want.Text("t") {
+		t.Errorf("Text = %q, want %q", got.Text("t"), want.Text("t"))
+	}
+	if !maps.Equal(got.StateVector(), want.StateVector()) {
+		t.Errorf("StateVector = %v, want %v", got.StateVector(), want.StateVector())
+	}
+	if !bytes.Equal(got.EncodeStateAsUpdate(nil), want.EncodeStateAsUpdate(nil)) {
+		t.Error("reloaded document encodes differently")
+	}
+
+	// Clients that missed some updates catch up from the merged one too
+	behind := load(t, base, insertY)
+	if err := behind.ApplyUpdate(merged); err != nil {
+		t.Fatal(err)
+	}
+	if behind.Text("t") != want.Text("t") {
+		t.Errorf("catching up: Text = %q, want %q", behind.Text("t"), want.Text("t"))
+	}
+
+	// Edits made after the merge still land in the right place
+	later := []byte{1, 1, 4, 0, 0xc4, 2, 0, 3, 0, 1, 'Z', 0} // client 4: between X and Y
+	for _, d := range []*Doc{got, want} {
+		if err := d.ApplyUpdate(later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got.Text("t") != "aXZYcd" || want.Text("t") != "aXZYcd" {
+		t.Errorf("after a later edit: %q and %q, want %q", got.Text("t"), want.Text("t"), "aXZYcd")
+	}
+}
@@ -0,0 +1,188 @@
+package yjs
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+LEARNING: EDITING FROM THE SERVER
+
+Content also changes outside the editor - REST updates, restored versions.
+Editors only learn about it as a Yjs update, so the server makes the change
+the way a client would: as its own client, with new items and deletions.
+
+The change is kept small. Blocks the text shares with the document at the
+start and the end stay as they are; only the blocks in between are deleted
+and the new text inserted as paragraphs:
+
+  document: [Intro] [Setup] [Old step] [Rollback]
+  text:      Intro   Setup   New step   Rollback
+                             ────────
+                             delete [Old step], insert <paragraph>New step
+
+Someone typing in [Intro] while this happens keeps their edit. Markdown
+inserted this way stays as written ("## Title" in a paragraph) - it renders
+back to the same text, which is what search and RAG read.
+*/
+
+// ErrNotXML means a root type holds something other than XML nodes
+var ErrNotXML = errors.New("yjs: root type is not an XML fragment")
+
+// block is a top-level node of an XML fragment, with its rendered text
+type block struct {
+	it   *item
+	text string
+}
+
+// ReplaceText changes an XML fragment root type so it renders as text,
+// as edits by client. Returns the update describing the change, already
+// applied, or nil when the text is already what it renders
+func (d *Doc) ReplaceText(root string, client uint64, text string) ([]byte, error) {
+	var blocks []block
+	t, exists := d.roots[root]
+	if exists {
+		if t.start != nil && !isXMLContainer(t) && t.length > 0 {
+			return nil, ErrNotXML
+		}
+		for it := t.start; it != nil; it = it.right {
+			c, ok := it.content.(*contentType)
+			if !ok || it.isDeleted {
+				continue
+			}
+			blocks = append(blocks, block{it: it, text: (&renderer{}).renderBlock(c.t)})
+		}
+	}
+
+	// Keep the blocks the text starts and ends with. Blocks that render as
+	// nothing (empty paragraphs) don't appear in the text - they are skipped
+	text = strings.TrimSpace(text)
+	head, rest := 0, text
+	for head < len(blocks) {
+		if blocks[head].text == "" {
+			head++
+			continue
+		}
+		after, ok := cutBlock(rest, blocks[head].text)
+		if !ok {
+			break
+		}
+		head, rest = head+1, after
+	}
+	tail := len(blocks)
+	for tail > head {
+		if blocks[tail-1].text == "" {
+			tail--
+			continue
+		}
+		before, ok := cutBlockSuffix(rest, blocks[tail-1].text)
+		if !ok {
+			break
+		}
+		tail, rest = tail-1, before
+	}
+
+	var paragraphs []string
+	for _, p := range strings.Split(rest, "\n\n") {
+		if strings.TrimSpace(p) != "" {
+			paragraphs = append(paragraphs, strings.Trim(p, "\n"))
+		}
+	}
+	if head == tail && len(paragraphs) == 0 {
+		return nil, nil
+	}
+
+	// New paragraphs go right after the kept head, before whatever followed it
+	var origin, rightOrigin *ID
+	switch {
+	case head > 0:
+		left := blocks[head-1].it
+		id := lastID(left)
+		origin = &id
+		if left.right != nil {
+			rightOrigin = &left.right.id
+		}
+	case exists && t.start != nil:
+		rightOrigin = &t.start.id
+	}
+
+	clock := d.state(client)
+	var structs []structure
+	for _, p := range paragraphs {
+		element := &item{
+			id:          ID{Client: client, Clock: clock},
+			origin:      origin,
+			rightOrigin: rightOrigin,
+			content:     &contentType{t: &sharedType{ref: TypeXMLElement, name: "paragraph"}},
+			n:           1,
+		}
+		if origin == nil && rightOrigin == nil {
+			element.parentKey = &root
+		}
+		xmlText := &item{
+			id:       ID{Client: client, Clock: clock + 1},
+			parentID: &element.id,
+			content:  &contentType{t: &sharedType{ref: TypeXMLText}},
+			n:        1,
+		}
+		str := &item{
+			id:       ID{Client: client, Clock: clock + 2},
+			parentID: &xmlText.id,
+			content:  newContentString(p),
+		}
+		str.n = str.content.length()
+		structs = append(structs, element, xmlText, str)
+
+		origin = &element.id // The next paragraph follows this one
+		clock += 2 + uint64(str.n)
+	}
+
+	e := &Encoder{}
+	if len(structs) == 0 {
+		e.WriteVarUint(0)
+	} else {
+		e.WriteVarUint(1)
+		e.WriteVarUint(uint64(len(structs)))
+		e.WriteVarUint(client)
+		e.WriteVarUint(structs[0].structID().Clock)
+		for _, s := range structs {
+			s.write(e, 0)
+		}
+	}
+	deletes := make(deleteSet)
+	for _, b := range blocks[head:tail] {
+		deletes.add(b.it.id.Client, b.it.id.Clock, uint64(b.it.n))
+	}
+	deletes.normalize()
+	writeDeleteSet(e, deletes)
+
+	update := e.Bytes()
+	if err := d.ApplyUpdate(update); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// cutBlock removes a block from the start of text, with the blank line after it
+func cutBlock(text, block string) (string, bool) {
+	rest, ok := strings.CutPrefix(text, block)
+	if !ok {
+		return text, false
+	}
+	if rest == "" {
+		return rest, true
+	}
+	return strings.CutPrefix(rest, "\n\n")
+}
+
+// cutBlockSuffix removes a block from the end of text, with the blank line before it
+func cutBlockSuffix(text, block string) (string, bool) {
+	rest, ok := strings.CutSuffix(text, block)
+	if !ok {
+		return text, false
+	}
+	if rest == "" {
+		return rest, true
+	}
+	return strings.CutSuffix(rest, "\n\n")
+}
@@ -0,0 +1,120 @@
+package yjs
+
+import (
+	"bytes"
+	"testing"
+)
+
+// paragraph is how Tiptap (y-prosemirror) stores a paragraph typed by
+// client 1: an XmlElement in the "default" fragment, an XmlText inside it,
+// and the string inside that
+var paragraph = []byte{
+	1,
+	3, 1, 0, // 3 structs, client 1, clock 0
+	0x07, 1, 7, 'd', 'e', 'f', 'a', 'u', 'l', 't', // ContentType in root "default"
+	3, 9, 'p', 'a', 'r', 'a', 'g', 'r', 'a', 'p', 'h', // XmlElement "paragraph"
+	0x07, 0, 1, 0, 6, // ContentType in (1,0): XmlText
+	0x04, 0, 1, 1, 5, 'H', 'e', 'l', 'l', 'o', // ContentString in (1,1)
+	0,
+}
+
+func TestReplaceTextEncodesLikeTiptap(t *testing.T) {
+	d := NewDoc()
+	update, err := d.ReplaceText("default", 1, "Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(update, paragraph) {
+		t.Errorf("ReplaceText = %v, want %v", update, paragraph)
+	}
+	if got := load(t, paragraph).Text("default"); got != "Hello" {
+		t.Errorf("Text = %q, want %q", got, "Hello")
+	}
+}
+
+func TestReplaceText(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+	}{
+		{"from empty", "", "One\n\nTwo"},
+		{"unchanged", "One\n\nTwo", "One\n\nTwo"},
+		{"change the middle", "Intro\n\nOld step\n\nRollback", "Intro\n\nNew step\n\nRollback"},
+		{"add at the end", "Intro", "Intro\n\nMore"},
+		{"add at the start", "Intro", "Title\n\nIntro"},
+		{"remove a block", "One\n\nTwo\n\nThree", "One\n\nThree"},
+		{"clear", "One\n\nTwo", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewDoc()
+			if _, err := server.ReplaceText("default", 1, tt.before); err != nil {
+				t.Fatal(err)
+			}
+			client := load(t, server.EncodeStateAsUpdate(nil))
+
+			update, err := server.ReplaceText("default", 1, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := server.Text("default"); got != tt.after {
+				t.Errorf("Text = %q, want %q", got, tt.after)
+			}
+			if tt.before == tt.after && update != nil {
+				t.Errorf("ReplaceText = %v for unchanged text, want nil", update)
+			}
+
+			// Editors receive the change as a plain update
+			if update != nil {
+				if err := client.ApplyUpdate(update); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := client.Text("default"); got != tt.after {
+				t.Errorf("client Text = %q, want %q", got, tt.after)
+			}
+		})
+	}
+}
+
+// TestReplaceTextKeepsConcurrentEdits checks that a block the server leaves
+// alone keeps an edit made in it at the same time
+func TestReplaceTextKeepsConcurrentEdits(t *testing.T) {
+	server := NewDoc()
+	if _, err := server.ReplaceText("default", 1, "Intro\n\nOld step"); err != nil {
+		t.Fatal(err)
+	}
+	client := load(t, server.EncodeStateAsUpdate(nil))
+
+	// Client 2 appends to "Intro": (1,2) is its string, "Intro" ends at (1,6)
+	typed := []byte{1, 1, 2, 0, 0x84, 1, 6, 1, '!', 0}
+	if err := client.ApplyUpdate(typed); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := server.ReplaceText("default", 1, "Intro\n\nNew step")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.ApplyUpdate(typed); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ApplyUpdate(replaced); err != nil {
+		t.Fatal(err)
+	}
+	const want = "Intro!\n\nNew step"
+	if got := server.Text("default"); got != want {
+		t.Errorf("server Text = %q, want %q", got, want)
+	}
+	if got := client.Text("default"); got != want {
+		t.Errorf("client Text = %q, want %q", got, want)
+	}
+}
+
+func TestReplaceTextNotXML(t *testing.T) {
+	d := load(t, base)
+	if _, err := d.ReplaceText("t", 1, "other"); err != ErrNotXML {
+		t.Errorf("ReplaceText on a Y.Text = %v, want ErrNotXML", err)
+	}
+}
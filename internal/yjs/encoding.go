@@ -0,0 +1,370 @@
+package yjs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+/*
+LEARNING: LIB0 ENCODING
+
+Yjs serializes everything with lib0, a compact binary format. The building
+block is the variable-length unsigned integer (varuint): 7 bits per byte,
+lowest bits first, high bit set on every byte except the last:
+
+  5     → 0x05
+  300   → 0xAC 0x02        (300 = 0b10_0101100)
+
+Strings and byte arrays are a varuint length followed by the bytes, so a
+decoder never needs delimiters. Arbitrary JSON-like values ("any") start with
+a one-byte tag (127 undefined, 119 string, 118 object, ...).
+*/
+
+// ErrUnexpectedEOF means an update or message ended in the middle of a value
+var ErrUnexpectedEOF = errors.New("yjs: unexpected end of data")
+
+// any type tags
+const (
+	anyUndefined = 127
+	anyNull      = 126
+	anyInteger   = 125
+	anyFloat32   = 124
+	anyFloat64   = 123
+	anyBigInt    = 122
+	anyFalse     = 121
+	anyTrue      = 120
+	anyString    = 119
+	anyObject    = 118
+	anyArray     = 117
+	anyBytes     = 116
+)
+
+// maxAnyDepth bounds the nesting of arrays and objects in an any value
+// Learning: Each level is one recursive call - without a bound, a few KB of
+// nested array tags from a client would exhaust the stack
+const maxAnyDepth = 64
+
+// Decoder reads lib0-encoded values from a byte slice
+type Decoder struct {
+	buf []byte
+	pos int
+}
+
+// NewDecoder creates a decoder positioned at the start of buf
+func NewDecoder(buf []byte) *Decoder {
+	return &Decoder{buf: buf}
+}
+
+// Remaining returns the number of unread bytes
+func (d *Decoder) Remaining() int {
+	return len(d.buf) - d.pos
+}
+
+// ReadUint8 reads a single byte
+func (d *Decoder) ReadUint8() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, ErrUnexpectedEOF
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+// ReadVarUint reads a variable-length unsigned integer
+func (d *Decoder) ReadVarUint() (uint64, error) {
+	var n uint64
+	for shift := uint(0); ; shift += 7 {
+		b, err := d.ReadUint8()
+		if err != nil {
+			return 0, err
+		}
+		if shift > 63 {
+			return 0, fmt.Errorf("yjs: varuint overflows 64 bits")
+		}
+		n |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return n, nil
+		}
+	}
+}
+
+// ReadVarInt reads a variable-length signed integer
+// Learning: The first byte holds a continuation bit, a sign bit and 6 value bits
+func (d *Decoder) ReadVarInt() (int64, error) {
+	b, err := d.ReadUint8()
+	if err != nil {
+		return 0, err
+	}
+	n := uint64(b & 0x3f)
+	negative := b&0x40 != 0
+	for shift := uint(6); b >= 0x80; shift += 7 {
+		if b, err = d.ReadUint8(); err != nil {
+			return 0, err
+		}
+		if shift > 63 {
+			return 0, fmt.Errorf("yjs: varint overflows 64 bits")
+		}
+		n |= uint64(b&0x7f) << shift
+	}
+	if negative {
+		return -int64(n), nil
+	}
+	return int64(n), nil
+}
+
+// ReadVarBytes reads a length-prefixed byte array
+// The result aliases the decoder's buffer
+func (d *Decoder) ReadVarBytes() ([]byte, error) {
+	n, err := d.ReadVarUint()
+	if err != nil {
+		return nil, err
+	}
+	return d.readBytes(n)
+}
+
+// ReadVarString reads a length-prefixed UTF-8 string
+func (d *Decoder) ReadVarString() (string, error) {
+	b, err := d.ReadVarBytes()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *Decoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(d.Remaining()) {
+		return nil, ErrUnexpectedEOF
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// ReadAny reads a tagged JSON-like value
+// Returns nil (undefined, null), bool, int64, float64, string, []byte,
+// []any or map[string]any
+func (d *Decoder) ReadAny() (any, error) {
+	return d.readAny(0)
+}
+
+func (d *Decoder) readAny(depth int) (any, error) {
+	if depth > maxAnyDepth {
+		return nil, fmt.Errorf("yjs: any value nested deeper than %d levels", maxAnyDepth)
+	}
+	tag, err := d.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case anyUndefined, anyNull:
+		return nil, nil
+	case anyInteger:
+		return d.ReadVarInt()
+	case anyFloat32:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case anyFloat64:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case anyBigInt:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case anyFalse:
+		return false, nil
+	case anyTrue:
+		return true, nil
+	case anyString:
+		return d.ReadVarString()
+	case anyObject:
+		n, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		obj := make(map[string]any)
+		for i := uint64(0); i < n; i++ {
+			key, err := d.ReadVarString()
+			if err != nil {
+				return nil, err
+			}
+			if obj[key], err = d.readAny(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case anyArray:
+		n, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]any, 0, min(n, uint64(d.Remaining())))
+		for i := uint64(0); i < n; i++ {
+			v, err := d.readAny(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case anyBytes:
+		b, err := d.ReadVarBytes()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	default:
+		return nil, fmt.Errorf("yjs: unknown any tag %d", tag)
+	}
+}
+
+// readRawAny reads one tagged value and returns its encoded bytes
+// Learning: Values are re-encoded byte for byte, so float32/float64 and
+// undefined/null survive a round trip through the server unchanged
+func (d *Decoder) readRawAny() (any, []byte, error) {
+	start := d.pos
+	v, err := d.ReadAny()
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, append([]byte(nil), d.buf[start:d.pos]...), nil
+}
+
+// Encoder writes lib0-encoded values
+type Encoder struct {
+	buf []byte
+}
+
+// Bytes returns the encoded data
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+// WriteUint8 writes a single byte
+func (e *Encoder) WriteUint8(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+// WriteVarUint writes a variable-length unsigned integer
+func (e *Encoder) WriteVarUint(n uint64) {
+	for n >= 0x80 {
+		e.buf = append(e.buf, byte(n)|0x80)
+		n >>= 7
+	}
+	e.buf = append(e.buf, byte(n))
+}
+
+// WriteVarBytes writes a length-prefixed byte array
+func (e *Encoder) WriteVarBytes(b []byte) {
+	e.WriteVarUint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// WriteVarString writes a length-prefixed UTF-8 string
+func (e *Encoder) WriteVarString(s string) {
+	if !utf8.ValidString(s) {
+		s = string([]rune(s)) // Invalid bytes become U+FFFD, as TextEncoder does
+	}
+	e.WriteVarUint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// WriteAny writes a JSON-like value with its type tag
+// Supports the types returned by ReadAny, plus int and float32
+func (e *Encoder) WriteAny(v any) {
+	switch v := v.(type) {
+	case nil:
+		e.WriteUint8(anyNull)
+	case bool:
+		if v {
+			e.WriteUint8(anyTrue)
+		} else {
+			e.WriteUint8(anyFalse)
+		}
+	case int:
+		e.writeAnyInt(int64(v))
+	case int64:
+		e.writeAnyInt(v)
+	case float32:
+		e.WriteUint8(anyFloat32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(v))
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+			e.writeAnyInt(int64(v))
+			return
+		}
+		e.WriteUint8(anyFloat64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v))
+	case string:
+		e.WriteUint8(anyString)
+		e.WriteVarString(v)
+	case []byte:
+		e.WriteUint8(anyBytes)
+		e.WriteVarBytes(v)
+	case []any:
+		e.WriteUint8(anyArray)
+		e.WriteVarUint(uint64(len(v)))
+		for _, item := range v {
+			e.WriteAny(item)
+		}
+	case map[string]any:
+		e.WriteUint8(anyObject)
+		e.WriteVarUint(uint64(len(v)))
+		for key, item := range v {
+			e.WriteVarString(key)
+			e.WriteAny(item)
+		}
+	default:
+		e.WriteUint8(anyUndefined)
+	}
+}
+
+// writeAnyInt writes an integer the way lib0 does: small ones as varint,
+// the rest as float64
+func (e *Encoder) writeAnyInt(n int64) {
+	if n > math.MaxInt32 || n < -math.MaxInt32 {
+		e.WriteUint8(anyFloat64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(float64(n)))
+		return
+	}
+	e.WriteUint8(anyInteger)
+	e.writeVarInt(n)
+}
+
+func (e *Encoder) writeVarInt(n int64) {
+	var sign byte
+	u := uint64(n)
+	if n < 0 {
+		sign = 0x40
+		u = uint64(-n)
+	}
+	first := byte(u&0x3f) | sign
+	u >>= 6
+	if u > 0 {
+		first |= 0x80
+	}
+	e.buf = append(e.buf, first)
+	for u > 0 {
+		b := byte(u & 0x7f)
+		u >>= 7
+		if u > 0 {
+			b |= 0x80
+		}
+		e.buf = append(e.buf, b)
+	}
+}
+
+func (e *Encoder) writeRaw(b []byte) {
+	e.buf = append(e.buf, b...)
+}
@@ -0,0 +1,88 @@
+package yjs
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+LEARNING: RELATIVE POSITIONS
+
+An index like "character 42" goes stale as soon as someone types before it.
+Yjs cursors point at a character's ID instead - it never changes, wherever
+the character ends up:
+
+  item   {client: 7, clock: 12}   just before this character (assoc ≥ 0)
+                                  or just after it (assoc < 0)
+  type / tname                    end of this type, when there's no item
+                                  (an empty paragraph, an empty document)
+
+Editors share cursors as relative positions in awareness; Locate turns one
+into a line and column of the rendered text, as of the server's replica.
+*/
+
+// RelativePosition is a position in a shared type, as Y.relativePositionToJSON
+// writes it
+type RelativePosition struct {
+	Type  *ID    `json:"type"`  // Type whose end is meant, when Item is nil
+	TName string `json:"tname"` // Root type whose end is meant, when Item is nil
+	Item  *ID    `json:"item"`  // Character the position sticks to
+	Assoc int    `json:"assoc"`
+}
+
+// positionMark stands in for the located position while rendering
+// Learning: Documents can't contain NUL - Postgres text columns reject it
+const positionMark = "\x00"
+
+// Locate finds a relative position in the text of a root type
+// Lines and columns are 1-based; columns count characters
+// Returns false when the position isn't in that root type (or was garbage collected)
+func (d *Doc) Locate(root string, pos RelativePosition) (line, column int, ok bool) {
+	t, exists := d.roots[root]
+	if !exists {
+		return 0, 0, false
+	}
+
+	text := (&renderer{mark: &pos}).render(t)
+	i := strings.Index(text, positionMark)
+	if i < 0 {
+		return 0, 0, false
+	}
+
+	before := text[:i]
+	lineStart := strings.LastIndex(before, "\n") + 1
+	return strings.Count(before, "\n") + 1, utf8.RuneCountInString(before[lineStart:]) + 1, true
+}
+
+// markAt returns the offset of the marked position within an item, or -1
+func (r *renderer) markAt(it *item) int {
+	if r.mark == nil || r.mark.Item == nil || r.found {
+		return -1
+	}
+	id := *r.mark.Item
+	if id.Client != it.id.Client || id.Clock < it.id.Clock || id.Clock >= it.id.Clock+uint64(it.n) {
+		return -1
+	}
+	r.found = true
+
+	offset := int(id.Clock - it.id.Clock)
+	if r.mark.Assoc < 0 {
+		offset++ // After the character
+	}
+	return offset
+}
+
+// markEnd returns the mark if the marked position is the end of a type
+func (r *renderer) markEnd(t *sharedType) string {
+	if r.mark == nil || r.mark.Item != nil || r.found {
+		return ""
+	}
+	switch {
+	case t.item != nil && r.mark.Type != nil && t.item.id == *r.mark.Type:
+	case t.item == nil && r.mark.TName != "" && t.rootKey == r.mark.TName:
+	default:
+		return ""
+	}
+	r.found = true
+	return positionMark
+}
@@ -0,0 +1,303 @@
+package yjs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+/*
+LEARNING: FROM CRDT TO TEXT
+
+Editors store their content in different shared types:
+
+  Y.Text         plain or rich text (CodeMirror, Quill)
+  Y.XmlFragment  a tree of elements (ProseMirror/Tiptap):
+
+    <paragraph>Restart the <text bold>pod</text></paragraph>
+    <heading level=2>Rollback</heading>
+
+Text renders a root type either way. XML trees are written as markdown -
+headings, lists, quotes, code blocks and inline marks survive, which is
+what chunking and search care about.
+*/
+
+// RootNames returns the names of the document's root types
+func (d *Doc) RootNames() []string {
+	names := make([]string, 0, len(d.roots))
+	for name := range d.roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Text renders a root type as text: Y.Text as is, Y.XmlFragment as markdown
+// Returns "" for a root type that doesn't exist
+func (d *Doc) Text(root string) string {
+	t, ok := d.roots[root]
+	if !ok {
+		return ""
+	}
+	return (&renderer{}).render(t)
+}
+
+// renderer renders shared types as text, optionally marking one position
+type renderer struct {
+	mark  *RelativePosition // Position to mark in the output, if any
+	found bool
+}
+
+func (r *renderer) render(t *sharedType) string {
+	if isXMLContainer(t) {
+		return strings.TrimSpace(r.renderBlocks(t))
+	}
+	return r.renderInline(t, false)
+}
+
+// isXMLContainer reports whether a type's children are XML nodes
+// Learning: Root types don't say what they are - the first child tells
+func isXMLContainer(t *sharedType) bool {
+	switch t.ref {
+	case TypeXMLFragment, TypeXMLElement:
+		return true
+	case typeUnknown:
+		for it := t.start; it != nil; it = it.right {
+			if c, ok := it.content.(*contentType); ok {
+				return c.t.ref == TypeXMLElement || c.t.ref == TypeXMLText
+			}
+		}
+	}
+	return false
+}
+
+// children returns the live nested types of a list
+func children(t *sharedType) []*sharedType {
+	var nodes []*sharedType
+	for it := t.start; it != nil; it = it.right {
+		if it.isDeleted {
+			continue
+		}
+		if c, ok := it.content.(*contentType); ok {
+			nodes = append(nodes, c.t)
+		}
+	}
+	return nodes
+}
+
+// attr returns the current value of a map entry (XML attribute)
+func attr(t *sharedType, key string) any {
+	it := t.entries[key]
+	if it == nil || it.isDeleted {
+		return nil
+	}
+	switch c := it.content.(type) {
+	case *contentAny:
+		if len(c.values) > 0 {
+			return c.values[len(c.values)-1]
+		}
+	case *contentString:
+		return c.String()
+	}
+	return nil
+}
+
+// renderBlocks renders the children of an XML fragment or block element,
+// separated by blank lines
+func (r *renderer) renderBlocks(t *sharedType) string {
+	var blocks []string
+	for _, child := range children(t) {
+		if block := r.renderBlock(child); strings.TrimSpace(block) != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	if m := r.markEnd(t); m != "" {
+		blocks = append(blocks, m)
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// renderBlock renders one block-level node as markdown
+// Node names are ProseMirror's (Tiptap StarterKit)
+func (r *renderer) renderBlock(t *sharedType) string {
+	if t.ref == TypeXMLText || t.ref == TypeText {
+		return r.renderInline(t, true)
+	}
+
+	switch t.name {
+	case "paragraph":
+		return r.renderInline(t, true)
+	case "heading":
+		level := 1
+		if n, ok := number(attr(t, "level")); ok && n >= 1 && n <= 6 {
+			level = n
+		}
+		return strings.Repeat("#", level) + " " + r.renderInline(t, true)
+	case "blockquote":
+		return prefixLines(r.renderBlocks(t), "> ", "> ")
+	case "bulletList":
+		return r.renderList(t, func(int) string { return "- " })
+	case "orderedList":
+		start := 1
+		if n, ok := number(attr(t, "start")); ok {
+			start = n
+		}
+		return r.renderList(t, func(i int) string { return fmt.Sprintf("%d. ", start+i) })
+	case "codeBlock":
+		lang, _ := attr(t, "language").(string)
+		return "```" + lang + "\n" + r.renderInline(t, false) + "\n```"
+	case "horizontalRule":
+		return "---"
+	default:
+		// listItem and anything unknown: whatever blocks it contains
+		if isXMLContainer(t) || t.start == nil {
+			return r.renderBlocks(t)
+		}
+		return r.renderInline(t, true)
+	}
+}
+
+func (r *renderer) renderList(t *sharedType, marker func(i int) string) string {
+	var items []string
+	for i, child := range children(t) {
+		m := marker(i)
+		items = append(items, prefixLines(r.renderBlocks(child), m, strings.Repeat(" ", len(m))))
+	}
+	return strings.Join(items, "\n")
+}
+
+// prefixLines prefixes the first line with first and the others with rest
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderInline renders text and inline nodes; with marks, formatting
+// attributes become markdown (bold, italic, code, strike, links)
+func (r *renderer) renderInline(t *sharedType, marks bool) string {
+	var b strings.Builder
+	active := make(map[string]string) // Formatting attribute → JSON value
+	for it := t.start; it != nil; it = it.right {
+		offset := r.markAt(it)
+		if it.isDeleted {
+			if offset >= 0 {
+				b.WriteString(positionMark) // Where the deleted text was
+			}
+			continue
+		}
+		switch c := it.content.(type) {
+		case *contentFormat:
+			if offset >= 0 {
+				b.WriteString(positionMark)
+			}
+			if c.value == "null" {
+				delete(active, c.key)
+			} else {
+				active[c.key] = c.value
+			}
+		case *contentString:
+			text := c.String()
+			if offset >= 0 {
+				text = string(utf16.Decode(c.units[:offset])) + positionMark + string(utf16.Decode(c.units[offset:]))
+			}
+			if marks {
+				b.WriteString(applyMarks(text, active))
+			} else {
+				b.WriteString(text)
+			}
+		case *contentType:
+			if offset == 0 {
+				b.WriteString(positionMark)
+			}
+			b.WriteString(r.renderInlineNode(c.t, marks))
+			if offset > 0 {
+				b.WriteString(positionMark)
+			}
+		default:
+			if offset >= 0 {
+				b.WriteString(positionMark)
+			}
+		}
+	}
+	b.WriteString(r.markEnd(t))
+	return b.String()
+}
+
+// renderInlineNode renders a node nested in a paragraph
+func (r *renderer) renderInlineNode(t *sharedType, marks bool) string {
+	if t.ref == TypeXMLText || t.ref == TypeText {
+		return r.renderInline(t, marks)
+	}
+	switch t.name {
+	case "hardBreak":
+		return "\n"
+	case "image":
+		src, _ := attr(t, "src").(string)
+		alt, _ := attr(t, "alt").(string)
+		return "![" + alt + "](" + src + ")"
+	default:
+		return r.renderInline(t, marks)
+	}
+}
+
+// applyMarks wraps a run of text in the markdown of its formatting attributes
+func applyMarks(text string, active map[string]string) string {
+	if len(active) == 0 || strings.TrimSpace(text) == "" {
+		return text
+	}
+	if _, ok := active["code"]; ok {
+		return "`" + text + "`"
+	}
+
+	// Keep surrounding spaces outside the markers ("** pod**" isn't bold)
+	trimmed := strings.TrimSpace(text)
+	lead := text[:strings.Index(text, trimmed)]
+	trail := text[len(lead)+len(trimmed):]
+
+	out := trimmed
+	if _, ok := active["italic"]; ok {
+		out = "*" + out + "*"
+	}
+	if _, ok := active["bold"]; ok {
+		out = "**" + out + "**"
+	}
+	if _, ok := active["strike"]; ok {
+		out = "~~" + out + "~~"
+	}
+	if value, ok := active["link"]; ok {
+		var link struct {
+			Href string `json:"href"`
+		}
+		if json.Unmarshal([]byte(value), &link) == nil && link.Href != "" {
+			out = "[" + out + "](" + link.Href + ")"
+		}
+	}
+	return lead + out + trail
+}
+
+// number converts a decoded attribute value to an int
+func number(v any) (int, bool) {
+	switch n := v.(type) {
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	case string:
+		var i int
+		_, err := fmt.Sscanf(n, "%d", &i)
+		return i, err == nil
+	}
+	return 0, false
+}
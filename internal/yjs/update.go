@@ -0,0 +1,437 @@
+package yjs
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+LEARNING: THE UPDATE FORMAT (V1)
+
+An update is a batch of structs grouped by client, followed by a delete set:
+
+  [number of clients]
+    [number of structs] [client] [clock of the first struct]
+      [info byte] [origin?] [right origin?] [parent?] [content]
+      ...
+  [delete set: client → (clock, length) ranges]
+
+The info byte packs the content type (low 5 bits) with three flags: has
+origin, has right origin, is a map entry. Parent information is only sent
+when there are no neighbours to copy it from.
+
+The same format serves for everything: a single keystroke, the diff a
+client is missing, or a whole document - which is why merging a document's
+update log into one snapshot update is always possible.
+*/
+
+// deleteRange is a run of deleted clocks of one client
+type deleteRange struct {
+	clock uint64
+	n     uint64
+}
+
+// deleteSet holds deleted ranges by client, sorted and merged by normalize
+type deleteSet map[uint64][]deleteRange
+
+func (ds deleteSet) add(client, clock, n uint64) {
+	ds[client] = append(ds[client], deleteRange{clock: clock, n: n})
+}
+
+// merge adds every range of other
+func (ds deleteSet) merge(other deleteSet) {
+	for client, ranges := range other {
+		ds[client] = append(ds[client], ranges...)
+	}
+	ds.normalize()
+}
+
+// normalize sorts each client's ranges and merges overlapping ones
+func (ds deleteSet) normalize() {
+	for client, ranges := range ds {
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].clock < ranges[j].clock })
+		merged := ranges[:0]
+		for _, r := range ranges {
+			if n := len(merged); n > 0 && r.clock <= merged[n-1].clock+merged[n-1].n {
+				last := &merged[n-1]
+				last.n = max(last.n, r.clock+r.n-last.clock)
+				continue
+			}
+			merged = append(merged, r)
+		}
+		if len(merged) == 0 {
+			delete(ds, client)
+		} else {
+			ds[client] = merged
+		}
+	}
+}
+
+// applyDeleteSet deletes the items in ds and returns the ranges that refer
+// to structs we don't have yet
+func (d *Doc) applyDeleteSet(ds deleteSet) deleteSet {
+	unapplied := make(deleteSet)
+	for client, ranges := range ds {
+		state := d.state(client)
+		for _, r := range ranges {
+			end := r.clock + r.n
+			if r.clock >= state {
+				unapplied.add(client, r.clock, r.n)
+				continue
+			}
+			if state < end {
+				unapplied.add(client, state, end-state)
+				end = state
+			}
+
+			// Split items at both ends of the range, then delete what's inside
+			structs := d.clients[client]
+			i := findIndex(structs, r.clock)
+			if it, ok := structs[i].(*item); ok && !it.isDeleted && it.id.Clock < r.clock {
+				d.insertStruct(client, i+1, d.splitItem(it, int(r.clock-it.id.Clock)))
+				i++
+			}
+			for ; i < len(d.clients[client]); i++ {
+				s := d.clients[client][i]
+				if s.structID().Clock >= end {
+					break
+				}
+				it, ok := s.(*item)
+				if !ok || it.isDeleted {
+					continue
+				}
+				if end < it.id.Clock+uint64(it.n) {
+					d.insertStruct(client, i+1, d.splitItem(it, int(end-it.id.Clock)))
+				}
+				d.deleteItem(it)
+			}
+		}
+	}
+	unapplied.normalize()
+	return unapplied
+}
+
+// deleteSet collects the deleted ranges of the struct store
+func (d *Doc) deleteSet() deleteSet {
+	ds := make(deleteSet)
+	for client, structs := range d.clients {
+		for _, s := range structs {
+			if s.deleted() {
+				ds.add(client, s.structID().Clock, uint64(s.length()))
+			}
+		}
+	}
+	ds.normalize() // Joins adjacent ranges
+	return ds
+}
+
+// decodedUpdate is an update before integration
+type decodedUpdate struct {
+	structs map[uint64][]structure
+	deletes deleteSet
+}
+
+func decodeUpdate(update []byte) (*decodedUpdate, error) {
+	d := NewDecoder(update)
+	u := &decodedUpdate{structs: make(map[uint64][]structure), deletes: make(deleteSet)}
+
+	numClients, err := d.ReadVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numClients; i++ {
+		numStructs, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		client, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+
+		for j := uint64(0); j < numStructs; j++ {
+			s, err := readStruct(d, ID{Client: client, Clock: clock})
+			if err != nil {
+				return nil, fmt.Errorf("struct %d:%d: %w", client, clock, err)
+			}
+			// Zero-length structs have no place in the struct store, and
+			// clocks past maxClock don't exist in Yjs
+			n := s.length()
+			if n <= 0 || clock > maxClock || uint64(n) > maxClock-clock {
+				return nil, fmt.Errorf("%w: struct %d:%d has length %d", ErrMalformedUpdate, client, clock, n)
+			}
+			u.structs[client] = append(u.structs[client], s)
+			clock += uint64(n)
+		}
+	}
+
+	numClients, err = d.ReadVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numClients; i++ {
+		client, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		numRanges, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < numRanges; j++ {
+			clock, err := d.ReadVarUint()
+			if err != nil {
+				return nil, err
+			}
+			n, err := d.ReadVarUint()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || clock > maxClock || n > maxClock-clock {
+				return nil, fmt.Errorf("%w: delete range %d:%d+%d", ErrMalformedUpdate, client, clock, n)
+			}
+			u.deletes.add(client, clock, n)
+		}
+	}
+	u.deletes.normalize()
+
+	return u, nil
+}
+
+func readStruct(d *Decoder, id ID) (structure, error) {
+	info, err := d.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+
+	switch info & 0x1f {
+	case refGC:
+		n, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		return &gc{id: id, n: int(n)}, nil
+	case refSkip:
+		n, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		return &skip{id: id, n: int(n)}, nil
+	}
+
+	it := &item{id: id}
+	if info&0x80 != 0 {
+		origin, err := readID(d)
+		if err != nil {
+			return nil, err
+		}
+		it.origin = &origin
+	}
+	if info&0x40 != 0 {
+		rightOrigin, err := readID(d)
+		if err != nil {
+			return nil, err
+		}
+		it.rightOrigin = &rightOrigin
+	}
+	if info&0xc0 == 0 {
+		// No neighbours to copy the parent from
+		isRoot, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if isRoot == 1 {
+			key, err := d.ReadVarString()
+			if err != nil {
+				return nil, err
+			}
+			it.parentKey = &key
+		} else {
+			parentID, err := readID(d)
+			if err != nil {
+				return nil, err
+			}
+			it.parentID = &parentID
+		}
+		if info&0x20 != 0 {
+			sub, err := d.ReadVarString()
+			if err != nil {
+				return nil, err
+			}
+			it.parentSub = &sub
+		}
+	}
+
+	if it.content, err = readContent(d, info&0x1f); err != nil {
+		return nil, err
+	}
+	it.n = it.content.length()
+	return it, nil
+}
+
+func readID(d *Decoder) (ID, error) {
+	client, err := d.ReadVarUint()
+	if err != nil {
+		return ID{}, err
+	}
+	clock, err := d.ReadVarUint()
+	if err != nil {
+		return ID{}, err
+	}
+	return ID{Client: client, Clock: clock}, nil
+}
+
+func writeID(e *Encoder, id ID) {
+	e.WriteVarUint(id.Client)
+	e.WriteVarUint(id.Clock)
+}
+
+// EncodeStateAsUpdate encodes everything the holder of state vector sv is
+// missing; a nil sv encodes the whole document
+// Learning: The delete set is always sent in full - it's small, and the
+// other side may not know about deletions of structs it already has
+func (d *Doc) EncodeStateAsUpdate(sv map[uint64]uint64) []byte {
+	clients := make(map[uint64]bool)
+	for client := range d.clients {
+		clients[client] = true
+	}
+	for client := range d.pending {
+		clients[client] = true
+	}
+	sorted := make([]uint64, 0, len(clients))
+	for client := range clients {
+		sorted = append(sorted, client)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	type block struct {
+		client  uint64
+		clock   uint64
+		structs []structure
+		offsets []int
+	}
+	var blocks []block
+	for _, client := range sorted {
+		// Pending structs are included, so nothing received is ever lost
+		structs := append(append([]structure(nil), d.clients[client]...), d.pending[client]...)
+		b := block{client: client}
+		cur := sv[client]
+		started := false
+		for _, s := range structs {
+			start := s.structID().Clock
+			end := start + uint64(s.length())
+			if end <= cur {
+				continue
+			}
+			if !started {
+				b.clock = max(cur, start)
+				cur = b.clock
+				started = true
+			} else if start > cur {
+				b.structs = append(b.structs, &skip{id: ID{Client: client, Clock: cur}, n: int(start - cur)})
+				b.offsets = append(b.offsets, 0)
+				cur = start
+			}
+			b.structs = append(b.structs, s)
+			b.offsets = append(b.offsets, int(cur-start))
+			cur = end
+		}
+		if len(b.structs) > 0 {
+			blocks = append(blocks, b)
+		}
+	}
+
+	e := &Encoder{}
+	e.WriteVarUint(uint64(len(blocks)))
+	for _, b := range blocks {
+		e.WriteVarUint(uint64(len(b.structs)))
+		e.WriteVarUint(b.client)
+		e.WriteVarUint(b.clock)
+		for i, s := range b.structs {
+			s.write(e, b.offsets[i])
+		}
+	}
+
+	ds := d.deleteSet()
+	ds.merge(d.pendingDeletes)
+	writeDeleteSet(e, ds)
+
+	return e.Bytes()
+}
+
+func writeDeleteSet(e *Encoder, ds deleteSet) {
+	clients := make([]uint64, 0, len(ds))
+	for client := range ds {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+
+	e.WriteVarUint(uint64(len(clients)))
+	for _, client := range clients {
+		e.WriteVarUint(client)
+		e.WriteVarUint(uint64(len(ds[client])))
+		for _, r := range ds[client] {
+			e.WriteVarUint(r.clock)
+			e.WriteVarUint(r.n)
+		}
+	}
+}
+
+// EncodeStateVector encodes the document's state vector, as sent in sync step 1
+func (d *Doc) EncodeStateVector() []byte {
+	return EncodeStateVector(d.StateVector())
+}
+
+// EncodeStateVector encodes a state vector
+func EncodeStateVector(sv map[uint64]uint64) []byte {
+	clients := make([]uint64, 0, len(sv))
+	for client := range sv {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+
+	e := &Encoder{}
+	e.WriteVarUint(uint64(len(clients)))
+	for _, client := range clients {
+		e.WriteVarUint(client)
+		e.WriteVarUint(sv[client])
+	}
+	return e.Bytes()
+}
+
+// DecodeStateVector decodes a state vector
+func DecodeStateVector(b []byte) (map[uint64]uint64, error) {
+	d := NewDecoder(b)
+	n, err := d.ReadVarUint()
+	if err != nil {
+		return nil, err
+	}
+	sv := make(map[uint64]uint64, min(n, uint64(d.Remaining())))
+	for i := uint64(0); i < n; i++ {
+		client, err := d.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if sv[client], err = d.ReadVarUint(); err != nil {
+			return nil, err
+		}
+	}
+	return sv, nil
+}
+
+// MergeUpdates combines updates into one update with the same effect
+// Deleted content is garbage collected along the way, so the result is
+// usually much smaller than the sum of its parts
+func MergeUpdates(updates ...[]byte) ([]byte, error) {
+	doc := NewDoc()
+	for i, update := range updates {
+		if err := doc.ApplyUpdate(update); err != nil {
+			return nil, fmt.Errorf("update %d: %w", i, err)
+		}
+	}
+	return doc.EncodeStateAsUpdate(nil), nil
+}